@@ -0,0 +1,27 @@
+package main
+
+import "net/url"
+
+// paramAliases - другие имена параметров поиска: старые или более короткие
+// имена, которые клиенты могут слать при переходе. Канонический параметр
+// важнее, если переданы оба
+var paramAliases = map[string]string{
+	"sort": "order_field",
+	"dir":  "order_by",
+	"q":    "query",
+}
+
+// resolveAliases переносит значения псевдонимов в канонические параметры
+func (q *queryDTO) resolveAliases(values url.Values) {
+	for alias, canonical := range paramAliases {
+		aliasValues, ok := values[alias]
+		if !ok {
+			continue
+		}
+		if values.Has(canonical) {
+			q.warn(alias + " ignored, " + canonical + " is set")
+			continue
+		}
+		values[canonical] = aliasValues
+	}
+}
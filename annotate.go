@@ -0,0 +1,171 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// AgeBucketWidth - ширина возрастной группы для age_buckets=1
+var AgeBucketWidth = 10
+
+// SnippetRadius - сколько символов About по обе стороны от совпадения
+// попадает в Snippet при snippet=1
+var SnippetRadius = 40
+
+// annotateUsers заполняет вычисляемые поля пользователей, запрошенные в params
+func annotateUsers(users []User, params *queryDTO) {
+	for idx := range users {
+		if params.ageBuckets {
+			users[idx].AgeBucket = ageBucket(users[idx].Age, AgeBucketWidth)
+		}
+		if params.ageLabel {
+			users[idx].AgeLabel = ageLabel(users[idx].Age, params.ageLabelLang)
+		}
+		if params.withSortKey && params.orderBy != OrderByAsIs && !params.random && len(params.orderIDs) == 0 {
+			users[idx].SortKey = userSortKey(users[idx], params)
+		}
+		if params.snippet {
+			users[idx].Snippet = aboutSnippet(users[idx].About, params.include, SnippetRadius, params.caseInsensitive)
+		}
+		if params.withSlug {
+			users[idx].Slug = userSlug(users[idx])
+		}
+		if params.offsets {
+			offset := aboutMatchOffset(users[idx].About, params.include, params.caseInsensitive)
+			users[idx].MatchOffset = &offset
+		}
+	}
+}
+
+// ageBucket возвращает группу вида "20-29" для ширины 10
+func ageBucket(age, width int) string {
+	if width <= 0 {
+		width = 1
+	}
+	low := bucketLow(age, width)
+	return strconv.Itoa(low) + "-" + strconv.Itoa(low+width-1)
+}
+
+// bucketLow возвращает начало группы ширины width, в которую попадает value
+func bucketLow(value, width int) int {
+	low := value / width * width
+	if value < 0 && value%width != 0 {
+		low -= width
+	}
+	return low
+}
+
+// Слова "год" для AgeLabel: единственное и множественное число по языкам
+var ageUnits = map[string][2]string{
+	"en": {"year", "years"},
+	"fr": {"an", "ans"},
+	"de": {"Jahr", "Jahre"},
+	"es": {"año", "años"},
+}
+
+// ageLabelLang выбирает язык AgeLabel: lang, затем первый язык из
+// Accept-Language. Неизвестные языки - английский
+func ageLabelLang(lang, acceptLanguage string) string {
+	if lang == "" {
+		lang = strings.TrimSpace(strings.SplitN(acceptLanguage, ",", 2)[0])
+		lang = strings.SplitN(lang, ";", 2)[0]
+	}
+	lang = strings.ToLower(strings.SplitN(lang, "-", 2)[0])
+	if _, ok := ageUnits[lang]; ok || lang == "ru" {
+		return lang
+	}
+	return "en"
+}
+
+// ageLabel возвращает возраст с единицей на языке lang: "26 years", "26 лет"
+func ageLabel(age int, lang string) string {
+	n := strconv.Itoa(age)
+	if lang == "ru" {
+		switch {
+		case age%10 == 1 && age%100 != 11:
+			return n + " год"
+		case age%10 >= 2 && age%10 <= 4 && (age%100 < 12 || age%100 > 14):
+			return n + " года"
+		}
+		return n + " лет"
+	}
+	units := ageUnits[lang]
+	if age == 1 || lang == "fr" && age == 0 {
+		return n + " " + units[0]
+	}
+	return n + " " + units[1]
+}
+
+// aboutSnippet возвращает часть about вокруг первого найденного слова из terms:
+// совпадение и до radius символов с каждой стороны. Пусто, если ни одно
+// слово в about не встречается, например, когда совпало только имя
+func aboutSnippet(about string, terms []string, radius int, caseInsensitive bool) string {
+	text := []rune(about)
+	for _, term := range terms {
+		pos := runeIndex(text, []rune(term), caseInsensitive)
+		if pos < 0 {
+			continue
+		}
+		start := max(pos-radius, 0)
+		end := min(pos+len([]rune(term))+radius, len(text))
+		return string(text[start:end])
+	}
+	return ""
+}
+
+// userSlug возвращает Name в нижнем регистре, где все, кроме букв и цифр,
+// заменено дефисами, и ID в конце: "Boyd Wolf" с ID 0 - "boyd-wolf-0".
+// ID делает slug уникальным и для одинаковых имен
+func userSlug(user User) string {
+	var result strings.Builder
+	for _, word := range strings.FieldsFunc(strings.ToLower(user.Name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		result.WriteString(word)
+		result.WriteByte('-')
+	}
+	result.WriteString(strconv.Itoa(user.ID))
+	return result.String()
+}
+
+// aboutMatchOffset возвращает позицию в символах самого раннего из слов
+// terms в about или -1, если ни одно слово в about не встречается
+func aboutMatchOffset(about string, terms []string, caseInsensitive bool) int {
+	text := []rune(about)
+	result := -1
+	for _, term := range terms {
+		if pos := runeIndex(text, []rune(term), caseInsensitive); pos >= 0 && (result < 0 || pos < result) {
+			result = pos
+		}
+	}
+	return result
+}
+
+// runeIndex ищет sub в text посимвольно, чтобы позиция не зависела от
+// длины символов в байтах. -1, если sub нет
+func runeIndex(text, sub []rune, caseInsensitive bool) int {
+	if len(sub) == 0 {
+		return -1
+	}
+	if caseInsensitive {
+		text = lowerRunes(text)
+		sub = lowerRunes(sub)
+	}
+	for idx := 0; idx+len(sub) <= len(text); idx++ {
+		if slices.Equal(text[idx:idx+len(sub)], sub) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// Посимвольное приведение к нижнему регистру с сохранением длины
+func lowerRunes(runes []rune) []rune {
+	result := make([]rune, len(runes))
+	for idx, r := range runes {
+		result[idx] = unicode.ToLower(r)
+	}
+	return result
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAgeBucket(t *testing.T) {
+	cases := []struct {
+		age, width int
+		expected   string
+	}{
+		{29, 10, "20-29"},
+		{30, 10, "30-39"},
+		{0, 10, "0-9"},
+		{24, 5, "20-24"},
+		{25, 5, "25-29"},
+		{7, 1, "7-7"},
+		{-1, 10, "-10--1"},
+	}
+
+	for _, c := range cases {
+		if bucket := ageBucket(c.age, c.width); bucket != c.expected {
+			t.Errorf("Expected: %s for %v/%v, got: %s", c.expected, c.age, c.width, bucket)
+		}
+	}
+}
+
+func TestAgeBuckets(t *testing.T) {
+	for _, user := range decodeUsers(t, searchRecorder("age_buckets=1&limit=0")) {
+		if user.AgeBucket != ageBucket(user.Age, 10) {
+			t.Errorf("Expected: %s for %v, got: %s", ageBucket(user.Age, 10), user.Age, user.AgeBucket)
+		}
+	}
+
+	// Glenn Jordan (29) и Owen Lynn (30) - на границе групп
+	users := decodeUsers(t, searchRecorder("age_buckets=1&query=Glenn"))
+	if len(users) != 1 || users[0].AgeBucket != "20-29" {
+		t.Errorf("Expected: 20-29, got: %v", users)
+	}
+	users = decodeUsers(t, searchRecorder("age_buckets=1&query=Owen"))
+	if len(users) != 1 || users[0].AgeBucket != "30-39" {
+		t.Errorf("Expected: 30-39, got: %v", users)
+	}
+
+	AgeBucketWidth = 5
+	defer func() { AgeBucketWidth = 10 }()
+	users = decodeUsers(t, searchRecorder("age_buckets=1&query=Glenn"))
+	if len(users) != 1 || users[0].AgeBucket != "25-29" {
+		t.Errorf("Expected: 25-29, got: %v", users)
+	}
+
+	// Без флага поле не отдается
+	if w := searchRecorder("query=Glenn"); strings.Contains(w.Body.String(), "AgeBucket") {
+		t.Errorf("Unexpected AgeBucket: %s", w.Body.String())
+	}
+}
+
+func TestAboutSnippet(t *testing.T) {
+	about := "0123456789 middle 0123456789"
+	cases := []struct {
+		terms    []string
+		radius   int
+		expected string
+	}{
+		{[]string{"middle"}, 3, "89 middle 01"},
+		// У начала и конца текста снипет обрезается по границе
+		{[]string{"0123"}, 5, "012345678"},
+		{[]string{"789"}, 40, about},
+		{[]string{"absent", "middle"}, 1, " middle "},
+		{[]string{"absent"}, 10, ""},
+	}
+	for _, c := range cases {
+		if actual := aboutSnippet(about, c.terms, c.radius, false); actual != c.expected {
+			t.Errorf("Expected: %q for %v, got: %q", c.expected, c.terms, actual)
+		}
+	}
+
+	// Многобайтовые символы не разрезаются
+	if actual := aboutSnippet("ääää Öl ääää", []string{"öl"}, 2, true); actual != "ä Öl ä" {
+		t.Errorf("Expected: %q, got: %q", "ä Öl ä", actual)
+	}
+
+	users := decodeUsers(t, searchRecorder("snippet=1&query=Boyd"))
+	if len(users) != 1 || users[0].Snippet != "" {
+		t.Errorf("Expected empty snippet for name match, got: %v", users)
+	}
+
+	users = decodeUsers(t, searchRecorder("snippet=1&query=voluptate&limit=1"))
+	if len(users) != 1 || !strings.Contains(users[0].Snippet, "voluptate") ||
+		len([]rune(users[0].Snippet)) > len("voluptate")+2*SnippetRadius {
+		t.Errorf("Unexpected snippet: %v", users)
+	}
+}
+
+func TestMatchOffset(t *testing.T) {
+	cases := []struct {
+		about    string
+		terms    []string
+		expected int
+	}{
+		{"Nulla cillum enim", []string{"enim", "cillum"}, 6},
+		{"Nulla cillum enim", []string{"velit"}, -1},
+		{"ääää Öl ääää", []string{"öl"}, 5},
+		{"", []string{"enim"}, -1},
+	}
+	for _, c := range cases {
+		if actual := aboutMatchOffset(c.about, c.terms, true); actual != c.expected {
+			t.Errorf("Expected: %d for %q in %q, got: %d", c.expected, c.terms, c.about, actual)
+		}
+	}
+
+	users := decodeUsers(t, searchRecorder("offsets=1&query=voluptate&limit=1"))
+	if len(users) != 1 || users[0].MatchOffset == nil || *users[0].MatchOffset != 18 {
+		t.Errorf("Expected MatchOffset 18, got: %v", users)
+	}
+
+	users = decodeUsers(t, searchRecorder("offsets=1&query=Boyd"))
+	if len(users) != 1 || users[0].MatchOffset == nil || *users[0].MatchOffset != -1 {
+		t.Errorf("Expected MatchOffset -1 for name match, got: %v", users)
+	}
+
+	users = decodeUsers(t, searchRecorder("query=voluptate&limit=1"))
+	if len(users) != 1 || users[0].MatchOffset != nil {
+		t.Errorf("Expected no MatchOffset without offsets=1, got: %v", users)
+	}
+}
+
+func TestWithSlug(t *testing.T) {
+	cases := []struct {
+		user     User
+		expected string
+	}{
+		{User{ID: 0, Name: "Boyd Wolf"}, "boyd-wolf-0"},
+		{User{ID: 7, Name: "  O'Neil   Smith-Jones "}, "o-neil-smith-jones-7"},
+		{User{ID: 3, Name: "Ёжик Öl"}, "ёжик-öl-3"},
+		{User{ID: 5, Name: ""}, "5"},
+	}
+	for _, c := range cases {
+		if actual := userSlug(c.user); actual != c.expected {
+			t.Errorf("Expected: %q, got: %q", c.expected, actual)
+		}
+	}
+
+	slugPattern := regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*-[0-9]+$`)
+	seen := map[string]bool{}
+	users := decodeUsers(t, searchRecorder("with_slug=1&limit=0"))
+	for _, user := range users {
+		if !slugPattern.MatchString(user.Slug) || !strings.HasSuffix(user.Slug, "-"+strconv.Itoa(user.ID)) || seen[user.Slug] {
+			t.Errorf("Unexpected slug %q for %v", user.Slug, user.ID)
+		}
+		seen[user.Slug] = true
+	}
+	if len(seen) != 35 {
+		t.Errorf("Expected 35 unique slugs, got: %d", len(seen))
+	}
+
+	if users = decodeUsers(t, searchRecorder("query=Boyd")); len(users) != 1 || users[0].Slug != "" {
+		t.Errorf("Expected no slug without with_slug=1, got: %v", users)
+	}
+}
+
+func TestWithSortKey(t *testing.T) {
+	users := decodeUsers(t, searchRecorder("with_sort_key=1&order_field=age&order_by=1&limit=0"))
+	for _, user := range users {
+		if key, ok := user.SortKey.(float64); !ok || int(key) != user.Age {
+			t.Errorf("Expected: SortKey %v for %v, got: %v", user.Age, user.ID, user.SortKey)
+		}
+	}
+
+	users = decodeUsers(t, searchRecorder("with_sort_key=1&order_field=about_len&order_by=1&limit=0"))
+	prev := -1
+	for _, user := range users {
+		length := len([]rune(user.About))
+		if key, ok := user.SortKey.(float64); !ok || int(key) != length {
+			t.Errorf("Expected: SortKey %v for %v, got: %v", length, user.ID, user.SortKey)
+		}
+		if length < prev {
+			t.Errorf("Expected ascending about length, got %v after %v", length, prev)
+		}
+		prev = length
+	}
+
+	// Для relevance - оценка, а не ID
+	users = decodeUsers(t, searchRecorder("with_sort_key=1&query=cillum&order_field=relevance&order_by=1&limit=0"))
+	for _, user := range users {
+		score := strings.Count(user.Name+" "+user.About, "cillum")
+		if key, ok := user.SortKey.(float64); !ok || int(key) != score {
+			t.Errorf("Expected: SortKey %v for %v, got: %v", score, user.ID, user.SortKey)
+		}
+	}
+
+	// Для priority - вес из priority_ids, у остальных 0
+	users = decodeUsers(t, searchRecorder("with_sort_key=1&priority_ids=3:5&limit=2"))
+	if len(users) != 2 || users[0].ID != 3 || users[0].SortKey != 5.0 || users[1].SortKey != 0.0 {
+		t.Errorf("Expected SortKey 5 for 3 and 0 next, got: %v", users)
+	}
+
+	for _, user := range decodeUsers(t, searchRecorder("order_field=age&order_by=1")) {
+		if user.SortKey != nil {
+			t.Errorf("Expected no SortKey without with_sort_key, got: %v", user.SortKey)
+		}
+	}
+}
+
+func TestAgeLabel(t *testing.T) {
+	cases := []struct {
+		query, acceptLanguage, expected string
+	}{
+		{"age_label=1&query=Boyd", "", "22 years"},
+		{"age_label=1&query=Boyd&lang=fr", "", "22 ans"},
+		{"age_label=1&query=Boyd", "de-DE,de;q=0.9", "22 Jahre"},
+		{"age_label=1&query=Boyd&lang=es", "de-DE", "22 años"},
+		{"age_label=1&query=Boyd&lang=ru", "", "22 года"},
+		{"age_label=1&query=Boyd", "ja", "22 years"},
+		{"query=Boyd&lang=fr", "", ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/?"+c.query, nil)
+		req.Header.Set("AccessToken", accessToken)
+		req.Header.Set("Accept-Language", c.acceptLanguage)
+		SearchServer(w, req)
+		users := decodeUsers(t, w)
+		if len(users) != 1 || users[0].AgeLabel != c.expected || users[0].Age != 22 {
+			t.Errorf("%s %s: expected: %q, got: %v", c.query, c.acceptLanguage, c.expected, users)
+		}
+	}
+
+	for age, expected := range map[int]string{1: "1 год", 11: "11 лет", 21: "21 год", 24: "24 года", 25: "25 лет"} {
+		if label := ageLabel(age, "ru"); label != expected {
+			t.Errorf("Expected: %s, got: %s", expected, label)
+		}
+	}
+	if label := ageLabel(1, "en"); label != "1 year" {
+		t.Errorf("Expected: 1 year, got: %s", label)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrorBadBatch отдается /users/batch без списка id или с нечисловыми id
+const ErrorBadBatch = `ids must be a non-empty list of integers`
+
+// batchHandler возвращает пользователей по списку id в порядке запроса:
+// GET /users/batch?ids=1,2,3 или POST /users/batch с телом [1,2,3].
+// Отсутствующие id пропускаются. Остальные параметры (query, fields,
+// format...) работают как в обычном поиске
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ids := query.Get("ids")
+	if r.Method == http.MethodPost {
+		var list []int
+		if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+			sendError(w, http.StatusBadRequest, ErrorBadBatch)
+			return
+		}
+		items := make([]string, len(list))
+		for idx, id := range list {
+			items[idx] = strconv.Itoa(id)
+		}
+		ids = strings.Join(items, ",")
+	}
+	if parsed, err := parseOrderIDs(ids); err != nil || len(parsed) == 0 {
+		sendError(w, http.StatusBadRequest, ErrorBadBatch)
+		return
+	}
+
+	query.Del("ids")
+	query.Set("order_ids", ids)
+	batch := r.Clone(r.Context())
+	batch.URL.RawQuery = query.Encode()
+
+	result, params, ok := searchUsers(w, batch, false)
+	if !ok {
+		return
+	}
+	sendUsers(w, result, params)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	// 999 нет в датасете
+	expected := []int{7, 2, 30}
+	users := decodeUsers(t, recorder("/users/batch", "ids=7,999,2,30"))
+	if ids := userIDs(users); !slices.Equal(ids, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, ids)
+	}
+
+	users = decodeUsers(t, serveRequest(httptest.NewRequest("POST", "/users/batch", strings.NewReader("[7, 999, 2, 30]"))))
+	if ids := userIDs(users); !slices.Equal(ids, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, ids)
+	}
+
+	for _, query := range []string{"", "ids=", "ids=1,x"} {
+		if w := recorder("/users/batch", query); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected: %d for %q, got: %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+	if w := serveRequest(httptest.NewRequest("POST", "/users/batch", strings.NewReader(`{"ids":1}`))); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestBatchCount(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	reqs := []SearchRequest{
+		{Query: "Boyd"},
+		{Query: "voluptate", Limit: 1},
+		{Query: "nobody-matches-this"},
+		{Extra: map[string]string{"gender": "female"}},
+	}
+	expected := make([]int, len(reqs))
+	for idx, req := range reqs {
+		req.Limit = 0
+		expected[idx] = len(decodeUsers(t, searchRecorder(ts.client.queryParams(req).Encode())))
+	}
+
+	counts, err := ts.client.BatchCount(reqs)
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if !slices.Equal(counts, expected) || counts[0] != 1 || counts[2] != 0 {
+		t.Errorf("Expected: %v, got: %v", expected, counts)
+	}
+
+	_, err = ts.client.BatchCount([]SearchRequest{{Query: "Boyd"}, {OrderField: "password", OrderBy: OrderByAsc}})
+	if err == nil || !strings.Contains(err.Error(), "request 1: "+ErrorBadOrderField) {
+		t.Errorf("Expected error for request 1, got: %v", err)
+	}
+
+	// Несжатое тело тоже принимается
+	req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`[{"Query":"Boyd"}]`))
+	req.Header.Set("AccessToken", accessToken)
+	w := httptest.NewRecorder()
+	SearchServer(w, req)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[1]" {
+		t.Errorf("Expected [1], got: %d %s", w.Code, w.Body.String())
+	}
+
+	// Тело с Content-Encoding: gzip, но не сжатое
+	req = httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`[{"Query":"Boyd"}]`))
+	req.Header.Set("AccessToken", accessToken)
+	req.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	SearchServer(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	from := map[int]bool{}
+	for _, user := range decodeUsers(t, searchRecorder("limit=0&query=voluptate")) {
+		from[user.ID] = true
+	}
+	var added, removed []int
+	common := 0
+	to := map[int]bool{}
+	for _, user := range decodeUsers(t, searchRecorder("limit=0&query=nostrud")) {
+		to[user.ID] = true
+		if from[user.ID] {
+			common++
+		} else {
+			added = append(added, user.ID)
+		}
+	}
+	for id := range from {
+		if !to[id] {
+			removed = append(removed, id)
+		}
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+	if common == 0 || len(added) == 0 || len(removed) == 0 {
+		t.Fatalf("Expected overlapping queries, got: %d common, %v added, %v removed", common, added, removed)
+	}
+
+	diff, err := ts.client.Diff(SearchRequest{Query: "voluptate", Limit: 1}, SearchRequest{Query: "nostrud"})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	if !slices.Equal(diff.Added, added) || !slices.Equal(diff.Removed, removed) {
+		t.Errorf("Expected: %v %v, got: %v %v", added, removed, diff.Added, diff.Removed)
+	}
+
+	_, err = ts.client.Diff(SearchRequest{}, SearchRequest{OrderField: "password", OrderBy: OrderByAsc})
+	if err == nil || !strings.Contains(err.Error(), "to: "+ErrorBadOrderField) {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
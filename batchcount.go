@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// ErrorBadBatchCount отдается /batch, если тело не является JSON-массивом
+// SearchRequest или не распаковывается
+const ErrorBadBatchCount = `body must be a JSON array of search requests`
+
+// MaxBatchCount - сколько запросов можно передать в /batch за раз
+var MaxBatchCount = 100
+
+// countWriter запоминает ответ searchUsers для одного запроса из /batch
+type countWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *countWriter) Header() http.Header         { return w.header }
+func (w *countWriter) WriteHeader(status int)      { w.status = status }
+func (w *countWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+
+// batchCountHandler принимает POST /batch с JSON-массивом SearchRequest, в
+// том числе сжатым gzip (Content-Encoding: gzip), и отдает массив чисел
+// найденных пользователей в том же порядке. Limit и Offset не учитываются.
+// Ошибка любого запроса отдается как 400 с его номером
+func batchCountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	body := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, ErrorBadBatchCount)
+			return
+		}
+		// Распакованное тело ограничено так же, как сжатое
+		body = http.MaxBytesReader(w, gz, MaxBodyBytes)
+	}
+
+	var reqs []SearchRequest
+	if err := json.NewDecoder(body).Decode(&reqs); err != nil {
+		sendError(w, http.StatusBadRequest, ErrorBadBatchCount)
+		return
+	}
+	if len(reqs) > MaxBatchCount {
+		sendError(w, http.StatusBadRequest, "too many requests in batch, max "+strconv.Itoa(MaxBatchCount))
+		return
+	}
+
+	counts := make([]int, len(reqs))
+	for idx, req := range reqs {
+		result, ok := searchAll(w, r, req, fmt.Sprintf("request %d", idx))
+		if !ok {
+			return
+		}
+		counts[idx] = len(result)
+	}
+
+	sendResponse(w, counts)
+}
+
+// searchAll выполняет req в рамках запроса r без Limit и Offset и
+// возвращает всех найденных. Ошибка поиска отправляется в w с префиксом
+// name, тогда ok == false
+func searchAll(w http.ResponseWriter, r *http.Request, req SearchRequest, name string) ([]User, bool) {
+	req.Limit, req.Offset = 0, 0
+	item := r.Clone(r.Context())
+	item.Method = http.MethodGet
+	// Параметры собираются так же, как у клиента без опций
+	item.URL.RawQuery = (&SearchClient{}).queryParams(req).Encode()
+
+	cw := &countWriter{header: http.Header{}, status: http.StatusOK}
+	result, _, ok := searchUsers(cw, item, false)
+	if !ok {
+		errResp := SearchErrorResponse{Error: cw.body.String()}
+		json.Unmarshal(cw.body.Bytes(), &errResp) //nolint:errcheck
+		sendError(w, cw.status, name+": "+errResp.Error)
+		return nil, false
+	}
+	return result, true
+}
+
+// BatchCount отправляет запросы одним сжатым POST /batch и возвращает число
+// найденных пользователей по каждому в том же порядке
+func (srv *SearchClient) BatchCount(reqs []SearchRequest) ([]int, error) {
+	payload, err := json.Marshal(reqs)
+	if err != nil {
+		return nil, err
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err = gz.Write(payload); err != nil {
+		return nil, err
+	}
+	if err = gz.Close(); err != nil {
+		return nil, err
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, srv.endpoint("batch"), &compressed) //nolint:errcheck
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := srv.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unknown error %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body) //nolint:errcheck
+
+	if resp.StatusCode == http.StatusBadRequest {
+		errResp := SearchErrorResponse{}
+		if err = json.Unmarshal(body, &errResp); err != nil {
+			return nil, fmt.Errorf("cant unpack error json: %s", err)
+		}
+		return nil, fmt.Errorf("bad batch request: %s", errResp.Error)
+	}
+	if err = countStatusError(resp.StatusCode); err != nil {
+		return nil, err
+	}
+
+	var counts []int
+	if err = json.Unmarshal(body, &counts); err != nil {
+		return nil, fmt.Errorf("cant unpack result json: %s", err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+)
+
+// responseCache хранит успешные ответы FindUsers до истечения ttl
+type responseCache struct {
+	ttl time.Duration
+	// Текущее время, подменяется в тестах
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	resp    SearchResponse
+	expires time.Time
+}
+
+// WithCache включает кеш ответов FindUsers на ttl. Ключ - запрос целиком,
+// ошибки не кешируются. Кеш общий для копий клиента и безопасен для
+// конкурентного использования
+func WithCache(ttl time.Duration) ClientOption {
+	return func(srv *SearchClient) {
+		srv.cache = &responseCache{ttl: ttl, now: time.Now, entries: map[string]cacheEntry{}}
+	}
+}
+
+// Ключ кеша для запроса: JSON с отсортированными ключами Extra
+func cacheKey(req SearchRequest) string {
+	b, _ := json.Marshal(req) //nolint:errcheck
+	return string(b)
+}
+
+// get возвращает копию закешированного ответа, если он еще не истек
+func (c *responseCache) get(key string) (*SearchResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	resp := entry.resp
+	resp.Users = slices.Clone(resp.Users)
+	return &resp, true
+}
+
+// put сохраняет копию ответа, чтобы изменения вызывающего не попали в кеш
+func (c *responseCache) put(key string, resp *SearchResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored := *resp
+	stored.Users = slices.Clone(resp.Users)
+	c.entries[key] = cacheEntry{resp: stored, expires: c.now().Add(c.ttl)}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientCache(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		SearchServer(w, r)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	client := NewSearchClient(accessToken, server.URL, WithCache(time.Minute))
+	client.cache.now = func() time.Time { return now }
+
+	req := SearchRequest{Query: "Boyd", Limit: 5, Extra: map[string]string{"gender": "male"}}
+	first, err := client.FindUsers(req)
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	first.Users[0].Name = "changed"
+
+	second, err := client.FindUsers(req)
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if requests.Load() != 1 {
+		t.Errorf("Expected cache hit, got %d requests", requests.Load())
+	}
+	if len(second.Users) != 1 || second.Users[0].Name != "Boyd Wolf" {
+		t.Errorf("Expected cached copy, got: %v", second.Users)
+	}
+
+	// Другой запрос - другой ключ
+	if _, err = client.FindUsers(SearchRequest{Query: "Hilda"}); err != nil || requests.Load() != 2 {
+		t.Errorf("Expected new request, got %d requests, %v", requests.Load(), err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err = client.FindUsers(req); err != nil || requests.Load() != 3 {
+		t.Errorf("Expected refetch after ttl, got %d requests, %v", requests.Load(), err)
+	}
+}
+
+func TestClientCacheErrors(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		SearchServer(w, r)
+	}))
+	defer server.Close()
+
+	client := NewSearchClient(accessToken, server.URL, WithCache(time.Minute))
+	for i := 0; i < 2; i++ {
+		if _, err := client.FindUsers(SearchRequest{OrderField: "height", OrderBy: OrderByAsc}); err == nil {
+			t.Errorf("Expected error")
+		}
+	}
+	if requests.Load() != 2 {
+		t.Errorf("Expected errors not to be cached, got %d requests", requests.Load())
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Capabilities описывает возможности сервера, чтобы клиент не отправлял
+// неподдерживаемые параметры
+type Capabilities struct {
+	SortEnabled bool
+	// Ограничение limit сверху, 0 - без ограничения
+	MaxPageSize  int
+	SearchFields []string
+	MatchModes   []string
+	OrderFields  []string
+}
+
+// Возможности сервера с настройками cfg
+func currentCapabilities(cfg Config) Capabilities {
+	return Capabilities{
+		SortEnabled:  cfg.AllowSort,
+		MaxPageSize:  cfg.MaxLimit,
+		SearchFields: searchFields,
+		MatchModes:   matchModes,
+		OrderFields:  orderFields,
+	}
+}
+
+// capabilitiesHandler отдает документ с возможностями сервера
+func capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, currentCapabilities(serverConfig(r)))
+}
+
+// Адрес дополнительного эндпоинта сервера
+func (srv *SearchClient) endpoint(name string) string {
+	endpoint, err := url.JoinPath(srv.URL, name)
+	if err != nil {
+		return srv.URL
+	}
+	return endpoint
+}
+
+// Capabilities запрашивает у сервера поддерживаемые возможности
+func (srv *SearchClient) Capabilities() (Capabilities, error) {
+	result := Capabilities{}
+
+	req, _ := http.NewRequest("GET", srv.endpoint("capabilities"), nil) //nolint:errcheck
+
+	resp, err := srv.do(req)
+	if err != nil {
+		return result, fmt.Errorf("unknown error %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body) //nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return result, fmt.Errorf("bad AccessToken")
+	default:
+		return result, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return result, fmt.Errorf("cant unpack result json: %s", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	caps, err := ts.client.Capabilities()
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if !caps.SortEnabled {
+		t.Errorf("Expected: %v, got: %v", true, caps.SortEnabled)
+	}
+	if caps.MaxPageSize != MaxLimit {
+		t.Errorf("Expected: %v, got: %v", MaxLimit, caps.MaxPageSize)
+	}
+	if !slices.Contains(caps.MatchModes, matchModeSoundex) {
+		t.Errorf("Expected %s in %v", matchModeSoundex, caps.MatchModes)
+	}
+	if !slices.Contains(caps.SearchFields, searchFieldInitials) {
+		t.Errorf("Expected %s in %v", searchFieldInitials, caps.SearchFields)
+	}
+
+	AllowSort = false
+	defer func() { AllowSort = true }()
+
+	caps, err = ts.client.Capabilities()
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if caps.SortEnabled {
+		t.Errorf("Expected: %v, got: %v", false, caps.SortEnabled)
+	}
+}
+
+// Сервер из NewServer отдает свои настройки, а не глобальные
+func TestCapabilitiesConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AllowSort, cfg.MaxLimit = false, 5
+	server := httptest.NewServer(NewServer(cfg))
+	defer server.Close()
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
+
+	caps, err := client.Capabilities()
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if caps.SortEnabled || caps.MaxPageSize != 5 {
+		t.Errorf("Expected sort disabled and max page size 5, got: %+v", caps)
+	}
+}
+
+// Каждое заявленное поле сортировки действительно поддерживается
+func TestCapabilitiesOrderFields(t *testing.T) {
+	for _, field := range currentCapabilities(DefaultConfig()).OrderFields {
+		if _, err := compareFunc(field, &queryDTO{}); err != nil {
+			t.Errorf("Order field %q: %v", field, err)
+		}
+	}
+}
+
+func TestCapabilitiesBadAccessToken(t *testing.T) {
+	ts := newTestServer(accessToken + "invalid")
+	defer ts.Close()
+
+	if _, err := ts.client.Capabilities(); err == nil || err.Error() != "bad AccessToken" {
+		t.Errorf("Invalid error: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
+	if _, err := client.Capabilities(); err == nil {
+		t.Error("Expected error for unsupported server")
+	}
+}
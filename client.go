@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,7 +11,9 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -30,11 +35,34 @@ type User struct {
 	Age    int
 	About  string
 	Gender string
+	// Необязательные поля, пустые, если их нет в данных
+	City    string `json:",omitempty"`
+	Country string `json:",omitempty"`
+	Handle  string `json:",omitempty"`
+	Email   string `json:",omitempty"`
+	Phone   string `json:",omitempty"`
+	// Возрастная группа вида "20-29", заполняется по запросу
+	AgeBucket string `json:",omitempty"`
+	// Возраст для показа на языке запроса, например "26 ans", заполняется
+	// по запросу age_label=1
+	AgeLabel string `json:",omitempty"`
+	// Часть About вокруг совпадения с запросом, заполняется по запросу
+	Snippet string `json:",omitempty"`
+	// Позиция первого совпадения с запросом в About в символах, -1, если
+	// совпало другое поле. Заполняется по запросу offsets=1
+	MatchOffset *int `json:",omitempty"`
+	// Имя для адресов вида "boyd-wolf-0", заполняется по запросу with_slug=1
+	Slug string `json:",omitempty"`
+	// Значение поля сортировки, заполняется по запросу with_sort_key=1
+	SortKey interface{} `json:",omitempty"`
 }
 
 type SearchResponse struct {
 	Users    []User
 	NextPage bool
+	// Сколько всего пользователей подошло под запрос без учета Limit и Offset,
+	// по X-Rows-Matched. -1 - если сервер его не отдал, например при NoTotal
+	TotalCount int
 }
 
 type SearchErrorResponse struct {
@@ -42,13 +70,20 @@ type SearchErrorResponse struct {
 }
 
 const (
-	OrderByAsc  = 1
+	OrderByAsc = 1
+	// Без сортировки: пользователи идут строго в порядке элементов <row> в файле
 	OrderByAsIs = 0
 	OrderByDesc = -1
 
 	ErrorBadOrderField = `OrderField invalid`
+
+	// Максимальный размер страницы, больший Limit урезается
+	MaxPageSize = 25
 )
 
+// OrderDirection - направление сортировки: OrderByAsc, OrderByAsIs или OrderByDesc
+type OrderDirection int
+
 type SearchRequest struct {
 	Limit      int
 	Offset     int    // Можно учесть после сортировки
@@ -56,6 +91,15 @@ type SearchRequest struct {
 	OrderField string
 	//  1 по возрастанию, 0 как встретилось, -1 по убыванию
 	OrderBy int
+	// Только пользователи с ID больше SinceID по возрастанию ID, nil - все.
+	// OrderField и OrderBy при этом сервером не учитываются
+	SinceID *int
+	// Не запрашивать лишнюю запись для NextPage: NextPage всегда false,
+	// зато сервер без сортировки останавливает поиск на последней записи страницы
+	NoTotal bool
+	// Дополнительные параметры запроса для новых возможностей сервера.
+	// Основные параметры (limit, offset, query, order_field, order_by) ими не перезаписываются
+	Extra map[string]string
 }
 
 type SearchClient struct {
@@ -63,41 +107,212 @@ type SearchClient struct {
 	AccessToken string
 	// урл внешней системы, куда идти
 	URL string
+	// TokenProvider выдает свежий токен, когда сервер отвечает 401 на AccessToken.
+	// Полученный токен сохраняется в AccessToken, запрос повторяется один раз.
+	// Если nil, используется только AccessToken. Обновление токена не
+	// синхронизировано, при конкурентных запросах провайдер должен это учитывать
+	TokenProvider func() (string, error)
+	// http-клиент для запросов, если nil - используется общий client
+	httpClient *http.Client
+	// Поиск без учета регистра, см. WithCaseInsensitive
+	caseInsensitive bool
+	// Ответы в gob вместо JSON, см. WithGob
+	gob bool
+	// Журнал запросов FindUsers, см. WithDebugLogger. nil - без журнала
+	debugLog io.Writer
+	// Кеш ответов FindUsers, см. WithCache. nil - без кеша
+	cache *responseCache
+	// OnDuplicate вызывается для каждого повторно полученного пользователя,
+	// которого FindAllUsers пропустил. Если nil, повторы пропускаются молча
+	OnDuplicate func(User)
 }
 
-// FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользователей
-func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+// ClientOption настраивает SearchClient при создании через NewSearchClient
+type ClientOption func(*SearchClient)
 
-	searcherParams := url.Values{}
+// NewSearchClient создает клиента и применяет к нему опции
+func NewSearchClient(accessToken, url string, opts ...ClientOption) *SearchClient {
+	srv := &SearchClient{AccessToken: accessToken, URL: url}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	return srv
+}
 
-	if req.Limit < 0 {
-		return nil, fmt.Errorf("limit must be > 0")
+// WithCaseInsensitive включает поиск без учета регистра: Query отправляется
+// в нижнем регистре вместе с case_insensitive=1. Нужна для старых серверов:
+// новые ищут без учета регистра и без этой опции
+func WithCaseInsensitive() ClientOption {
+	return func(srv *SearchClient) {
+		srv.caseInsensitive = true
 	}
-	if req.Limit > 25 {
-		req.Limit = 25
+}
+
+// WithGob запрашивает пользователей в gob (format=gob) вместо JSON. Только
+// для серверов на Go с этой же структурой User; fields= в gob не учитывается
+func WithGob() ClientOption {
+	return func(srv *SearchClient) {
+		srv.gob = true
 	}
-	if req.Offset < 0 {
-		return nil, fmt.Errorf("offset must be > 0")
+}
+
+// WithDebugLogger пишет в w строку на каждый запрос FindUsers: URL,
+// статус ответа и время. AccessToken в журнал не попадает
+func WithDebugLogger(w io.Writer) ClientOption {
+	return func(srv *SearchClient) {
+		srv.debugLog = w
 	}
+}
 
-	// нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
-	req.Limit++
+// logRequest пишет запрос в журнал WithDebugLogger. status - код ответа
+// или текст ошибки, если ответа нет
+func (srv *SearchClient) logRequest(target, status string, start time.Time) {
+	if srv.debugLog == nil {
+		return
+	}
+	line := fmt.Sprintf("GET %s -> %s in %s\n", target, status, time.Since(start))
+	if srv.AccessToken != "" {
+		line = strings.ReplaceAll(line, srv.AccessToken, "REDACTED")
+	}
+	_, _ = io.WriteString(srv.debugLog, line) //nolint:errcheck
+}
+
+// http-клиент, через который идут запросы
+func (srv *SearchClient) doer() *http.Client {
+	if srv.httpClient != nil {
+		return srv.httpClient
+	}
+	return client
+}
+
+// do отправляет req с AccessToken. На 401 при заданном TokenProvider
+// получает новый токен и повторяет запрос один раз
+func (srv *SearchClient) do(req *http.Request) (*http.Response, error) {
+	if srv.AccessToken == "" && srv.TokenProvider != nil {
+		if err := srv.refreshToken(); err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Set("AccessToken", srv.AccessToken)
+
+	resp, err := srv.doer().Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || srv.TokenProvider == nil {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	if err = srv.refreshToken(); err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	retry.Header.Set("AccessToken", srv.AccessToken)
+	return srv.doer().Do(retry)
+}
+
+// refreshToken запрашивает новый токен у TokenProvider
+func (srv *SearchClient) refreshToken() error {
+	token, err := srv.TokenProvider()
+	if err != nil {
+		return fmt.Errorf("cant refresh AccessToken: %w", err)
+	}
+	srv.AccessToken = token
+	return nil
+}
+
+// queryParams собирает параметры запроса к серверу из req
+func (srv *SearchClient) queryParams(req SearchRequest) url.Values {
+	searcherParams := url.Values{}
+
+	if srv.caseInsensitive {
+		req.Query = strings.ToLower(req.Query)
+		searcherParams.Add("case_insensitive", "1")
+	}
 
 	searcherParams.Add("limit", strconv.Itoa(req.Limit))
 	searcherParams.Add("offset", strconv.Itoa(req.Offset))
 	searcherParams.Add("query", req.Query)
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+	if req.NoTotal {
+		searcherParams.Add("no_total", "1")
+	}
+	if req.SinceID != nil {
+		searcherParams.Add("since_id", strconv.Itoa(*req.SinceID))
+	}
+	if srv.gob {
+		searcherParams.Add("format", "gob")
+	}
+	for key, value := range req.Extra {
+		if searcherParams.Has(key) {
+			continue
+		}
+		searcherParams.Add(key, value)
+	}
+	return searcherParams
+}
+
+// FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользователей
+func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
+
+// FindUsersContext - FindUsers с контекстом: отмена ctx или истечение его
+// дедлайна прерывает запрос к серверу
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
+	if srv.cache == nil {
+		return srv.findUsers(ctx, req)
+	}
+
+	key := cacheKey(req)
+	if resp, ok := srv.cache.get(key); ok {
+		return resp, nil
+	}
+	resp, err := srv.findUsers(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	srv.cache.put(key, resp)
+	return resp, nil
+}
+
+// findUsers выполняет запрос FindUsers без кеша
+func (srv *SearchClient) findUsers(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
+	if req.Limit < 0 {
+		return nil, fmt.Errorf("limit must be > 0")
+	}
+	if req.Limit > MaxPageSize {
+		req.Limit = MaxPageSize
+	}
+	if req.Offset < 0 {
+		return nil, fmt.Errorf("offset must be > 0")
+	}
 
-	searcherReq, _ := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil) //nolint:errcheck
-	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	// нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет.
+	// Limit 0 означает "без ограничения", следующей страницы тогда нет
+	if req.Limit > 0 && !req.NoTotal {
+		req.Limit++
+	}
+
+	searcherParams := srv.queryParams(req)
+	target := srv.URL + "?" + searcherParams.Encode()
+	searcherReq, _ := http.NewRequestWithContext(ctx, "GET", target, nil) //nolint:errcheck
 
-	resp, err := client.Do(searcherReq)
+	start := time.Now()
+	resp, err := srv.do(searcherReq)
+	if err != nil {
+		srv.logRequest(target, "error: "+err.Error(), start)
+	} else {
+		srv.logRequest(target, resp.Status, start)
+	}
 	if err != nil {
+		// ошибка контекста тоже net.Error с Timeout(), поэтому проверяем его первым
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("request cancelled for %s: %w", searcherParams.Encode(), ctxErr)
+		}
 		if err, ok := err.(net.Error); ok && err.Timeout() {
 			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
 		}
-		return nil, fmt.Errorf("unknown error %s", err)
+		return nil, fmt.Errorf("unknown error %w", err)
 	}
 	defer resp.Body.Close()
 	body, _ := io.ReadAll(resp.Body) //nolint:errcheck
@@ -107,6 +322,8 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 		return nil, fmt.Errorf("bad AccessToken")
 	case http.StatusInternalServerError:
 		return nil, fmt.Errorf("SearchServer fatal error")
+	case http.StatusServiceUnavailable:
+		return nil, newUnavailableError(resp, body)
 	case http.StatusBadRequest:
 		errResp := SearchErrorResponse{}
 		err = json.Unmarshal(body, &errResp)
@@ -119,14 +336,16 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
 	}
 
-	data := []User{}
-	err = json.Unmarshal(body, &data)
+	data, err := decodeUsersBody(resp.Header.Get("Content-Type"), body)
 	if err != nil {
 		return nil, fmt.Errorf("cant unpack result json: %s", err)
 	}
 
-	result := SearchResponse{}
-	if len(data) == req.Limit {
+	result := SearchResponse{TotalCount: -1}
+	if matched, err := strconv.Atoi(resp.Header.Get("X-Rows-Matched")); err == nil {
+		result.TotalCount = matched
+	}
+	if req.Limit > 0 && len(data) == req.Limit && !req.NoTotal {
 		result.NextPage = true
 		result.Users = data[0 : len(data)-1]
 	} else {
@@ -136,3 +355,253 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 	return &result, err
 }
 
+// UnavailableError возвращается, когда сервер ответил 503: перегружен или
+// не уложился в StageTimeouts
+type UnavailableError struct {
+	// Текст ошибки от сервера
+	Message string
+	// Через сколько можно повторить запрос по Retry-After, 0 - если сервер его не прислал
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("server unavailable: %s, retry after %s", e.Message, e.RetryAfter)
+	}
+	return "server unavailable: " + e.Message
+}
+
+// newUnavailableError разбирает ответ 503. Retry-After бывает числом секунд
+// или HTTP-датой
+func newUnavailableError(resp *http.Response, body []byte) *UnavailableError {
+	errResp := SearchErrorResponse{}
+	if json.Unmarshal(body, &errResp) != nil {
+		errResp.Error = strings.TrimSpace(string(body))
+	}
+	result := &UnavailableError{Message: errResp.Error}
+
+	retryAfter := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		result.RetryAfter = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(retryAfter); err == nil && time.Until(date) > 0 {
+		result.RetryAfter = time.Until(date)
+	}
+	return result
+}
+
+// TopN возвращает первых n пользователей по полю field в направлении direction.
+// n больше MaxPageSize урезается так же, как Limit в FindUsers
+func (srv *SearchClient) TopN(field string, direction OrderDirection, n int) ([]User, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be > 0")
+	}
+
+	resp, err := srv.FindUsers(SearchRequest{OrderField: field, OrderBy: int(direction), Limit: n})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Users, nil
+}
+
+// decodeUsersBody разбирает пользователей из массива (v=1), из data (v=2)
+// или из gob и protobuf, если сервер ответил в них (см. WithGob)
+func decodeUsersBody(contentType string, body []byte) ([]User, error) {
+	data := []User{}
+	if strings.HasPrefix(contentType, gobContentType) {
+		err := gob.NewDecoder(bytes.NewReader(body)).Decode(&data)
+		return data, err
+	}
+	if strings.HasPrefix(contentType, protobufContentType) {
+		return unmarshalUsersProto(body)
+	}
+	if trimmed := strings.TrimSpace(string(body)); !strings.HasPrefix(trimmed, "{") {
+		err := json.Unmarshal(body, &data)
+		return data, err
+	}
+
+	envelope := struct {
+		Version int    `json:"version"`
+		Data    []User `json:"data"`
+	}{Data: data}
+	err := json.Unmarshal(body, &envelope)
+	return envelope.Data, err
+}
+
+// CountFast возвращает число пользователей, подходящих под req, без загрузки
+// самих пользователей: HEAD-запрос и заголовок X-Rows-Matched. Limit, Offset
+// и сортировка не учитываются. Если сервер не отдает заголовок, пользователи
+// загружаются GET-запросом без ограничения и считаются
+func (srv *SearchClient) CountFast(req SearchRequest) (int, error) {
+	req.Limit, req.Offset, req.OrderBy = 0, 0, OrderByAsIs
+	target := srv.URL + "?" + srv.queryParams(req).Encode()
+
+	headReq, _ := http.NewRequest(http.MethodHead, target, nil) //nolint:errcheck
+	resp, err := srv.do(headReq)
+	if err != nil {
+		return 0, fmt.Errorf("unknown error %w", err)
+	}
+	resp.Body.Close()
+	if err = countStatusError(resp.StatusCode); err != nil {
+		return 0, err
+	}
+	if matched := resp.Header.Get("X-Rows-Matched"); matched != "" {
+		if count, err := strconv.Atoi(matched); err == nil {
+			return count, nil
+		}
+	}
+
+	getReq, _ := http.NewRequest(http.MethodGet, target, nil) //nolint:errcheck
+	resp, err = srv.do(getReq)
+	if err != nil {
+		return 0, fmt.Errorf("unknown error %w", err)
+	}
+	defer resp.Body.Close()
+	if err = countStatusError(resp.StatusCode); err != nil {
+		return 0, err
+	}
+	body, _ := io.ReadAll(resp.Body) //nolint:errcheck
+	users, err := decodeUsersBody(resp.Header.Get("Content-Type"), body)
+	if err != nil {
+		return 0, fmt.Errorf("cant unpack result json: %s", err)
+	}
+	return len(users), nil
+}
+
+// Ошибка для неуспешного ответа на запрос CountFast. У HEAD нет тела,
+// поэтому причина 400 неизвестна
+func countStatusError(status int) error {
+	switch status {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return fmt.Errorf("bad AccessToken")
+	case http.StatusInternalServerError:
+		return fmt.Errorf("SearchServer fatal error")
+	default:
+		return fmt.Errorf("unexpected status %d", status)
+	}
+}
+
+// FindAllUsers проходит все страницы выдачи по MaxPageSize, начиная с req.Offset,
+// и возвращает всех найденных пользователей. Если данные меняются во время
+// обхода, страницы сдвигаются, и пользователь может прийти повторно: такие
+// повторы по ID пропускаются, см. OnDuplicate
+func (srv *SearchClient) FindAllUsers(req SearchRequest) ([]User, error) {
+	req.Limit = MaxPageSize
+
+	var (
+		result = []User{}
+		seen   = map[int]bool{}
+	)
+	for {
+		resp, err := srv.FindUsers(req)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range resp.Users {
+			if seen[user.ID] {
+				if srv.OnDuplicate != nil {
+					srv.OnDuplicate(user)
+				}
+				continue
+			}
+			seen[user.ID] = true
+			result = append(result, user)
+		}
+		if !resp.NextPage || len(resp.Users) == 0 {
+			return result, nil
+		}
+		req.Offset += len(resp.Users)
+	}
+}
+
+// Paginator запрашивает страницы поиска по одной, см. SearchClient.Paginate
+type Paginator struct {
+	srv  *SearchClient
+	req  SearchRequest
+	done bool
+}
+
+// Paginate возвращает Paginator по req, начиная с req.Offset. Limit 0 или
+// больше MaxPageSize заменяется на MaxPageSize
+func (srv *SearchClient) Paginate(req SearchRequest) *Paginator {
+	if req.Limit == 0 || req.Limit > MaxPageSize {
+		req.Limit = MaxPageSize
+	}
+	return &Paginator{srv: srv, req: req}
+}
+
+// Next возвращает очередную страницу и true, если за ней есть следующая.
+// После последней страницы возвращает nil и false. При ошибке offset не
+// сдвигается, и Next можно вызвать повторно
+func (p *Paginator) Next() ([]User, bool, error) {
+	if p.done {
+		return nil, false, nil
+	}
+
+	resp, err := p.srv.FindUsers(p.req)
+	if err != nil {
+		return nil, false, err
+	}
+	p.req.Offset += p.req.Limit
+	p.done = !resp.NextPage
+	return resp.Users, resp.NextPage, nil
+}
+
+// FindUsersBy возвращает страницу FindUsers по req в виде map по значению
+// поля keyField как строки: "id" дает ключи "0", "1"..., "name" - имена.
+// keyField задается так же, как в fields=. Если у двух пользователей
+// одинаковый ключ, возвращается ошибка
+func (srv *SearchClient) FindUsersBy(req SearchRequest, keyField string) (map[string]User, error) {
+	key, ok := userFields[keyField]
+	if !ok {
+		return nil, fmt.Errorf("unknown key field %q", keyField)
+	}
+
+	resp, err := srv.FindUsers(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]User, len(resp.Users))
+	for _, user := range resp.Users {
+		value, ok := userKey(user, key)
+		if !ok {
+			return nil, fmt.Errorf("user %d has no %s", user.ID, keyField)
+		}
+		if prev, ok := result[value]; ok {
+			return nil, fmt.Errorf("duplicate %s %q for users %d and %d", keyField, value, prev.ID, user.ID)
+		}
+		result[value] = user
+	}
+	return result, nil
+}
+
+// userKey возвращает значение поля name пользователя как строку. Поле-указатель
+// разыменовывается, для nil возвращается false
+func userKey(user User, name string) (string, bool) {
+	value := reflect.ValueOf(user).FieldByName(name)
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return "", false
+		}
+		value = value.Elem()
+	}
+	return fmt.Sprint(value.Interface()), true
+}
+
+// FindFirst возвращает первого пользователя, подходящего под req, и false,
+// если таких нет. Запрашивается одна запись без признака следующей страницы,
+// так что сервер без сортировки останавливает поиск на первом совпадении
+func (srv *SearchClient) FindFirst(req SearchRequest) (*User, bool, error) {
+	req.Limit, req.NoTotal = 1, true
+
+	resp, err := srv.FindUsers(req)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(resp.Users) == 0 {
+		return nil, false, nil
+	}
+	return &resp.Users[0], true, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Буквы, которые в скандинавских языках идут после z, в порядке алфавита
+const (
+	swedishTail   = "åäö"
+	norwegianTail = "æøå"
+)
+
+// Буквы, которые в испанском идут сразу после своей базовой: ñ после n
+const spanishSeparate = "ñ"
+
+// Языки, для которых правила collator совпадают с алфавитом. Для остальных
+// (польский, чешский, турецкий и другие со своими буквами) упрощенные
+// правила дали бы неверный порядок, поэтому имена сравниваются побайтово
+var collatedLanguages = map[string]bool{
+	"en": true, "de": true, "fr": true, "it": true, "pt": true, "nl": true,
+	"es": true, "sv": true, "fi": true, "da": true, "nb": true, "nn": true, "no": true,
+}
+
+// Разложение букв с диакритикой на базовую букву
+var baseLetters = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a",
+	'æ': "ae", 'ç': "c", 'č': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ñ': "n", 'ń': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o",
+	'ř': "r", 'š': "s", 'ß': "ss",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u",
+	'ý': "y", 'ÿ': "y", 'ž': "z",
+}
+
+// foldText приводит строку к нижнему регистру и убирает диакритику:
+// "Muñoz" -> "munoz". Остальные символы не меняются
+func foldText(s string) string {
+	var result strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if base, ok := baseLetters[r]; ok {
+			result.WriteString(base)
+			continue
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
+
+// collator сравнивает строки по правилам языка: сначала без учета
+// регистра и диакритики, затем побайтово для однозначности.
+// Это упрощенная замена golang.org/x/text/collate без внешних зависимостей,
+// поэтому она включается только для языков из collatedLanguages
+type collator struct {
+	// Буквы, которые сортируются после z, в порядке алфавита языка
+	tail string
+	// Буквы с диакритикой, которые идут после всех слов со своей базовой
+	// буквой, а не вместе с ними
+	separate string
+}
+
+// collatorFor выбирает правила сравнения по первому языку из Accept-Language.
+// Без заголовка и для языков не из collatedLanguages возвращает nil - имена
+// сравниваются побайтово, как раньше
+func collatorFor(acceptLanguage string) *collator {
+	tag := strings.TrimSpace(strings.SplitN(acceptLanguage, ",", 2)[0])
+	tag = strings.SplitN(tag, ";", 2)[0]
+	if tag == "" || tag == "*" {
+		return nil
+	}
+
+	lang := strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+	switch {
+	case !collatedLanguages[lang]:
+		return nil
+	case lang == "sv" || lang == "fi":
+		return &collator{tail: swedishTail}
+	case lang == "da" || lang == "nb" || lang == "nn" || lang == "no":
+		return &collator{tail: norwegianTail}
+	case lang == "es":
+		return &collator{separate: spanishSeparate}
+	}
+	return &collator{}
+}
+
+// Ключ первичного сравнения строки
+func (c *collator) key(s string) string {
+	var result strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if idx := strings.IndexRune(c.tail, r); idx >= 0 {
+			// Символы сразу после 'z' в порядке tail
+			result.WriteRune('z' + 1 + rune(utf8.RuneCountInString(c.tail[:idx])))
+			continue
+		}
+		if base, ok := baseLetters[r]; ok {
+			result.WriteString(base)
+			if strings.ContainsRune(c.separate, r) {
+				// После базовой буквы и любых следующих за ней букв
+				result.WriteRune(unicode.MaxRune)
+			}
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			result.WriteRune(r)
+		}
+	}
+	return result.String()
+}
+
+func (c *collator) compare(a, b string) int {
+	if result := strings.Compare(c.key(a), c.key(b)); result != 0 {
+		return result
+	}
+	return strings.Compare(a, b)
+}
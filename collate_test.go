@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestCollatorFor(t *testing.T) {
+	cases := map[string]*collator{
+		"":                     nil,
+		"*":                    nil,
+		"en-US,en;q=0.9":       {},
+		"fr":                   {},
+		"sv-SE;q=0.8":          {tail: swedishTail},
+		"NB":                   {tail: norwegianTail},
+		"da, en-GB;q=0.8, en":  {tail: norwegianTail},
+		"fi-FI,sv;q=0.7,en-US": {tail: swedishTail},
+		"es-MX":                {separate: spanishSeparate},
+		// Для языков со своими правилами упрощенный порядок был бы неверным
+		"pl-PL": nil,
+		"tr":    nil,
+	}
+
+	for header, expected := range cases {
+		actual := collatorFor(header)
+		if (actual == nil) != (expected == nil) || (actual != nil && *actual != *expected) {
+			t.Errorf("Expected: %v for %q, got: %v", expected, header, actual)
+		}
+	}
+}
+
+func TestCollatedNameSort(t *testing.T) {
+	fileName = "testdata/collation.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		acceptLanguage string
+		expected       []int
+	}{
+		// Побайтово: заглавные латинские, затем строчные, затем с диакритикой
+		{"", []int{0, 3, 2, 1}},
+		{"en-US", []int{3, 2, 1, 0}},
+		// В шведском Å идет после Z
+		{"sv-SE", []int{3, 1, 0, 2}},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/?order_field=name&order_by=1", nil)
+		req.Header.Set("AccessToken", accessToken)
+		if c.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", c.acceptLanguage)
+		}
+		w := httptest.NewRecorder()
+		SearchServer(w, req)
+
+		users := decodeUsers(t, w)
+		if len(users) != len(c.expected) {
+			t.Fatalf("Expected: %v, got: %v", len(c.expected), len(users))
+		}
+		for idx, user := range users {
+			if user.ID != c.expected[idx] {
+				t.Errorf("Expected: %v for %q, got: %v", c.expected, c.acceptLanguage, users)
+				break
+			}
+		}
+	}
+}
+
+func TestFoldText(t *testing.T) {
+	cases := map[string]string{"Muñoz": "munoz", "MUÑOZ": "munoz", "munoz": "munoz", "Straße, Åsa!": "strasse, asa!"}
+	for text, expected := range cases {
+		if actual := foldText(text); actual != expected {
+			t.Errorf("Expected: %s, got: %s", expected, actual)
+		}
+	}
+}
+
+func TestNormalizeMatching(t *testing.T) {
+	fileName = "testdata/collation.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		query    string
+		expected int
+	}{
+		{"query=emile", 0},
+		{"query=EMILE&case_insensitive=1", 0},
+		{"query=%C3%89mile", 1},
+		// Регистр, диакритика и оба сразу
+		{"query=%C3%A9mile&normalize=1", 1},
+		{"query=Emile&normalize=1", 1},
+		{"query=EMILE&normalize=1", 1},
+		{"query=emile+durand&normalize=1", 1},
+		{"query=%C3%85SA&normalize=1", 1},
+	}
+	for _, c := range cases {
+		if users := decodeUsers(t, searchRecorder(c.query)); len(users) != c.expected {
+			t.Errorf("Expected: %d for %s, got: %v", c.expected, c.query, users)
+		}
+	}
+}
+
+func TestCISortFields(t *testing.T) {
+	fileName = "testdata/casesort.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		// С учетом регистра заглавные буквы раньше строчных
+		{"order_field=name&order_by=1", []int{1, 0, 2}},
+		{"order_field=city&order_by=1", []int{1, 2, 0}},
+		// name без учета регистра, city в том же запросе - с учетом
+		{"order_field=name&order_by=1&ci_sort_fields=name", []int{0, 1, 2}},
+		{"order_field=city&order_by=1&ci_sort_fields=name", []int{1, 2, 0}},
+		{"order_field=city&order_by=1&ci_sort_fields=name,city", []int{1, 0, 2}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder(c.query))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	// Пустой ci_sort_fields= отменяет значение по умолчанию
+	CaseInsensitiveSortFields = []string{"name"}
+	defer func() { CaseInsensitiveSortFields = nil }()
+	if actual := userIDs(decodeUsers(t, searchRecorder("order_field=name&order_by=1"))); !slices.Equal(actual, []int{0, 1, 2}) {
+		t.Errorf("Expected: %v, got: %v", []int{0, 1, 2}, actual)
+	}
+	if actual := userIDs(decodeUsers(t, searchRecorder("order_field=name&order_by=1&ci_sort_fields="))); !slices.Equal(actual, []int{1, 0, 2}) {
+		t.Errorf("Expected: %v, got: %v", []int{1, 0, 2}, actual)
+	}
+
+	if w := searchRecorder("order_field=name&order_by=1&ci_sort_fields=age"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestCollatorSeparateLetters(t *testing.T) {
+	names := []string{"Oscar", "Ñandú", "Nube", "Nuñez", "Núñez"}
+	cases := []struct {
+		acceptLanguage string
+		expected       []string
+	}{
+		{"en", []string{"Ñandú", "Nube", "Nuñez", "Núñez", "Oscar"}},
+		// В испанском ñ - отдельная буква между n и o
+		{"es", []string{"Nube", "Nuñez", "Núñez", "Ñandú", "Oscar"}},
+	}
+	for _, c := range cases {
+		collator := collatorFor(c.acceptLanguage)
+		sorted := slices.Clone(names)
+		slices.SortFunc(sorted, collator.compare)
+		if !slices.Equal(sorted, c.expected) {
+			t.Errorf("%s: expected: %v, got: %v", c.acceptLanguage, c.expected, sorted)
+		}
+	}
+}
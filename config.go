@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Config - настройки сервера, созданного через NewServer
+type Config struct {
+	// Файл с данными
+	FileName string
+	// Значение заголовка AccessToken, с которым принимаются запросы
+	AccessToken string
+	// Размер страницы без limit в запросе. См. DefaultLimit
+	DefaultLimit int
+	// Ограничение limit сверху, 0 - без ограничения. См. MaxLimit
+	MaxLimit int
+	// Разрешена ли сортировка. См. AllowSort
+	AllowSort bool
+	// Сколько запросов обрабатывается одновременно, 0 - без ограничения.
+	// См. MaxConcurrent
+	MaxConcurrent int
+	// Дополнительные токены и поля, которые они видят. См. TokenFields
+	TokenFields map[string][]string
+}
+
+// DefaultConfig возвращает настройки из глобальных переменных пакета,
+// с которыми работает SearchServer
+func DefaultConfig() Config {
+	return Config{
+		FileName:      fileName,
+		AccessToken:   accessToken,
+		DefaultLimit:  DefaultLimit,
+		MaxLimit:      MaxLimit,
+		AllowSort:     AllowSort,
+		MaxConcurrent: MaxConcurrent,
+		TokenFields:   TokenFields,
+	}
+}
+
+// Ключ настроек в контексте запроса
+type configKey struct{}
+
+// NewServer возвращает обработчик поиска с настройками cfg вместо
+// глобальных переменных. Остальные параметры берутся из пакета
+func NewServer(cfg Config) http.Handler {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SearchServer(w, r.WithContext(context.WithValue(r.Context(), configKey{}, cfg)))
+	})
+	return limitConcurrency(cfg.MaxConcurrent, handler)
+}
+
+// tokenAllowed - запрос с токеном token принимается
+func (cfg Config) tokenAllowed(token string) bool {
+	if token == cfg.AccessToken {
+		return true
+	}
+	_, ok := cfg.TokenFields[token]
+	return ok
+}
+
+// allowedFields возвращает ключи JSON полей, которые видит token, или nil,
+// если поля токена не ограничены
+func (cfg Config) allowedFields(token string) map[string]bool {
+	names, ok := cfg.TokenFields[token]
+	if !ok || token == cfg.AccessToken {
+		return nil
+	}
+	result := map[string]bool{}
+	for _, name := range names {
+		if key, ok := userFields[name]; ok {
+			result[key] = true
+		}
+	}
+	return result
+}
+
+// Поля, которые читает поиск по search_field. Поиск по умолчанию не
+// проверяется: иначе токену без About нельзя было бы искать совсем
+var searchFieldSources = map[string][]string{
+	searchFieldInitials: {"name"},
+	searchFieldAny:      {"name", "about", "gender"},
+	searchFieldLocation: {"city", "country"},
+	searchFieldAll:      {"name", "about", "id", "age"},
+	searchFieldHandle:   {"handle"},
+	searchFieldName:     {"name"},
+	searchFieldEmail:    {"email"},
+	searchFieldPhone:    {"phone"},
+}
+
+// fieldAllowed - поле name (имя как в fields=, order_field или stats) видно
+// токену запроса. about_words и about_len вычисляются по About, relevance и
+// priority задает сам запрос
+func (q *queryDTO) fieldAllowed(name string) bool {
+	if q.allowedFields == nil {
+		return true
+	}
+	switch name {
+	case "", "relevance", "priority":
+		return true
+	case "about_words", "about_len":
+		name = "about"
+	}
+	key, ok := userFields[name]
+	return ok && q.allowedFields[key]
+}
+
+// checkTokenFields отклоняет запрос, который сортирует, ищет или считает
+// статистику по полю, не видному токену: иначе значения поля можно узнать
+// по порядку, совпадениям или min/max
+func (q *queryDTO) checkTokenFields() error {
+	if q.allowedFields == nil {
+		return nil
+	}
+	if !q.fieldAllowed(q.orderField) {
+		return &paramError{ErrorBadOrderField}
+	}
+	for _, key := range q.orderKeys {
+		if !q.fieldAllowed(key.field) {
+			return &paramError{ErrorBadOrderField}
+		}
+	}
+	for _, name := range searchFieldSources[q.searchField] {
+		if !q.fieldAllowed(name) {
+			return &paramError{ErrorBadSearchField}
+		}
+	}
+	for _, m := range q.fieldMatches {
+		if !q.fieldAllowed(m.field) {
+			return &paramError{ErrorBadFieldMatch}
+		}
+	}
+	if q.stats != "" && !q.fieldAllowed(q.stats) {
+		return &paramError{ErrorBadStats}
+	}
+	return nil
+}
+
+// serverConfig возвращает настройки, с которыми обрабатывается запрос r
+func serverConfig(r *http.Request) Config {
+	if cfg, ok := r.Context().Value(configKey{}).(Config); ok {
+		return cfg
+	}
+	return DefaultConfig()
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// configRecorder выполняет запрос к NewServer(cfg) с токеном token
+func configRecorder(cfg Config, token, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/?"+query, nil)
+	req.Header.Set("AccessToken", token)
+	NewServer(cfg).ServeHTTP(w, req)
+	return w
+}
+
+func configUsers(t *testing.T, w *httptest.ResponseRecorder) []User {
+	t.Helper()
+	var users []User
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		t.Fatalf("Invalid error: %v, body: %s", err, w.Body.String())
+	}
+	return users
+}
+
+func TestNewServerFileName(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FileName = "testdata/about.xml"
+
+	if users := configUsers(t, configRecorder(cfg, accessToken, "limit=0")); len(users) != 3 {
+		t.Errorf("Expected 3 users from %s, got: %d", cfg.FileName, len(users))
+	}
+	// Глобальный обработчик по-прежнему читает fileName
+	if users := configUsers(t, searchRecorder("limit=0")); len(users) != 35 {
+		t.Errorf("Expected 35 users from %s, got: %d", fileName, len(users))
+	}
+}
+
+func TestNewServerAccessToken(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AccessToken = "custom"
+
+	if w := configRecorder(cfg, "custom", "limit=1"); w.Code != http.StatusOK {
+		t.Errorf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+	if w := configRecorder(cfg, accessToken, "limit=1"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected: %d, got: %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestNewServerMaxLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxLimit = 2
+
+	if users := configUsers(t, configRecorder(cfg, accessToken, "limit=5")); len(users) != 2 {
+		t.Errorf("Expected 2 users, got: %d", len(users))
+	}
+	if users := configUsers(t, searchRecorder("limit=5")); len(users) != 5 {
+		t.Errorf("Expected 5 users, got: %d", len(users))
+	}
+}
+
+func TestNewServerDefaultLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DefaultLimit = 3
+
+	if users := configUsers(t, configRecorder(cfg, accessToken, "")); len(users) != 3 {
+		t.Errorf("Expected 3 users, got: %d", len(users))
+	}
+	if users := configUsers(t, searchRecorder("")); len(users) != DefaultLimit {
+		t.Errorf("Expected %d users, got: %d", DefaultLimit, len(users))
+	}
+}
+
+func TestNewServerAllowSort(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AllowSort = false
+
+	if w := configRecorder(cfg, accessToken, "order_field=age&order_by=1"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+	if w := searchRecorder("order_field=age&order_by=1"); w.Code != http.StatusOK {
+		t.Errorf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestNewServerAllowSortSinceID(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AllowSort = false
+
+	// since_id сам отдает строки по возрастанию id и сортировкой не считается
+	users := configUsers(t, configRecorder(cfg, accessToken, "since_id=30&limit=3"))
+	if ids := userIDs(users); !slices.Equal(ids, []int{31, 32, 33}) {
+		t.Errorf("Expected: %v, got: %v", []int{31, 32, 33}, ids)
+	}
+}
+
+func TestNewServerMaxConcurrent(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxConcurrent = 1
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := NewServer(cfg)
+	// Первый запрос держит единственный слот, пока ответ не отпустят
+	blocked := &blockingWriter{ResponseRecorder: httptest.NewRecorder(), started: started, release: release}
+	done := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest("GET", "/?query=Boyd", nil)
+		req.Header.Set("AccessToken", accessToken)
+		handler.ServeHTTP(blocked, req)
+		close(done)
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/?query=Boyd", nil)
+	req.Header.Set("AccessToken", accessToken)
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected: %d, got: %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	close(release)
+	<-done
+	if blocked.Code != http.StatusOK {
+		t.Errorf("Expected: %d, got: %d", http.StatusOK, blocked.Code)
+	}
+}
+
+// blockingWriter при первой записи тела сообщает в started и ждет release
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	started chan struct{}
+	release chan struct{}
+	once    bool
+}
+
+func (w *blockingWriter) Write(b []byte) (int, error) {
+	if !w.once {
+		w.once = true
+		close(w.started)
+		<-w.release
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestNewServerTokenFields(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TokenFields = map[string][]string{"partner": {"id", "name", "age"}}
+
+	fields := func(token, query string) map[string]interface{} {
+		t.Helper()
+		var users []map[string]interface{}
+		w := configRecorder(cfg, token, query)
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil || len(users) != 1 {
+			t.Fatalf("Expected one user, got: %d %s", w.Code, w.Body.String())
+		}
+		return users[0]
+	}
+
+	if user := fields(accessToken, "query=Boyd"); user["About"] == nil {
+		t.Errorf("Expected About for %s, got: %v", accessToken, user)
+	}
+	if user := fields("partner", "query=Boyd"); user["About"] != nil || user["Name"] != "Boyd Wolf" || len(user) != 3 {
+		t.Errorf("Expected ID, Name and Age only, got: %v", user)
+	}
+	// fields= не расширяет разрешенные поля
+	if user := fields("partner", "query=Boyd&fields=name,about"); len(user) != 1 || user["Name"] != "Boyd Wolf" {
+		t.Errorf("Expected Name only, got: %v", user)
+	}
+
+	w := configRecorder(cfg, "partner", "query=Boyd&format=csv")
+	if strings.Contains(w.Body.String(), "Nulla cillum") {
+		t.Errorf("Expected no About in CSV, got: %s", w.Body.String())
+	}
+	if w := configRecorder(cfg, "unknown", "query=Boyd"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected: %d, got: %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestNewServerTokenFieldsQueries(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TokenFields = map[string][]string{"partner": {"id", "name"}}
+
+	status := func(path, query string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path+"?"+query, nil)
+		req.Header.Set("AccessToken", "partner")
+		NewServer(cfg).ServeHTTP(w, req)
+		return w.Code
+	}
+
+	cases := []struct {
+		path, query string
+		expected    int
+	}{
+		{"/", "stats=age&order_ids=5", http.StatusBadRequest},
+		{"/", "stats=about_len", http.StatusBadRequest},
+		{"/", "stats=id", http.StatusOK},
+		{"/histogram", "field=age&bucket=1", http.StatusBadRequest},
+		{"/histogram", "field=id&bucket=5", http.StatusOK},
+		{"/", "order_field=age&order_by=1", http.StatusBadRequest},
+		{"/", "order_field=name:asc,age:desc", http.StatusBadRequest},
+		{"/", "order_field=name:asc,id:desc", http.StatusOK},
+		{"/", "order_field=name&order_by=1", http.StatusOK},
+		{"/", "search_field=email&query=boyd", http.StatusBadRequest},
+		{"/", "match=about:prefix&query=Nulla", http.StatusBadRequest},
+		{"/", "search_field=name&query=Boyd", http.StatusOK},
+	}
+	for _, c := range cases {
+		if actual := status(c.path, c.query); actual != c.expected {
+			t.Errorf("Expected: %d for %s?%s, got: %d", c.expected, c.path, c.query, actual)
+		}
+	}
+
+	// Хеш выборки не зависит от скрытых полей
+	digest := func(token string) string {
+		t.Helper()
+		var result digestResponse
+		if err := json.Unmarshal(configRecorder(cfg, token, "digest=1&query=Boyd&fields=id,name").Body.Bytes(), &result); err != nil {
+			t.Fatalf("Invalid error: %v", err)
+		}
+		return result.Digest
+	}
+	if digest("partner") == digest(accessToken) {
+		t.Error("Expected digest over visible fields only")
+	}
+}
+
+func TestNewServerTokenFieldsPhone(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FileName = "testdata/phone.xml"
+	cfg.TokenFields = map[string][]string{"partner": {"id", "name"}, "support": {"id", "phone"}}
+
+	phone := func(token, query string) string {
+		t.Helper()
+		users := configUsers(t, configRecorder(cfg, token, "order_field=id&order_by=1&limit=1&"+query))
+		if len(users) != 1 {
+			t.Fatalf("Expected one user, got: %v", users)
+		}
+		return users[0].Phone
+	}
+
+	if actual := phone(accessToken, ""); actual != "" {
+		t.Errorf("Expected no phone by default, got: %q", actual)
+	}
+	if actual := phone(accessToken, "fields=all"); actual != "(555) 123-4567" {
+		t.Errorf("Expected phone with fields=all, got: %q", actual)
+	}
+	if actual := phone("partner", "fields=phone"); actual != "" {
+		t.Errorf("Expected no phone for partner, got: %q", actual)
+	}
+	if actual := phone("support", ""); actual != "(555) 123-4567" {
+		t.Errorf("Expected phone for support, got: %q", actual)
+	}
+	// Поиск по скрытому телефону тоже раскрыл бы его
+	if w := configRecorder(cfg, "partner", "search_field=phone&query=5551234"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
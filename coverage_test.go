@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -20,7 +28,7 @@ var (
 
 func newTestServer(accessToken string) TestServer {
 	server := httptest.NewServer(http.HandlerFunc(SearchServer))
-	client := SearchClient{accessToken, server.URL}
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
 
 	return TestServer{server, client}
 }
@@ -40,6 +48,42 @@ func TestBadAccessToken(t *testing.T) {
 	}
 }
 
+func TestTokenProvider(t *testing.T) {
+	ts := newTestServer("expired")
+	defer ts.Close()
+
+	calls := 0
+	ts.client.TokenProvider = func() (string, error) {
+		calls++
+		return accessToken, nil
+	}
+
+	srchResp, err := ts.client.FindUsers(SearchRequest{Query: "Boyd"})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if len(srchResp.Users) != 1 || calls != 1 || ts.client.AccessToken != accessToken {
+		t.Errorf("Unexpected result: %v, calls %d, token %s", srchResp.Users, calls, ts.client.AccessToken)
+	}
+
+	// Новый токен запоминается, повторно провайдер не вызывается
+	if _, err = ts.client.FindUsers(SearchRequest{Query: "Boyd"}); err != nil || calls != 1 {
+		t.Errorf("Expected cached token, got: %v, calls %d", err, calls)
+	}
+
+	// Токен обновляется только один раз за запрос
+	ts.client.AccessToken = "expired"
+	ts.client.TokenProvider = func() (string, error) { return "still invalid", nil }
+	if _, err = ts.client.FindUsers(SearchRequest{}); err == nil || err.Error() != "bad AccessToken" {
+		t.Errorf("Invalid error: %v", err)
+	}
+
+	ts.client.TokenProvider = func() (string, error) { return "", errTest }
+	if _, err = ts.client.FindUsers(SearchRequest{}); !errors.Is(err, errTest) {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
 func TestOpenFile(t *testing.T) {
 	originalFilePath := "dataset.xml"
 	fileName = "invalid.xml" // Путь к тестовому файлу
@@ -60,7 +104,7 @@ func TestTimeout(t *testing.T) {
 		time.Sleep(1500 * time.Millisecond)
 	}))
 	defer server.Close()
-	client := SearchClient{accessToken, server.URL}
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
 
 	_, err := client.FindUsers(SearchRequest{})
 
@@ -69,8 +113,40 @@ func TestTimeout(t *testing.T) {
 	}
 }
 
+func TestFindUsersContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(1500 * time.Millisecond):
+		}
+	}))
+	defer server.Close()
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.FindUsersContext(ctx, SearchRequest{})
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "request cancelled") {
+		t.Errorf("Invalid error: %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	_, err = client.FindUsersContext(ctx, SearchRequest{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Invalid error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancel did not abort request, took %s", elapsed)
+	}
+}
+
 func TestUnknownError(t *testing.T) {
-	client := SearchClient{accessToken, "http://invalid/"}
+	client := SearchClient{AccessToken: accessToken, URL: "http://invalid/"}
 
 	_, err := client.FindUsers(SearchRequest{})
 
@@ -90,7 +166,7 @@ func TestUnknownBadRequestError(t *testing.T) {
 		}
 	}))
 	defer server.Close()
-	client := SearchClient{accessToken, server.URL}
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
 
 	_, err := client.FindUsers(SearchRequest{})
 
@@ -104,7 +180,7 @@ func TestFatalError(t *testing.T) {
 		http.Error(w, "SearchServer fatal error", http.StatusInternalServerError)
 	}))
 	defer server.Close()
-	client := SearchClient{accessToken, server.URL}
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
 
 	_, err := client.FindUsers(SearchRequest{})
 
@@ -119,7 +195,7 @@ func TestUnpackErrorJson(t *testing.T) {
 		w.WriteHeader(http.StatusBadRequest)
 	}))
 	defer server.Close()
-	client := SearchClient{accessToken, server.URL}
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
 
 	_, err := client.FindUsers(SearchRequest{})
 
@@ -132,7 +208,7 @@ func TestUnpackResultJson(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	}))
 	defer server.Close()
-	client := SearchClient{accessToken, server.URL}
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
 
 	_, err := client.FindUsers(SearchRequest{})
 
@@ -276,6 +352,33 @@ func TestInvalidOffset(t *testing.T) {
 
 }
 
+func TestOffsetBoundary(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	cases := []struct {
+		offset   int
+		expected []int
+	}{
+		{30, []int{30, 31, 32, 33, 34}},
+		{34, []int{34}},
+		{35, []int{}},
+		{36, []int{}},
+	}
+	for _, c := range cases {
+		srchResp, err := ts.client.FindUsers(SearchRequest{OrderField: "id", OrderBy: OrderByAsc, Offset: c.offset, Limit: 5})
+		if err != nil {
+			t.Fatalf("Invalid error: %v", err.Error())
+		}
+		if actual := userIDs(srchResp.Users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for offset %d, got: %v", c.expected, c.offset, actual)
+		}
+		if srchResp.NextPage {
+			t.Errorf("Expected no next page for offset %d", c.offset)
+		}
+	}
+}
+
 func TestInvalidRequestOffsetLow(t *testing.T) {
 	ts := newTestServer(accessToken)
 	defer ts.Close()
@@ -448,3 +551,2231 @@ func (e *errorResponseWriter) WriteHeader(statusCode int) {}
 
 var someError = &json.MarshalerError{}
 
+// serveRequest выполняет req с accessToken к SearchServer напрямую, без клиента
+func serveRequest(req *http.Request) *httptest.ResponseRecorder {
+	req.Header.Set("AccessToken", accessToken)
+	w := httptest.NewRecorder()
+	SearchServer(w, req)
+	return w
+}
+
+// GET-запрос path?query к SearchServer, например recorder("/rank", "id=3")
+func recorder(path, query string) *httptest.ResponseRecorder {
+	return serveRequest(httptest.NewRequest("GET", path+"?"+query, nil))
+}
+
+// Запрос поиска к SearchServer
+func searchRecorder(query string) *httptest.ResponseRecorder {
+	return recorder("/", query)
+}
+
+// Разбор успешного ответа SearchServer
+func decodeUsers(t *testing.T, w *httptest.ResponseRecorder) []User {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d (%s)", http.StatusOK, w.Code, w.Body.String())
+	}
+	users := []User{}
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	return users
+}
+
+func TestSearchFieldInitials(t *testing.T) {
+	// Инициалы BW в датасете у Boyd Wolf и Beth Wynn
+	for _, query := range []string{"BW", "bw"} {
+		users := decodeUsers(t, searchRecorder("search_field=initials&query="+query))
+		if len(users) != 2 || users[0].Name != "Boyd Wolf" || users[1].Name != "Beth Wynn" {
+			t.Errorf("Expected: [Boyd Wolf, Beth Wynn] for %s, got: %v", query, users)
+		}
+	}
+
+	users := decodeUsers(t, searchRecorder("search_field=initials&query=WB"))
+	if len(users) != 0 {
+		t.Errorf("Expected: %v, got: %v", 0, len(users))
+	}
+}
+
+func TestBadSearchField(t *testing.T) {
+	w := searchRecorder("search_field=random&query=BW")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), ErrorBadSearchField) {
+		t.Errorf("Expected: %s, got: %s", ErrorBadSearchField, w.Body.String())
+	}
+}
+
+func TestAllowSort(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	AllowSort = false
+	defer func() { AllowSort = true }()
+
+	_, err := ts.client.FindUsers(SearchRequest{OrderField: "id", OrderBy: OrderByAsc})
+	expected := "unknown bad request error: " + ErrorSortDisabled
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected: %v, got: %v", expected, err)
+	}
+
+	// Запросы без сортировки продолжают работать
+	srchResp, err := ts.client.FindUsers(SearchRequest{OrderBy: OrderByAsIs})
+	if err != nil {
+		t.Errorf("Invalid error: %v", err.Error())
+	}
+	if len(srchResp.Users) != 35 {
+		t.Errorf("Expected: %v, got: %v", 35, len(srchResp.Users))
+	}
+
+	AllowSort = true
+	srchResp, err = ts.client.FindUsers(SearchRequest{OrderField: "id", OrderBy: OrderByAsc})
+	if err != nil {
+		t.Errorf("Invalid error: %v", err.Error())
+	}
+	if srchResp.Users[0].ID != 0 {
+		t.Errorf("Expected: %v, got: %v", 0, srchResp.Users[0].ID)
+	}
+}
+
+func TestRowsScannedHeaders(t *testing.T) {
+	w := searchRecorder("query=Boyd")
+	users := decodeUsers(t, w)
+
+	if scanned := w.Header().Get("X-Rows-Scanned"); scanned != "35" {
+		t.Errorf("Expected: %v, got: %v", 35, scanned)
+	}
+	if matched := w.Header().Get("X-Rows-Matched"); matched != strconv.Itoa(len(users)) {
+		t.Errorf("Expected: %v, got: %v", len(users), matched)
+	}
+}
+
+func TestExtraParams(t *testing.T) {
+	// Сервер возвращает полученные параметры в поле About
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendResponse(w, []User{{About: r.URL.RawQuery}})
+	}))
+	defer server.Close()
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
+
+	srchResp, err := client.FindUsers(SearchRequest{
+		Query: "Boyd",
+		Limit: 5,
+		Extra: map[string]string{"search_field": "initials", "query": "clobbered", "limit": "100"},
+	})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+
+	values, err := url.ParseQuery(srchResp.Users[0].About)
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if values.Get("search_field") != "initials" {
+		t.Errorf("Expected: %v, got: %v", "initials", values.Get("search_field"))
+	}
+	if values["query"][0] != "Boyd" || len(values["query"]) != 1 {
+		t.Errorf("Expected: %v, got: %v", []string{"Boyd"}, values["query"])
+	}
+	if values["limit"][0] != "6" || len(values["limit"]) != 1 {
+		t.Errorf("Expected: %v, got: %v", []string{"6"}, values["limit"])
+	}
+}
+
+func TestOrderByAsIsKeepsFileOrder(t *testing.T) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileOrder := struct {
+		IDs []int `xml:"row>id"`
+	}{}
+	if err = xml.Unmarshal(b, &fileOrder); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	srchResp, err := ts.client.FindUsers(SearchRequest{OrderBy: OrderByAsIs})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+
+	if len(srchResp.Users) != len(fileOrder.IDs) {
+		t.Fatalf("Expected: %v, got: %v", len(fileOrder.IDs), len(srchResp.Users))
+	}
+	for idx, user := range srchResp.Users {
+		if user.ID != fileOrder.IDs[idx] {
+			t.Errorf("Expected: %v, got: %v", fileOrder.IDs[idx], user.ID)
+		}
+	}
+}
+
+func TestResponseCharset(t *testing.T) {
+	w := httptest.NewRecorder()
+	sendResponse(w, []User{})
+
+	expected := "application/json; charset=utf-8"
+	if contentType := w.Header().Get("Content-Type"); contentType != expected {
+		t.Errorf("Expected: %s, got: %s", expected, contentType)
+	}
+
+	ResponseCharset = ""
+	defer func() { ResponseCharset = "utf-8" }()
+
+	w = httptest.NewRecorder()
+	sendResponse(w, []User{})
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected: %s, got: %s", "application/json", contentType)
+	}
+}
+
+func TestSearchFieldAnyGender(t *testing.T) {
+	users := decodeUsers(t, searchRecorder("query=female"))
+	if len(users) != 0 {
+		t.Errorf("Expected: %v, got: %v", 0, len(users))
+	}
+
+	users = decodeUsers(t, searchRecorder("search_field=any&query=female&limit=0"))
+	if len(users) != 11 {
+		t.Errorf("Expected: %v, got: %v", 11, len(users))
+	}
+	for _, user := range users {
+		if user.Gender != "female" {
+			t.Errorf("Expected: %v, got: %v", "female", user.Gender)
+		}
+	}
+
+	// Остальные поля в режиме any продолжают искаться
+	users = decodeUsers(t, searchRecorder("search_field=any&query=Boyd"))
+	if len(users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(users))
+	}
+}
+
+func TestUnlimitedExport(t *testing.T) {
+	users := decodeUsers(t, searchRecorder("order_field=id&order_by=1&limit=0"))
+	if len(users) != 35 {
+		t.Fatalf("Expected: %v, got: %v", 35, len(users))
+	}
+	for idx, user := range users {
+		if user.ID != idx {
+			t.Errorf("Expected: %v, got: %v", idx, user.ID)
+		}
+	}
+
+	users = decodeUsers(t, searchRecorder("order_field=id&order_by=1&offset=30&limit=0"))
+	if len(users) != 5 {
+		t.Errorf("Expected: %v, got: %v", 5, len(users))
+	}
+
+	users = decodeUsers(t, searchRecorder("order_field=id&order_by=1&limit=1"))
+	if len(users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(users))
+	}
+
+	if w := searchRecorder("limit=-1"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestClientUnlimited(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	// Единственный найденный пользователь не должен теряться при Limit 0
+	srchResp, err := ts.client.FindUsers(SearchRequest{Query: "Boyd"})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if len(srchResp.Users) != 1 || srchResp.NextPage {
+		t.Errorf("Expected: 1 user without next page, got: %v, %v", len(srchResp.Users), srchResp.NextPage)
+	}
+}
+
+func TestTooManyQueryParams(t *testing.T) {
+	query := strings.Repeat("query=a&", MaxQueryParams+10)
+	w := searchRecorder(query)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), ErrorTooManyParams) {
+		t.Errorf("Expected: %s, got: %s", ErrorTooManyParams, w.Body.String())
+	}
+
+	// Ровно MaxQueryParams параметров допустимо
+	query = strings.Repeat("query=Boyd&", MaxQueryParams)
+	if users := decodeUsers(t, searchRecorder(query)); len(users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(users))
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	MaxBodyBytes = 16
+	defer func() { MaxBodyBytes = 1 << 20 }()
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("x", 32)))
+	req.Header.Set("AccessToken", accessToken)
+	w := httptest.NewRecorder()
+	SearchServer(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected: %d, got: %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
+
+func TestEmptyResultIsArray(t *testing.T) {
+	for _, query := range []string{"query=nobody-matches", "offset=100", "order_field=id&order_by=1&offset=35"} {
+		w := searchRecorder(query)
+		if body := w.Body.String(); body != "[]" {
+			t.Errorf("Expected: [] for %s, got: %s", query, body)
+		}
+	}
+
+	if page := paginateData(nil, 0, 10); page == nil {
+		t.Error("Expected empty slice, got nil")
+	}
+}
+
+func TestSearchFieldLocation(t *testing.T) {
+	fileName = "testdata/location.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		{"Norway", []int{0, 3}},
+		{"Berg", []int{3}},
+		// Упоминание в About не считается местоположением
+		{"Oslo", []int{0}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder("search_field=location&query="+c.query))
+		if len(users) != len(c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, users)
+			continue
+		}
+		for idx, user := range users {
+			if user.ID != c.expected[idx] {
+				t.Errorf("Expected: %v for %s, got: %v", c.expected[idx], c.query, user.ID)
+			}
+		}
+	}
+
+	users := decodeUsers(t, searchRecorder("query=Oslo"))
+	if len(users) != 1 || users[0].ID != 2 {
+		t.Errorf("Expected: user 2 by About, got: %v", users)
+	}
+}
+
+func TestOrderFieldCity(t *testing.T) {
+	fileName = "testdata/location.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	w := searchRecorder("order_field=city&order_by=1")
+	users := decodeUsers(t, w)
+
+	// Пользователь без города идет первым
+	expected := []string{"", "Bergen", "Berlin", "Oslo"}
+	for idx, user := range users {
+		if user.City != expected[idx] {
+			t.Errorf("Expected: %v, got: %v", expected[idx], user.City)
+		}
+	}
+	if strings.Contains(w.Body.String(), `"City":""`) {
+		t.Errorf("Expected empty City to be omitted, got: %s", w.Body.String())
+	}
+}
+
+func TestQueryTerms(t *testing.T) {
+	cases := []struct {
+		query            string
+		include, exclude []string
+	}{
+		{"", nil, nil},
+		{" ", nil, nil},
+		{"Boyd Wolf", []string{"Boyd", "Wolf"}, nil},
+		{"-Lorem", nil, []string{"Lorem"}},
+		{"Nulla -Lorem - --x", []string{"Nulla"}, []string{"Lorem", "-x"}},
+		{`"Boyd Wolf" Lorem`, []string{"Boyd Wolf", "Lorem"}, nil},
+		{`-"nulla cillum" ""  "unclosed phrase`, []string{"unclosed phrase"}, []string{"nulla cillum"}},
+		{`-"" "a  b"x`, []string{"a  b", "x"}, nil},
+	}
+
+	for _, c := range cases {
+		include, exclude := parseQueryTerms(c.query)
+		if !slices.Equal(include, c.include) || !slices.Equal(exclude, c.exclude) {
+			t.Errorf("Expected: %v %v for %q, got: %v %v", c.include, c.exclude, c.query, include, exclude)
+		}
+	}
+}
+
+func TestNegatedQuery(t *testing.T) {
+	all := decodeUsers(t, searchRecorder("limit=0"))
+	withLorem := decodeUsers(t, searchRecorder("query=Lorem&limit=0"))
+	if len(withLorem) == 0 {
+		t.Fatal("Expected users with Lorem")
+	}
+
+	users := decodeUsers(t, searchRecorder("query=-Lorem&limit=0&case_insensitive=0"))
+	if len(users) != len(all)-len(withLorem) {
+		t.Errorf("Expected: %v, got: %v", len(all)-len(withLorem), len(users))
+	}
+	for _, user := range users {
+		if strings.Contains(user.About, "Lorem") {
+			t.Errorf("Expected user %v to be excluded", user.ID)
+		}
+	}
+
+	// Отрицание сочетается с обычными словами через AND
+	users = decodeUsers(t, searchRecorder("query=Nulla+-Lorem&case_insensitive=0"))
+	for _, user := range users {
+		if !strings.Contains(user.About, "Nulla") || strings.Contains(user.About, "Lorem") {
+			t.Errorf("Unexpected user %v", user.ID)
+		}
+	}
+
+	// Одиночный "-" ничего не исключает
+	if users = decodeUsers(t, searchRecorder("query=-&limit=0")); len(users) != len(all) {
+		t.Errorf("Expected: %v, got: %v", len(all), len(users))
+	}
+}
+
+func TestMultiTermQuery(t *testing.T) {
+	users := decodeUsers(t, searchRecorder("query=Boyd+Wolf"))
+	if len(users) != 1 || users[0].ID != 0 {
+		t.Errorf("Expected: user 0, got: %v", users)
+	}
+
+	if users = decodeUsers(t, searchRecorder("query=Boyd+Mayer")); len(users) != 0 {
+		t.Errorf("Expected: %v, got: %v", 0, len(users))
+	}
+}
+
+func TestSearchWarnings(t *testing.T) {
+	w := searchRecorder("query=Boyd&offset=5")
+	if users := decodeUsers(t, w); len(users) != 0 {
+		t.Errorf("Expected: %v, got: %v", 0, len(users))
+	}
+	expected := "offset 5 is beyond total 1"
+	if warnings := w.Header().Get("X-Search-Warnings"); warnings != expected {
+		t.Errorf("Expected: %s, got: %s", expected, warnings)
+	}
+
+	w = searchRecorder("order_by=1&offset=100")
+	warnings := w.Header().Get("X-Search-Warnings")
+	if !strings.Contains(warnings, "order_by without order_field") || !strings.Contains(warnings, "offset 100") {
+		t.Errorf("Unexpected warnings: %s", warnings)
+	}
+
+	if w = searchRecorder("order_field=id&order_by=1&offset=10"); w.Header().Get("X-Search-Warnings") != "" {
+		t.Errorf("Unexpected warnings: %s", w.Header().Get("X-Search-Warnings"))
+	}
+}
+
+func TestQuotedPhraseQuery(t *testing.T) {
+	// Фраза и отдельное слово
+	users := decodeUsers(t, searchRecorder(url.Values{"query": {`"Boyd Wolf" Nulla`}}.Encode()))
+	if len(users) != 1 || users[0].ID != 0 {
+		t.Errorf("Expected: user 0, got: %v", users)
+	}
+
+	// Без кавычек слова могут стоять в разных местах, с кавычками - нет
+	loose := decodeUsers(t, searchRecorder(url.Values{"query": {`Nulla cillum`}, "limit": {"0"}, "case_insensitive": {"0"}}.Encode()))
+	phrase := decodeUsers(t, searchRecorder(url.Values{"query": {`"Nulla cillum"`}, "limit": {"0"}, "case_insensitive": {"0"}}.Encode()))
+	if len(phrase) == 0 || len(phrase) >= len(loose) {
+		t.Errorf("Expected phrase to narrow results: %v phrase vs %v loose", len(phrase), len(loose))
+	}
+	for _, user := range phrase {
+		if !strings.Contains(user.About, "Nulla cillum") {
+			t.Errorf("Unexpected user %v", user.ID)
+		}
+	}
+
+	if users = decodeUsers(t, searchRecorder(url.Values{"query": {`"Wolf Boyd"`}}.Encode())); len(users) != 0 {
+		t.Errorf("Expected: %v, got: %v", 0, len(users))
+	}
+}
+
+func TestDefaultLimit(t *testing.T) {
+	if users := decodeUsers(t, searchRecorder("order_field=id&order_by=1")); len(users) != DefaultLimit {
+		t.Errorf("Expected: %v, got: %v", DefaultLimit, len(users))
+	}
+	if users := decodeUsers(t, searchRecorder("order_field=id&order_by=1&limit=")); len(users) != DefaultLimit {
+		t.Errorf("Expected: %v, got: %v", DefaultLimit, len(users))
+	}
+	if users := decodeUsers(t, searchRecorder("limit=0")); len(users) != 35 {
+		t.Errorf("Expected: %v, got: %v", 35, len(users))
+	}
+	if users := decodeUsers(t, searchRecorder("limit=20")); len(users) != 20 {
+		t.Errorf("Expected: %v, got: %v", 20, len(users))
+	}
+
+	DefaultLimit = 3
+	defer func() { DefaultLimit = 10 }()
+	if users := decodeUsers(t, searchRecorder("")); len(users) != 3 {
+		t.Errorf("Expected: %v, got: %v", 3, len(users))
+	}
+}
+
+func TestGenderFilter(t *testing.T) {
+	males := decodeUsers(t, searchRecorder("gender=male&limit=0"))
+	females := decodeUsers(t, searchRecorder("gender=female&limit=0"))
+	if len(males) != 24 || len(females) != 11 {
+		t.Errorf("Expected: 24 and 11, got: %v and %v", len(males), len(females))
+	}
+	for _, user := range males {
+		if user.Gender != "male" {
+			t.Errorf("Expected: male, got: %v", user.Gender)
+		}
+	}
+
+	for _, query := range []string{"gender=male,female&limit=0", "gender=male&gender=female&limit=0"} {
+		if users := decodeUsers(t, searchRecorder(query)); len(users) != 35 {
+			t.Errorf("Expected: %v for %s, got: %v", 35, query, len(users))
+		}
+	}
+
+	// Фильтр сочетается с query
+	if users := decodeUsers(t, searchRecorder("gender=female&query=Boyd")); len(users) != 0 {
+		t.Errorf("Expected: %v, got: %v", 0, len(users))
+	}
+
+	for _, query := range []string{"gender=robot", "gender=male,robot", "gender=male&gender="} {
+		if w := searchRecorder(query); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected: %d for %s, got: %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
+
+func TestOrderFieldAboutWords(t *testing.T) {
+	users := decodeUsers(t, searchRecorder("order_field=about_words&order_by=1&limit=0"))
+	if len(users) != 35 {
+		t.Fatalf("Expected: %v, got: %v", 35, len(users))
+	}
+
+	// Меньше всего слов в About у Gates Spencer
+	if users[0].ID != 23 {
+		t.Errorf("Expected: %v, got: %v", 23, users[0].ID)
+	}
+
+	for idx := 1; idx < len(users); idx++ {
+		prev, cur := len(strings.Fields(users[idx-1].About)), len(strings.Fields(users[idx].About))
+		if prev > cur || (prev == cur && users[idx-1].ID > users[idx].ID) {
+			t.Errorf("Wrong order at %v: %v words (id %v) before %v words (id %v)",
+				idx, prev, users[idx-1].ID, cur, users[idx].ID)
+		}
+	}
+}
+
+func TestHeadRequest(t *testing.T) {
+	get := searchRecorder("query=Boyd")
+
+	req := httptest.NewRequest("HEAD", "/?query=Boyd", nil)
+	req.Header.Set("AccessToken", accessToken)
+	head := httptest.NewRecorder()
+	SearchServer(head, req)
+
+	if head.Code != http.StatusOK {
+		t.Errorf("Expected: %d, got: %d", http.StatusOK, head.Code)
+	}
+	if head.Body.Len() != 0 {
+		t.Errorf("Expected empty body, got: %s", head.Body.String())
+	}
+
+	for _, header := range []string{"Content-Type", "Content-Length", "ETag", "Last-Modified"} {
+		if head.Header().Get(header) == "" {
+			t.Errorf("Expected %s header", header)
+		}
+		if head.Header().Get(header) != get.Header().Get(header) {
+			t.Errorf("Expected: %s, got: %s", get.Header().Get(header), head.Header().Get(header))
+		}
+	}
+	if head.Header().Get("Content-Length") != strconv.Itoa(get.Body.Len()) {
+		t.Errorf("Expected: %v, got: %v", get.Body.Len(), head.Header().Get("Content-Length"))
+	}
+
+	// Авторизация проверяется и для HEAD
+	req = httptest.NewRequest("HEAD", "/", nil)
+	head = httptest.NewRecorder()
+	SearchServer(head, req)
+	if head.Code != http.StatusUnauthorized || head.Body.Len() != 0 {
+		t.Errorf("Expected: %d without body, got: %d %s", http.StatusUnauthorized, head.Code, head.Body.String())
+	}
+}
+
+func TestMinQueryLen(t *testing.T) {
+	MinQueryLen = 3
+	defer func() { MinQueryLen = 0 }()
+
+	for _, query := range []string{"query=a", "query=Bo", "query=%20a%20"} {
+		w := searchRecorder(query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorQueryTooShort) {
+			t.Errorf("Expected: %d for %s, got: %d %s", http.StatusBadRequest, query, w.Code, w.Body.String())
+		}
+	}
+
+	if users := decodeUsers(t, searchRecorder("query=Boy")); len(users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(users))
+	}
+
+	// Пустой запрос разрешен всегда
+	for _, query := range []string{"", "query=", "query=%20"} {
+		if users := decodeUsers(t, searchRecorder(query)); len(users) != DefaultLimit {
+			t.Errorf("Expected: %v for %q, got: %v", DefaultLimit, query, len(users))
+		}
+	}
+}
+
+func TestCaseInsensitiveOption(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	// Регистр учитывается только с case_insensitive=0
+	srchResp, err := ts.client.FindUsers(SearchRequest{Query: "bOyD wOLF", Extra: map[string]string{"case_insensitive": "0"}})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if len(srchResp.Users) != 0 {
+		t.Errorf("Expected: %v, got: %v", 0, len(srchResp.Users))
+	}
+
+	srchResp, err = ts.client.FindUsers(SearchRequest{Query: "bOyD wOLF"})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if len(srchResp.Users) != 1 || srchResp.Users[0].Name != "Boyd Wolf" {
+		t.Errorf("Expected: Boyd Wolf, got: %v", srchResp.Users)
+	}
+
+	// Everett Dillard и Dillard Mccoy в любом регистре
+	for _, query := range []string{"Dillard", "dillard", "DILLARD"} {
+		if users := decodeUsers(t, searchRecorder("limit=0&query="+query)); len(users) != 2 {
+			t.Errorf("Expected 2 users for %s, got: %v", query, userIDs(users))
+		}
+	}
+
+	client := NewSearchClient(accessToken, ts.server.URL, WithCaseInsensitive())
+	srchResp, err = client.FindUsers(SearchRequest{Query: "bOyD wOLF"})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if len(srchResp.Users) != 1 || srchResp.Users[0].Name != "Boyd Wolf" {
+		t.Errorf("Expected: Boyd Wolf, got: %v", srchResp.Users)
+	}
+}
+
+func TestUpdatedSince(t *testing.T) {
+	fileName = "testdata/updated.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		since    string
+		expected []int
+	}{
+		// Граница включается, в том числе при другом часовом поясе
+		{"2024-01-10T12:00:00Z", []int{0, 1, 3}},
+		{"2024-01-10T12:00:01Z", []int{1}},
+		{"2024-02-01T00:00:00Z", []int{1}},
+		{"2024-02-01T00:00:01Z", []int{}},
+		// Без фильтра строки без updated_at не отбрасываются
+		{"", []int{0, 1, 2, 3}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder("updated_since="+url.QueryEscape(c.since)))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.since, actual)
+		}
+	}
+
+	for _, since := range []string{"2024-01-10", "yesterday", "2024-01-10T12:00:00"} {
+		w := searchRecorder("updated_since=" + url.QueryEscape(since))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadUpdatedSince) {
+			t.Errorf("Expected: %d for %s, got: %d %s", http.StatusBadRequest, since, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	body := searchRecorder("query=Boyd").Body.String()
+	if strings.Contains(body, "\n") || strings.Contains(body, "  ") {
+		t.Errorf("Expected compact JSON, got: %s", body)
+	}
+
+	body = searchRecorder("query=Boyd&pretty=1").Body.String()
+	if !strings.Contains(body, "[\n  {\n    \"ID\": 0,") {
+		t.Errorf("Expected indented JSON, got: %s", body)
+	}
+
+	// Клиент разбирает оба варианта
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+	for _, pretty := range []string{"0", "1"} {
+		srchResp, err := ts.client.FindUsers(SearchRequest{Query: "Boyd", Extra: map[string]string{"pretty": pretty}})
+		if err != nil {
+			t.Fatalf("Invalid error: %v", err.Error())
+		}
+		if len(srchResp.Users) != 1 || srchResp.Users[0].Name != "Boyd Wolf" {
+			t.Errorf("Expected: Boyd Wolf, got: %v", srchResp.Users)
+		}
+	}
+}
+
+func TestAboutLang(t *testing.T) {
+	fileName = "testdata/lang.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		// Без lang ищется английский вариант, немецкий не участвует
+		{"query=Angelt", []int{}},
+		{"query=fishing", []int{0}},
+		{"query=Angelt&lang=de", []int{0}},
+		{"query=fishing&lang=de", []int{}},
+		// Единственный вариант используется для любого языка
+		{"query=Geige", []int{1}},
+		{"query=chess&lang=de", []int{2}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder(c.query))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	users := decodeUsers(t, searchRecorder("query=Boyd&lang=de"))
+	if len(users) != 1 || users[0].About != "Angelt gern in den Fjorden." {
+		t.Errorf("Expected german About, got: %v", users)
+	}
+}
+
+func TestSearchFieldAll(t *testing.T) {
+	if users := decodeUsers(t, searchRecorder("query=21&limit=0")); len(users) != 0 {
+		t.Errorf("Expected no numeric matches by default, got: %v", userIDs(users))
+	}
+
+	// Возраст 21 у 1, 15 и 23, id 21 тоже подходит
+	users := decodeUsers(t, searchRecorder("query=21&search_field=all&order_field=id&order_by=1&limit=0"))
+	if actual := userIDs(users); !slices.Equal(actual, []int{1, 15, 21, 23}) {
+		t.Errorf("Expected: %v, got: %v", []int{1, 15, 21, 23}, actual)
+	}
+
+	// Текстовые поля по-прежнему ищутся
+	if users = decodeUsers(t, searchRecorder("query=Boyd&search_field=all")); len(users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(users))
+	}
+}
+
+func TestTopN(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	users, err := ts.client.TopN("age", OrderByDesc, 3)
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	// Ровесники идут по возрастанию id
+	if actual := userIDs(users); !slices.Equal(actual, []int{13, 32, 6}) {
+		t.Errorf("Expected: %v, got: %v", []int{13, 32, 6}, actual)
+	}
+
+	users, err = ts.client.TopN("id", OrderByAsc, 2)
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if actual := userIDs(users); !slices.Equal(actual, []int{0, 1}) {
+		t.Errorf("Expected: %v, got: %v", []int{0, 1}, actual)
+	}
+
+	for _, n := range []int{0, -1} {
+		if _, err = ts.client.TopN("age", OrderByDesc, n); err == nil || err.Error() != "n must be > 0" {
+			t.Errorf("Invalid error for n=%d: %v", n, err)
+		}
+	}
+}
+
+func TestFilterDataStopAfter(t *testing.T) {
+	data, err := readData(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{"", "query=e", "query=Boyd", "query=an&gender=female"} {
+		for _, page := range []struct{ offset, limit int }{{0, 1}, {0, 5}, {3, 4}, {20, 25}, {40, 5}} {
+			params := &queryDTO{}
+			req := httptest.NewRequest("GET", "/?"+query, nil)
+			if err = params.parseParams(req); err != nil {
+				t.Fatal(err)
+			}
+			params.offset, params.limit, params.noTotal = page.offset, page.limit, true
+
+			full, _, _ := filterData(context.Background(), data, params, 0)
+			stopAfter := filterStopAfter(params)
+			short, scanned, _ := filterData(context.Background(), data, params, stopAfter)
+
+			expected := paginateData(full, page.offset, page.limit)
+			if actual := paginateData(short, page.offset, page.limit); !slices.Equal(userIDs(actual), userIDs(expected)) {
+				t.Errorf("Expected: %v for %s %+v, got: %v", userIDs(expected), query, page, userIDs(actual))
+			}
+			if len(full) > stopAfter && scanned == len(data.Rows) {
+				t.Errorf("Expected early exit for %s %+v", query, page)
+			}
+		}
+	}
+
+	// С сортировкой просматриваются все строки
+	w := searchRecorder("order_field=id&order_by=1&limit=1")
+	if scanned := w.Header().Get("X-Rows-Scanned"); scanned != "35" {
+		t.Errorf("Expected: %v, got: %v", 35, scanned)
+	}
+	// Без no_total нужен X-Rows-Matched, тоже просматриваются все строки
+	w = searchRecorder("limit=1")
+	if scanned := w.Header().Get("X-Rows-Scanned"); scanned != "35" {
+		t.Errorf("Expected: %v, got: %v", 35, scanned)
+	}
+	w = searchRecorder("limit=1&no_total=1")
+	if scanned := w.Header().Get("X-Rows-Scanned"); scanned != "1" {
+		t.Errorf("Expected: %v, got: %v", 1, scanned)
+	}
+}
+
+func BenchmarkFilterDataAsIs(b *testing.B) {
+	data, err := readData(fileName)
+	if err != nil {
+		b.Fatal(err)
+	}
+	params := &queryDTO{include: []string{"e"}, query: "e", limit: 5}
+
+	b.Run("full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			filterData(context.Background(), data, params, 0) //nolint:errcheck
+		}
+	})
+	b.Run("stop_after", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			filterData(context.Background(), data, params, filterStopAfter(params)) //nolint:errcheck
+		}
+	})
+}
+
+func TestGenderUnknown(t *testing.T) {
+	fileName = "testdata/gender.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	users := decodeUsers(t, searchRecorder("limit=0"))
+	expected := []string{"male", genderUnknown, "female", genderUnknown}
+	for idx, user := range users {
+		if user.Gender != expected[idx] {
+			t.Errorf("Expected: %s for %d, got: %s", expected[idx], user.ID, user.Gender)
+		}
+	}
+
+	users = decodeUsers(t, searchRecorder("gender=unknown"))
+	if actual := userIDs(users); !slices.Equal(actual, []int{1, 3}) {
+		t.Errorf("Expected: %v, got: %v", []int{1, 3}, actual)
+	}
+	users = decodeUsers(t, searchRecorder("gender=female&gender=unknown"))
+	if actual := userIDs(users); !slices.Equal(actual, []int{1, 2, 3}) {
+		t.Errorf("Expected: %v, got: %v", []int{1, 2, 3}, actual)
+	}
+}
+
+func TestSearchFieldHandle(t *testing.T) {
+	fileName = "testdata/handle.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		{"boydwolf", []int{0}},
+		{"@boydwolf", []int{0}},
+		{"@BOYD", []int{0}},
+		{"@hilda", []int{1}},
+		// Упоминание в About не считается handle
+		{"wolf", []int{0}},
+		{"@brooks", []int{}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder("search_field=handle&query="+url.QueryEscape(c.query)))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	if users := decodeUsers(t, searchRecorder("query=Boyd&case_insensitive=0")); len(users) != 1 || users[0].Handle != "@BoydWolf" {
+		t.Errorf("Expected handle in response, got: %v", users)
+	}
+}
+
+func TestWordBoundary(t *testing.T) {
+	cases := []struct {
+		about, query string
+		expected     bool
+	}{
+		{"Run the command", "an", false},
+		{"Run an errand", "an", true},
+		{"an errand", "an", true},
+		{"Run as an", "an", true},
+		{"Plan B, an option.", "an", true},
+		{"It was an, well", "an", true},
+		{"(an)", "an", true},
+		{"an_var", "an", false},
+		{"Mañana", "an", false},
+		{"Ends with an.", "an.", true},
+		{"Ends with anchor.", "an.", false},
+	}
+	params := &queryDTO{wordBoundary: true}
+	for _, c := range cases {
+		if actual := params.containsAbout(c.about, c.query); actual != c.expected {
+			t.Errorf("Expected: %v for %q in %q, got: %v", c.expected, c.query, c.about, actual)
+		}
+	}
+
+	params.caseInsensitive = true
+	if !params.containsAbout("AN errand", "an") {
+		t.Errorf("Expected case insensitive word match")
+	}
+
+	// "id" встречается в About и внутри слов, и отдельным словом
+	all := decodeUsers(t, searchRecorder("query=id&limit=0"))
+	words := decodeUsers(t, searchRecorder("query=id&word_boundary=1&limit=0"))
+	if len(all) != 32 || len(words) != 16 {
+		t.Errorf("Expected: 32 and 16, got: %v and %v", len(all), len(words))
+	}
+}
+
+func TestStrictFields(t *testing.T) {
+	for _, query := range []string{"order_field=height&order_by=1", "order_field=height&order_by=1&strict_fields=1"} {
+		w := searchRecorder(query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadOrderField) {
+			t.Errorf("Expected: %d for %s, got: %d %s", http.StatusBadRequest, query, w.Code, w.Body.String())
+		}
+	}
+
+	w := searchRecorder("order_field=height&order_by=1&strict_fields=0&limit=3")
+	users := decodeUsers(t, w)
+	if actual := userIDs(users); !slices.Equal(actual, []int{15, 16, 19}) {
+		t.Errorf("Expected name order, got: %v", actual)
+	}
+	if warnings := w.Header().Get("X-Search-Warnings"); !strings.Contains(warnings, "unknown order_field height") {
+		t.Errorf("Expected warning, got: %q", warnings)
+	}
+
+	// Известные поля в мягком режиме работают как обычно
+	w = searchRecorder("order_field=id&order_by=1&strict_fields=0&limit=3")
+	if actual := userIDs(decodeUsers(t, w)); !slices.Equal(actual, []int{0, 1, 2}) || w.Header().Get("X-Search-Warnings") != "" {
+		t.Errorf("Unexpected result: %v %q", actual, w.Header().Get("X-Search-Warnings"))
+	}
+}
+
+func TestCountFast(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	count, err := ts.client.CountFast(SearchRequest{Query: "e", Limit: 5, OrderField: "age", OrderBy: OrderByDesc})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	expected := len(decodeUsers(t, searchRecorder("query=e&limit=0")))
+	if count != expected {
+		t.Errorf("Expected: %v, got: %v", expected, count)
+	}
+
+	// Сервер без X-Rows-Matched: пользователи считаются по GET
+	heads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads++
+			return
+		}
+		SearchServer(w, r)
+	}))
+	defer server.Close()
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
+	if count, err = client.CountFast(SearchRequest{Query: "e"}); err != nil || count != expected || heads != 1 {
+		t.Errorf("Expected: %v, got: %v, %v, heads %d", expected, count, err, heads)
+	}
+
+	client.AccessToken = "invalid"
+	if _, err = client.CountFast(SearchRequest{}); err == nil || err.Error() != "bad AccessToken" {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
+
+func TestRequireAbout(t *testing.T) {
+	fileName = "testdata/about.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	if actual := userIDs(decodeUsers(t, searchRecorder(""))); !slices.Equal(actual, []int{0, 1, 2}) {
+		t.Errorf("Expected: %v, got: %v", []int{0, 1, 2}, actual)
+	}
+	// Пустой About и About из одних пробелов отбрасываются
+	if actual := userIDs(decodeUsers(t, searchRecorder("require_about=1"))); !slices.Equal(actual, []int{0}) {
+		t.Errorf("Expected: %v, got: %v", []int{0}, actual)
+	}
+}
+
+func TestOrderIDs(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		{"order_ids=5,2,9", []int{5, 2, 9}},
+		// order_field и order_by не влияют на порядок
+		{"order_ids=5,2,9&order_field=id&order_by=1", []int{5, 2, 9}},
+		// Отсутствующие и повторные id пропускаются
+		{"order_ids=34,100,0,34", []int{34, 0}},
+		// Фильтры применяются до упорядочивания
+		{"order_ids=0,1,2&gender=male", []int{0, 2}},
+		{"order_ids=9,5,2&limit=2", []int{9, 5}},
+		// query фильтрует, порядок остается из order_ids
+		{"order_ids=1,0,2&query=Boyd", []int{0}},
+		{"order_ids=34,2,0&query=voluptate", []int{2, 0}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder(c.query))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	w := searchRecorder("order_ids=1,x")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadOrderIDs) {
+		t.Errorf("Expected: %d, got: %d %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
+
+func TestPriorityIDs(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		// Больший вес раньше, равные веса по id, остальные после них по id
+		{"priority_ids=9:100,2:50,30:50&limit=5", []int{9, 2, 30, 0, 1}},
+		// Отрицательный вес ниже невзвешенных
+		{"priority_ids=0:-1,3:1&limit=3", []int{3, 1, 2}},
+		// По убыванию невзвешенные раньше
+		{"priority_ids=9:100&order_by=-1&limit=2", []int{0, 1}},
+		// С order_field приоритет задается среди полей
+		{"priority_ids=9:100&order_field=id&order_by=1&limit=2", []int{0, 1}},
+		{"priority_ids=9:100,5:100&order_field=priority,id:desc&limit=3", []int{9, 5, 34}},
+		{"priority_ids=9:100,2:50&gender=female&limit=2", []int{9, 1}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder(c.query))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	for _, query := range []string{"priority_ids=9", "priority_ids=x:1", "priority_ids=9:high", "priority_ids=9:NaN"} {
+		w := searchRecorder(query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadPriorityIDs) {
+			t.Errorf("Expected: %d for %s, got: %d %s", http.StatusBadRequest, query, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestStrictOrderIDs(t *testing.T) {
+	StrictOrderIDs = true
+	defer func() { StrictOrderIDs = false }()
+
+	w := searchRecorder("order_ids=1,0&query=Boyd")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorQueryWithOrderIDs) {
+		t.Errorf("Expected: %d %q, got: %d %s", http.StatusBadRequest, ErrorQueryWithOrderIDs, w.Code, w.Body.String())
+	}
+
+	// Каждый из параметров по отдельности разрешен
+	if actual := userIDs(decodeUsers(t, searchRecorder("order_ids=1,0"))); !slices.Equal(actual, []int{1, 0}) {
+		t.Errorf("Expected: %v, got: %v", []int{1, 0}, actual)
+	}
+	if actual := userIDs(decodeUsers(t, searchRecorder("query=Boyd"))); !slices.Equal(actual, []int{0}) {
+		t.Errorf("Expected: %v, got: %v", []int{0}, actual)
+	}
+}
+
+func TestFieldNameCharacters(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		// Пробелы по краям убираются, внутри имени - нет
+		{"order_field=" + url.QueryEscape("na me") + "&order_by=1", ErrorBadOrderField},
+		{"order_field=" + url.QueryEscape("age;drop") + "&order_by=1", ErrorBadOrderField},
+		{"order_field=" + url.QueryEscape("<id>"), ErrorBadOrderField},
+		// Проверка символов идет до мягкого режима strict_fields=0
+		{"order_field=" + url.QueryEscape("a-b") + "&order_by=1&strict_fields=0", ErrorBadOrderField},
+		{"search_field=" + url.QueryEscape("a ny") + "&query=Boyd", ErrorBadSearchField},
+		{"search_field=" + url.QueryEscape("../any"), ErrorBadSearchField},
+	}
+	for _, c := range cases {
+		w := searchRecorder(c.query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), c.expected) {
+			t.Errorf("Expected: %d %s for %s, got: %d %s", http.StatusBadRequest, c.expected, c.query, w.Code, w.Body.String())
+		}
+	}
+
+	if w := searchRecorder("order_field=about_words&order_by=1"); w.Code != http.StatusOK {
+		t.Errorf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestBirthMonth(t *testing.T) {
+	fileName = "testdata/birthday.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		month    string
+		expected []int
+	}{
+		{"3", []int{0, 1}},
+		{"03", []int{0, 1}},
+		{"12", []int{2}},
+		{"1", []int{}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder("birth_month="+c.month))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.month, actual)
+		}
+	}
+
+	for _, month := range []string{"0", "13", "march", "-1"} {
+		w := searchRecorder("birth_month=" + month)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadBirthMonth) {
+			t.Errorf("Expected: %d for %s, got: %d %s", http.StatusBadRequest, month, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestNoTotal(t *testing.T) {
+	full := decodeUsers(t, searchRecorder("limit=0"))
+
+	w := searchRecorder("no_total=1&offset=3&limit=4")
+	users := decodeUsers(t, w)
+	if !slices.Equal(userIDs(users), userIDs(full[3:7])) {
+		t.Errorf("Expected: %v, got: %v", userIDs(full[3:7]), userIDs(users))
+	}
+	if scanned := w.Header().Get("X-Rows-Scanned"); scanned != "7" {
+		t.Errorf("Expected early exit after 7 rows, got: %v", scanned)
+	}
+	if matched := w.Header().Get("X-Rows-Matched"); matched != "" {
+		t.Errorf("Unexpected X-Rows-Matched: %v", matched)
+	}
+
+	// Без no_total страница без сортировки не останавливается досрочно
+	// и отдает общее число найденных
+	if matched := searchRecorder("limit=4").Header().Get("X-Rows-Matched"); matched != strconv.Itoa(len(full)) {
+		t.Errorf("Expected X-Rows-Matched %d, got: %v", len(full), matched)
+	}
+	if warnings := searchRecorder("no_total=1&offset=100").Header().Get("X-Search-Warnings"); warnings != "" {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+	srchResp, err := ts.client.FindUsers(SearchRequest{Limit: 4, NoTotal: true})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if !slices.Equal(userIDs(srchResp.Users), userIDs(full[:4])) || srchResp.NextPage {
+		t.Errorf("Expected: %v without NextPage, got: %v %v", userIDs(full[:4]), userIDs(srchResp.Users), srchResp.NextPage)
+	}
+}
+
+func TestFindAllUsers(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	users, err := ts.client.FindAllUsers(SearchRequest{OrderField: "id", OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	expected := userIDs(decodeUsers(t, searchRecorder("order_field=id&order_by=1&limit=0")))
+	if !slices.Equal(userIDs(users), expected) {
+		t.Errorf("Expected: %v, got: %v", expected, userIDs(users))
+	}
+}
+
+func TestFindUsersBy(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	byID, err := ts.client.FindUsersBy(SearchRequest{Limit: 5}, "id")
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if len(byID) != 5 || byID["0"].Name != "Boyd Wolf" || byID["4"].ID != 4 {
+		t.Errorf("Unexpected users by id: %v", byID)
+	}
+
+	byName, err := ts.client.FindUsersBy(SearchRequest{Query: "Boyd"}, "name")
+	if err != nil || len(byName) != 1 || byName["Boyd Wolf"].ID != 0 {
+		t.Errorf("Unexpected users by name: %v, %v", byName, err)
+	}
+
+	// У многих пользователей одинаковый пол
+	if _, err = ts.client.FindUsersBy(SearchRequest{Limit: 5}, "gender"); err == nil || !strings.Contains(err.Error(), "duplicate gender") {
+		t.Errorf("Expected duplicate key error, got: %v", err)
+	}
+	if _, err = ts.client.FindUsersBy(SearchRequest{Limit: 5}, "password"); err == nil {
+		t.Error("Expected error for unknown key field")
+	}
+}
+
+func TestUserKeyPointer(t *testing.T) {
+	offset := 7
+	if value, ok := userKey(User{MatchOffset: &offset}, "MatchOffset"); !ok || value != "7" {
+		t.Errorf("Expected: %q, got: %q, %v", "7", value, ok)
+	}
+	if value, ok := userKey(User{}, "MatchOffset"); ok {
+		t.Errorf("Expected no key for nil pointer, got: %q", value)
+	}
+}
+
+func TestPaginator(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	paginator := ts.client.Paginate(SearchRequest{Limit: 10, OrderField: "age", OrderBy: OrderByAsc})
+	var (
+		users []User
+		pages int
+	)
+	for {
+		page, more, err := paginator.Next()
+		if err != nil {
+			t.Fatalf("Invalid error: %v", err.Error())
+		}
+		pages++
+		users = append(users, page...)
+		if !more {
+			break
+		}
+	}
+
+	expected := userIDs(decodeUsers(t, searchRecorder("order_field=age&order_by=1&limit=0")))
+	if pages != 4 || !slices.Equal(userIDs(users), expected) {
+		t.Errorf("Expected %v in 4 pages, got: %v in %d", expected, userIDs(users), pages)
+	}
+
+	// Исчерпанный Paginator больше не обращается к серверу
+	if page, more, err := paginator.Next(); page != nil || more || err != nil {
+		t.Errorf("Expected exhausted paginator, got: %v %v %v", page, more, err)
+	}
+}
+
+func TestFindAllUsersShiftingData(t *testing.T) {
+	// Между страницами в начало выдачи добавляется строка, и последняя
+	// строка первой страницы попадает на вторую
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset")) //nolint:errcheck
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))   //nolint:errcheck
+
+		data := make([]User, 0, 40)
+		if requests > 0 {
+			data = append(data, User{ID: 100})
+		}
+		for id := 0; id < 30; id++ {
+			data = append(data, User{ID: id})
+		}
+		requests++
+		sendResponse(w, paginateData(data, offset, limit))
+	}))
+	defer server.Close()
+
+	var duplicates []int
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
+	client.OnDuplicate = func(user User) { duplicates = append(duplicates, user.ID) }
+
+	users, err := client.FindAllUsers(SearchRequest{})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+
+	seen := map[int]bool{}
+	for _, user := range users {
+		if seen[user.ID] {
+			t.Errorf("Duplicate id %d", user.ID)
+		}
+		seen[user.ID] = true
+	}
+	if len(users) != 30 || !slices.Equal(duplicates, []int{24}) {
+		t.Errorf("Expected 30 users and duplicate 24, got: %v, %v", userIDs(users), duplicates)
+	}
+}
+
+func TestFindFirst(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	user, ok, err := ts.client.FindFirst(SearchRequest{Query: "Wolf"})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if !ok || user == nil || user.Name != "Boyd Wolf" {
+		t.Errorf("Expected Boyd Wolf, got: %v, %v", user, ok)
+	}
+
+	user, ok, err = ts.client.FindFirst(SearchRequest{OrderField: "age", OrderBy: OrderByDesc})
+	if err != nil || !ok || user.ID != 13 {
+		t.Errorf("Expected the oldest user, got: %v, %v, %v", user, ok, err)
+	}
+
+	user, ok, err = ts.client.FindFirst(SearchRequest{Query: "nobody-here"})
+	if err != nil || ok || user != nil {
+		t.Errorf("Expected no user, got: %v, %v, %v", user, ok, err)
+	}
+
+	// На сервере поиск останавливается на первом совпадении
+	if scanned := searchRecorder("query=Wolf&limit=1&no_total=1").Header().Get("X-Rows-Scanned"); scanned != "1" {
+		t.Errorf("Expected: %v, got: %v", 1, scanned)
+	}
+}
+
+func TestAnchor(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		// Начало имени и начало About
+		{"query=Nulla&anchor=start", []int{0, 19}},
+		{"query=Bo&anchor=start", []int{0}},
+		{"query=ol&anchor=start", []int{}},
+		// Jordan и Newman
+		{"query=an&anchor=end", []int{8, 14}},
+		{"query=Wolf&anchor=end", []int{0}},
+		{"query=Bo&anchor=end", []int{}},
+		{"query=BW&search_field=initials&anchor=start", []int{0, 22}},
+		{"query=W&search_field=initials&anchor=start", []int{13}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder(c.query+"&order_field=id&order_by=1&limit=0"))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	any := decodeUsers(t, searchRecorder("query=ol&limit=0"))
+	if none := decodeUsers(t, searchRecorder("query=ol&anchor=none&limit=0")); len(none) != len(any) || len(any) == 0 {
+		t.Errorf("Expected anchor=none to match substring search, got: %v and %v", len(none), len(any))
+	}
+
+	for _, query := range []string{"anchor=middle", "anchor=start&match_mode=soundex&query=Boid"} {
+		w := searchRecorder(query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadAnchor) {
+			t.Errorf("Expected: %d for %s, got: %d %s", http.StatusBadRequest, query, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSinceID(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	expected := userIDs(decodeUsers(t, searchRecorder("query=e&order_field=id&order_by=1&limit=0")))
+
+	var walked []int
+	sinceID := -1
+	for len(walked) <= len(expected) {
+		srchResp, err := ts.client.FindUsers(SearchRequest{Query: "e", Limit: 4, SinceID: &sinceID})
+		if err != nil {
+			t.Fatalf("Invalid error: %v", err.Error())
+		}
+		if len(srchResp.Users) == 0 {
+			break
+		}
+		walked = append(walked, userIDs(srchResp.Users)...)
+		sinceID = srchResp.Users[len(srchResp.Users)-1].ID
+	}
+	if !slices.Equal(walked, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, walked)
+	}
+
+	w := searchRecorder("since_id=30&order_field=age&order_by=-1&gender=male")
+	if actual := userIDs(decodeUsers(t, w)); !slices.Equal(actual, []int{31, 34}) {
+		t.Errorf("Expected: %v, got: %v", []int{31, 34}, actual)
+	}
+	if warnings := w.Header().Get("X-Search-Warnings"); warnings != "since_id sorts by id ascending" {
+		t.Errorf("Unexpected warnings: %q", warnings)
+	}
+
+	if w = searchRecorder("since_id=abc"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadSinceID) {
+		t.Errorf("Expected: %d, got: %d %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+	if plan := explainRecorder(t, "since_id=30"); !plan.IndexUsed {
+		t.Errorf("Expected since_id to use the id index, got: %+v", plan)
+	}
+}
+
+func TestExcludeIDs(t *testing.T) {
+	users := decodeUsers(t, searchRecorder("exclude_ids=0,%202,40&order_field=id&order_by=1&limit=3"))
+	if ids := userIDs(users); !slices.Equal(ids, []int{1, 3, 4}) {
+		t.Errorf("Expected: %v, got: %v", []int{1, 3, 4}, ids)
+	}
+	if w := searchRecorder("exclude_ids=1,x"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadExcludeIDs) {
+		t.Errorf("Expected: %d, got: %d %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
+
+func TestSearchModeHeaders(t *testing.T) {
+	cases := []struct {
+		query                string
+		mode, field, folding string
+	}{
+		{"query=Boyd", "contains", "default", "case"},
+		{"query=Boyd&case_insensitive=0", "contains", "default", "none"},
+		{"query=boyd&case_insensitive=1&search_field=any", "contains", "any", "case"},
+		{"query=munoz&normalize=1&case_insensitive=1", "contains", "default", "accent"},
+		{"query=Boid&match_mode=soundex", "soundex", "default", "case"},
+	}
+
+	for _, c := range cases {
+		w := searchRecorder(c.query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected: %d for %s, got: %d", http.StatusOK, c.query, w.Code)
+		}
+		got := []string{w.Header().Get("X-Search-Mode"), w.Header().Get("X-Search-Field"), w.Header().Get("X-Search-Folding")}
+		if expected := []string{c.mode, c.field, c.folding}; !slices.Equal(got, expected) {
+			t.Errorf("Expected: %v for %s, got: %v", expected, c.query, got)
+		}
+	}
+}
+
+func TestRelevanceFreshness(t *testing.T) {
+	fileName = "testdata/fresh.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		// Равные совпадения без freshness_boost упорядочены по id
+		{"", []int{0, 1, 2, 3}},
+		// Свежая строка 2 выше старой 1, строка без updated_at - в конце,
+		// два совпадения у 0 важнее свежести
+		{"&freshness_boost=0.5&now=2024-03-01T00:00:00Z", []int{0, 2, 1, 3}},
+		{"&freshness_boost=0", []int{0, 1, 2, 3}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder("query=cillum&order_field=relevance&order_by=1"+c.query))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	// Без updated_at свежесть ни на что не влияет
+	fileName = "testdata/about.xml"
+	plain := userIDs(decodeUsers(t, searchRecorder("order_field=relevance&order_by=1&limit=0")))
+	boosted := userIDs(decodeUsers(t, searchRecorder("order_field=relevance&order_by=1&limit=0&freshness_boost=0.5")))
+	if !slices.Equal(plain, boosted) {
+		t.Errorf("Expected: %v, got: %v", plain, boosted)
+	}
+
+	for _, query := range []string{"freshness_boost=-1", "freshness_boost=x", "now=yesterday"} {
+		if w := searchRecorder(query); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected: %d for %s, got: %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
+
+func TestSearchFieldName(t *testing.T) {
+	users := decodeUsers(t, searchRecorder("search_field=name&query="+url.QueryEscape("Wolf Boyd")))
+	if ids := userIDs(users); !slices.Equal(ids, []int{0}) {
+		t.Errorf("Expected: [0], got: %v", ids)
+	}
+
+	// Фраза в кавычках по-прежнему учитывает порядок слов
+	users = decodeUsers(t, searchRecorder("search_field=name&query="+url.QueryEscape(`"Wolf Boyd"`)))
+	if len(users) != 0 {
+		t.Errorf("Expected no users for quoted phrase, got: %v", userIDs(users))
+	}
+
+	users = decodeUsers(t, searchRecorder("search_field=name&query="+url.QueryEscape("Wolf Nobody")))
+	if len(users) != 0 {
+		t.Errorf("Expected no users with a wrong token, got: %v", userIDs(users))
+	}
+
+	// Совпадения в About не учитываются
+	for _, user := range decodeUsers(t, searchRecorder("search_field=name&query=nulla&limit=0")) {
+		if !strings.Contains(user.Name, "nulla") {
+			t.Errorf("Expected name match only, got: %v", user.Name)
+		}
+	}
+}
+
+func TestEmptyOffsetIs404(t *testing.T) {
+	// По умолчанию - пустой список с 200
+	if users := decodeUsers(t, searchRecorder("query=Boyd&offset=5")); len(users) != 0 {
+		t.Errorf("Expected: %v, got: %v", 0, len(users))
+	}
+
+	EmptyOffsetIs404 = true
+	defer func() { EmptyOffsetIs404 = false }()
+
+	w := searchRecorder("query=Boyd&offset=5")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected: %d, got: %d", http.StatusNotFound, w.Code)
+	}
+	errResp := SearchErrorResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil || errResp.Error != "offset 5 is beyond total 1" {
+		t.Errorf("Unexpected error body: %s", w.Body.String())
+	}
+
+	// Последняя непустая страница и offset=0 при пустой выдаче - как раньше
+	if users := decodeUsers(t, searchRecorder("query=Boyd&offset=0")); len(users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(users))
+	}
+	if users := decodeUsers(t, searchRecorder("query=nobody-here")); len(users) != 0 {
+		t.Errorf("Expected: %v, got: %v", 0, len(users))
+	}
+}
+
+func TestSearchFieldEmail(t *testing.T) {
+	fileName = "testdata/email.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		// Без "@" ищется только часть до "@": домен boyd.org не подходит
+		{"boyd", []int{0}},
+		{"BOYD.WOLF", []int{0}},
+		{" boyd.wolf@example.com ", []int{0}},
+		{"hilda@boyd.org", []int{1}},
+		{"@example.com", []int{0, 3}},
+		{"brooks", []int{}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder("search_field=email&query="+url.QueryEscape(c.query)))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %q, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	// Без email - первыми, дальше по адресу без учета регистра
+	users := decodeUsers(t, searchRecorder("order_field=email&order_by=1"))
+	if actual := userIDs(users); !slices.Equal(actual, []int{2, 3, 0, 1}) {
+		t.Errorf("Expected: %v, got: %v", []int{2, 3, 0, 1}, actual)
+	}
+	if users[2].Email != "Boyd.Wolf@Example.com" {
+		t.Errorf("Expected email in response, got: %q", users[2].Email)
+	}
+}
+
+func TestActiveFilter(t *testing.T) {
+	fileName = "testdata/active.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		// Строка без <active> считается активной
+		{"active=true", []int{0, 2}},
+		{"active=1", []int{0, 2}},
+		{"active=false", []int{1, 3}},
+		{"", []int{0, 1, 2, 3}},
+		{"active=false&gender=male", []int{3}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder(c.query))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %q, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	w := searchRecorder("active=maybe")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadActive) {
+		t.Errorf("Expected: %d %q, got: %d %s", http.StatusBadRequest, ErrorBadActive, w.Code, w.Body.String())
+	}
+}
+
+func TestSearchFieldPhone(t *testing.T) {
+	fileName = "testdata/phone.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		// Цифры в About не учитываются
+		{"5551234", []int{0}},
+		{"(555) 123-4567", []int{0}},
+		{"555-987", []int{1}},
+		{"1555", []int{1}},
+		{"555", []int{0, 1}},
+		// Без цифр ничего не находится
+		{"phone", []int{}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder("search_field=phone&query="+url.QueryEscape(`"`+c.query+`"`)))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %q, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	users := decodeUsers(t, searchRecorder("search_field=phone&query=5551234&fields=id,phone"))
+	if len(users) != 1 || users[0].Phone != "(555) 123-4567" {
+		t.Errorf("Expected phone in response, got: %v", users)
+	}
+	// Без fields= телефон не отдается
+	users = decodeUsers(t, searchRecorder("search_field=phone&query=5551234"))
+	if len(users) != 1 || users[0].Phone != "" {
+		t.Errorf("Expected no phone by default, got: %v", users)
+	}
+}
+
+func TestOrderFieldDirections(t *testing.T) {
+	check := func(query string) []User {
+		t.Helper()
+		users := decodeUsers(t, searchRecorder(query+"&limit=0"))
+		for idx := 1; idx < len(users); idx++ {
+			prev, user := users[idx-1], users[idx]
+			if prev.Age < user.Age || prev.Age == user.Age && prev.Name > user.Name {
+				t.Errorf("Expected age desc, name asc for %s, got %v before %v", query, prev, user)
+			}
+		}
+		return users
+	}
+
+	users := check("order_field=" + url.QueryEscape("age:desc,name:asc"))
+	if len(users) == 0 || users[0].Age != 40 {
+		t.Fatalf("Expected oldest first, got: %v", users)
+	}
+
+	// Поле без направления берет его из order_by
+	check("order_field=" + url.QueryEscape("age,name:asc") + "&order_by=-1")
+	check("order_field=" + url.QueryEscape("age:desc,name") + "&order_by=1")
+
+	for _, query := range []string{"order_field=age:up", "order_field=age:desc,unknown", "order_field=age:desc,name&cursor=x"} {
+		if w := searchRecorder(query); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected: %d for %s, got: %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
+
+func TestMaxMatchAboutLen(t *testing.T) {
+	fileName = "testdata/about.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	// "Nulla cillum enim voluptate." - voluptate занимает символы с 19-го по 27-й
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		{"query=voluptate", []int{0}},
+		{"query=voluptate&max_match_about_len=26", []int{}},
+		{"query=voluptate&max_match_about_len=27", []int{0}},
+		{"query=cillum&max_match_about_len=16", []int{0}},
+		// Имя ищется целиком независимо от ограничения
+		{"query=Boyd&max_match_about_len=1", []int{0}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder(c.query))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	MaxMatchAboutLen = 16
+	defer func() { MaxMatchAboutLen = 0 }()
+	if users := decodeUsers(t, searchRecorder("query=voluptate")); len(users) != 0 {
+		t.Errorf("Expected no users with MaxMatchAboutLen, got: %v", userIDs(users))
+	}
+	if users := decodeUsers(t, searchRecorder("query=voluptate&max_match_about_len=0")); len(users) != 1 {
+		t.Errorf("Expected max_match_about_len=0 to search the whole About, got: %v", userIDs(users))
+	}
+
+	if w := searchRecorder("max_match_about_len=-1"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	cases := []struct {
+		s        string
+		n        int
+		expected string
+	}{
+		{"Muñoz", 3, "Muñ"},
+		{"Muñoz", 5, "Muñoz"},
+		{"Muñoz", 10, "Muñoz"},
+		{"Muñoz", 0, "Muñoz"},
+	}
+	for _, c := range cases {
+		if actual := truncateRunes(c.s, c.n); actual != c.expected {
+			t.Errorf("Expected: %q for %q/%d, got: %q", c.expected, c.s, c.n, actual)
+		}
+	}
+}
+
+func TestParamAliases(t *testing.T) {
+	cases := []struct {
+		alias, canonical string
+	}{
+		{"sort=name&dir=1", "order_field=name&order_by=1"},
+		{"sort=age&dir=-1&limit=0", "order_field=age&order_by=-1&limit=0"},
+		{"q=Boyd", "query=Boyd"},
+	}
+	for _, c := range cases {
+		expected := searchRecorder(c.canonical)
+		actual := searchRecorder(c.alias)
+		if actual.Body.String() != expected.Body.String() {
+			t.Errorf("Expected %s to behave as %s", c.alias, c.canonical)
+		}
+	}
+
+	// Канонический параметр важнее псевдонима
+	w := searchRecorder("order_field=age&sort=name&order_by=1&limit=0")
+	if userIDs(decodeUsers(t, w))[0] != userIDs(decodeUsers(t, searchRecorder("order_field=age&order_by=1&limit=0")))[0] {
+		t.Errorf("Expected order_field to win over sort")
+	}
+	if warnings := w.Header().Get("X-Search-Warnings"); !strings.Contains(warnings, "sort ignored") {
+		t.Errorf("Expected warning about sort, got: %s", warnings)
+	}
+}
+
+func TestClientDebugLogger(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	var log bytes.Buffer
+	client := NewSearchClient(accessToken, ts.server.URL, WithDebugLogger(&log))
+	if _, err := client.FindUsers(SearchRequest{Query: "Boyd", Limit: 5}); err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	// Токен, случайно попавший в URL, тоже скрывается
+	if _, err := client.FindUsers(SearchRequest{Limit: 5, Extra: map[string]string{"note": accessToken}}); err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected: 2 lines, got: %q", log.String())
+	}
+	if !strings.Contains(lines[0], ts.server.URL+"?") || !strings.Contains(lines[0], "query=Boyd") ||
+		!strings.Contains(lines[0], "-> 200 OK in ") {
+		t.Errorf("Expected URL and status, got: %s", lines[0])
+	}
+	if strings.Contains(log.String(), accessToken) || !strings.Contains(lines[1], "note=REDACTED") {
+		t.Errorf("Expected token to be redacted, got: %s", log.String())
+	}
+
+	bad := NewSearchClient(accessToken+"invalid", ts.server.URL, WithDebugLogger(&log))
+	log.Reset()
+	_, _ = bad.FindUsers(SearchRequest{Limit: 5}) //nolint:errcheck
+	if !strings.Contains(log.String(), "-> 401 Unauthorized") || strings.Contains(log.String(), accessToken) {
+		t.Errorf("Expected 401 without token, got: %s", log.String())
+	}
+}
+
+func TestAboutParagraphs(t *testing.T) {
+	fileName = "testdata/paragraphs.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		// Фраза на стыке абзацев не совпадает, внутри абзаца - совпадает
+		{`"enim voluptate"`, []int{1}},
+		{`"cillum enim"`, []int{0}},
+		{`"voluptate velit"`, []int{0}},
+		// Отдельные слова могут быть в разных абзацах
+		{"enim voluptate", []int{0, 1}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder("query="+url.QueryEscape(c.query)))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	users := decodeUsers(t, searchRecorder("query=Boyd"))
+	if expected := "Nulla cillum enim\n\nvoluptate velit esse."; len(users) != 1 || users[0].About != expected {
+		t.Errorf("Expected: %q, got: %v", expected, users)
+	}
+
+	// max_match_about_len считается по About целиком: второй абзац
+	// начинается с 20-го символа
+	if users := decodeUsers(t, searchRecorder("query=velit&max_match_about_len=19")); len(users) != 0 {
+		t.Errorf("Expected no users, got: %v", userIDs(users))
+	}
+	if users := decodeUsers(t, searchRecorder("query=velit&max_match_about_len=34")); len(users) != 1 {
+		t.Errorf("Expected: 1 user, got: %v", userIDs(users))
+	}
+}
+
+func TestEmptyQueryBehavior(t *testing.T) {
+	total := len(decodeUsers(t, searchRecorder("limit=0")))
+	defer func() { EmptyQueryBehavior = EmptyQueryAll }()
+
+	EmptyQueryBehavior = EmptyQueryNone
+	for _, query := range []string{"limit=0", "query=++&limit=0"} {
+		if users := decodeUsers(t, searchRecorder(query)); len(users) != 0 {
+			t.Errorf("Expected no users for %s, got: %v", query, len(users))
+		}
+	}
+	if users := decodeUsers(t, searchRecorder("query=Boyd")); len(users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(users))
+	}
+	if users := decodeUsers(t, searchRecorder("order_ids=2,1")); len(users) != 2 {
+		t.Errorf("Expected order_ids to be allowed, got: %v", userIDs(users))
+	}
+
+	EmptyQueryBehavior = EmptyQueryError
+	w := searchRecorder("limit=0")
+	errResp := SearchErrorResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &errResp); w.Code != http.StatusBadRequest || err != nil || errResp.Error != ErrorEmptyQuery {
+		t.Errorf("Expected: %d %s, got: %d %s", http.StatusBadRequest, ErrorEmptyQuery, w.Code, w.Body.String())
+	}
+	if users := decodeUsers(t, searchRecorder("query=Boyd")); len(users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(users))
+	}
+
+	EmptyQueryBehavior = EmptyQueryAll
+	if users := decodeUsers(t, searchRecorder("limit=0")); len(users) != total {
+		t.Errorf("Expected: %v, got: %v", total, len(users))
+	}
+}
+
+func TestDistinctNames(t *testing.T) {
+	fileName = "testdata/samenames.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		{"", []int{5, 1, 2}},
+		// Из двух Boyd Wolf остается id 2 на месте первого
+		{"distinct_names=1", []int{2, 1}},
+		{"distinct_names=1&query=Boyd", []int{2}},
+		// Фильтр раньше схлопывания: id 2 не подходит, остается 5
+		{"distinct_names=1&query=Nulla", []int{5}},
+		{"distinct_names=1&order_field=age&order_by=-1", []int{2, 1}},
+		// Пагинация после схлопывания
+		{"distinct_names=1&limit=1&offset=1", []int{1}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder(c.query))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, actual)
+		}
+	}
+}
+
+func TestNameFormat(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		{"query=Boyd", "Boyd Wolf"},
+		{"query=Boyd&name_format=" + url.QueryEscape("{last}, {first}"), "Wolf, Boyd"},
+		{"query=Boyd&name_format=" + url.QueryEscape("{last}"), "Wolf"},
+		// Поиск по имени от шаблона не зависит
+		{"query=" + url.QueryEscape("Boyd Wolf") + "&name_format=" + url.QueryEscape("{last} {first}"), "Wolf Boyd"},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder(c.query))
+		if len(users) != 1 || users[0].Name != c.expected {
+			t.Errorf("Expected: %s for %s, got: %v", c.expected, c.query, users)
+		}
+	}
+
+	DefaultNameFormat = "{last} {first}"
+	defer func() { DefaultNameFormat = "{first} {last}" }()
+	if users := decodeUsers(t, searchRecorder("query=Boyd")); len(users) != 1 || users[0].Name != "Wolf Boyd" {
+		t.Errorf("Expected: Wolf Boyd, got: %v", users)
+	}
+
+	if w := searchRecorder("name_format=" + url.QueryEscape("{middle}")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestCollapseSpaces(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		{"query=BoydWolf", []int{}},
+		{"query=BoydWolf&collapse_spaces=1", []int{0}},
+		{"query=" + url.QueryEscape(`"Boy dWolf"`) + "&collapse_spaces=1", []int{0}},
+		{"query=boydwolf&collapse_spaces=1&case_insensitive=1", []int{0}},
+		{"query=BoydWolf&collapse_spaces=1&search_field=name", []int{0}},
+	}
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder(c.query))
+		if actual := userIDs(users); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	// About в ответе не меняется
+	users := decodeUsers(t, searchRecorder("query=BoydWolf&collapse_spaces=1"))
+	expected := decodeUsers(t, searchRecorder("query=Boyd"))
+	if len(users) != 1 || users[0].About != expected[0].About {
+		t.Errorf("Expected About unchanged, got: %v", users)
+	}
+}
+
+func TestMinMatch(t *testing.T) {
+	terms := []string{"Boyd", "voluptate", "nostrud"}
+	counts := map[int]int{}
+	for _, term := range terms {
+		for _, user := range decodeUsers(t, searchRecorder("limit=0&query="+term)) {
+			counts[user.ID]++
+		}
+	}
+	var expected []int
+	for id, count := range counts {
+		if count >= 2 {
+			expected = append(expected, id)
+		}
+	}
+	slices.Sort(expected)
+
+	actual := userIDs(decodeUsers(t, searchRecorder("limit=0&order_field=id&order_by=1&min_match=2&query="+strings.Join(terms, "+"))))
+	if len(expected) < 2 || !slices.Equal(actual, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, actual)
+	}
+
+	// min_match больше числа слов означает совпадение всех слов
+	all := userIDs(decodeUsers(t, searchRecorder("limit=0&query="+strings.Join(terms, "+"))))
+	if clamped := userIDs(decodeUsers(t, searchRecorder("limit=0&min_match=5&query="+strings.Join(terms, "+")))); !slices.Equal(clamped, all) {
+		t.Errorf("Expected: %v, got: %v", all, clamped)
+	}
+
+	if w := searchRecorder("min_match=-1&query=Boyd"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestFieldMatch(t *testing.T) {
+	// "Ma" - начало имени у 1, 6 и 10 и подстрока About у 20, 25 и 34
+	expected := []int{1, 6, 10, 20, 25, 34}
+	actual := userIDs(decodeUsers(t, searchRecorder("limit=0&order_field=id&order_by=1&case_insensitive=0&match=name:prefix,about:substring&query=Ma")))
+	if !slices.Equal(actual, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, actual)
+	}
+
+	if names := userIDs(decodeUsers(t, searchRecorder("limit=0&match=name:prefix&query=Ev"))); !slices.Equal(names, []int{3}) {
+		t.Errorf("Expected: %v, got: %v", []int{3}, names)
+	}
+	if names := userIDs(decodeUsers(t, searchRecorder("limit=0&match=name:suffix&query=Ev"))); len(names) != 0 {
+		t.Errorf("Expected no users, got: %v", names)
+	}
+	if names := userIDs(decodeUsers(t, searchRecorder("limit=0&match=name:exact&query=Everett"))); !slices.Equal(names, []int{3}) {
+		t.Errorf("Expected: %v, got: %v", []int{3}, names)
+	}
+
+	for _, query := range []string{
+		"match=gender:prefix&query=Ma",
+		"match=name:fuzzy&query=Ma",
+		"match=name&query=Ma",
+		"match=name:prefix&search_field=location&query=Ma",
+		"match=name:prefix&match_mode=soundex&query=Ma",
+	} {
+		w := searchRecorder(query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadFieldMatch) {
+			t.Errorf("%s: expected: %d, got: %d %s", query, http.StatusBadRequest, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestMinVisibleAge(t *testing.T) {
+	MinVisibleAge = 25
+	defer func() { MinVisibleAge = 0 }()
+
+	for _, query := range []string{"limit=0", "limit=0&query=e", "limit=0&order_field=age&order_by=1", "limit=0&gender=male,female,unknown"} {
+		users := decodeUsers(t, searchRecorder(query))
+		if len(users) == 0 {
+			t.Errorf("%s: expected users", query)
+		}
+		for _, user := range users {
+			if user.Age < 25 {
+				t.Errorf("%s: unexpected user %d with age %d", query, user.ID, user.Age)
+			}
+		}
+	}
+}
+
+func TestStopWords(t *testing.T) {
+	all := userIDs(decodeUsers(t, searchRecorder("limit=0")))
+	if ids := userIDs(decodeUsers(t, searchRecorder("limit=0&query=the+a"))); len(ids) == len(all) {
+		t.Fatalf("Expected stop words to be searched without StopWords, got: %v", ids)
+	}
+
+	StopWords = []string{"the", "a"}
+	defer func() { StopWords = nil }()
+
+	if ids := userIDs(decodeUsers(t, searchRecorder("limit=0&query=the+a"))); !slices.Equal(ids, all) {
+		t.Errorf("Expected: %v, got: %v", all, ids)
+	}
+	if ids := userIDs(decodeUsers(t, searchRecorder("limit=0&query=The+Boyd"))); !slices.Equal(ids, []int{0}) {
+		t.Errorf("Expected: %v, got: %v", []int{0}, ids)
+	}
+
+	EmptyQueryBehavior = EmptyQueryNone
+	defer func() { EmptyQueryBehavior = EmptyQueryAll }()
+	if ids := userIDs(decodeUsers(t, searchRecorder("limit=0&query=the+a"))); len(ids) != 0 {
+		t.Errorf("Expected no users, got: %v", ids)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	if cc := searchRecorder("query=Boyd").Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("Expected no Cache-Control without CacheMaxAge, got: %s", cc)
+	}
+
+	CacheMaxAge = 60
+	defer func() { CacheMaxAge = 0 }()
+
+	w := searchRecorder("query=Boyd")
+	if cc := w.Header().Get("Cache-Control"); w.Code != http.StatusOK || cc != "private, max-age=60" {
+		t.Errorf("Expected private, max-age=60, got: %d %s", w.Code, cc)
+	}
+	// Ответ зависит от токена и согласования формата
+	if vary := w.Header().Get("Vary"); vary != "AccessToken, Accept, Accept-Language" {
+		t.Errorf("Expected Vary by token and Accept headers, got: %q", vary)
+	}
+	w = searchRecorder("limit=-1")
+	if cc := w.Header().Get("Cache-Control"); w.Code != http.StatusBadRequest || cc != "no-store" {
+		t.Errorf("Expected no-store, got: %d %s", w.Code, cc)
+	}
+
+	w = httptest.NewRecorder()
+	SearchServer(w, httptest.NewRequest("GET", "/?query=Boyd", nil))
+	if cc := w.Header().Get("Cache-Control"); w.Code != http.StatusUnauthorized || cc != "no-store" {
+		t.Errorf("Expected no-store, got: %d %s", w.Code, cc)
+	}
+	// max-age только у поиска
+	if cc := reloadRecorder().Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("Expected no Cache-Control on reload, got: %s", cc)
+	}
+}
+
+func TestMaxOffset(t *testing.T) {
+	MaxOffset = 10
+	defer func() { MaxOffset = 0 }()
+
+	if users := decodeUsers(t, searchRecorder("offset=10&limit=1")); len(users) != 1 || users[0].ID != 10 {
+		t.Errorf("Expected user 10, got: %v", users)
+	}
+	w := searchRecorder("offset=11&limit=1")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "use cursor pagination") {
+		t.Errorf("Expected: %d with cursor hint, got: %d %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
+
+func TestFieldNameNormalization(t *testing.T) {
+	expected := userIDs(decodeUsers(t, searchRecorder("order_field=name&order_by=1&limit=0")))
+	for _, field := range []string{"%20Name%20", "NAME", "Name%09"} {
+		w := searchRecorder("order_field=" + field + "&order_by=1&limit=0")
+		if ids := userIDs(decodeUsers(t, w)); w.Code != http.StatusOK || !slices.Equal(ids, expected) {
+			t.Errorf("%s: expected: %v, got: %d %v", field, expected, w.Code, ids)
+		}
+	}
+	if ids := userIDs(decodeUsers(t, searchRecorder("order_field=Age:DESC,%20ID&limit=0"))); !slices.Equal(ids, userIDs(decodeUsers(t, searchRecorder("order_field=age:desc,id&limit=0")))) {
+		t.Errorf("Expected same order as lowercase keys, got: %v", ids)
+	}
+	if users := decodeUsers(t, searchRecorder("search_field=%20Email&query=boydwolf")); len(users) != 1 {
+		t.Errorf("Expected one user, got: %v", users)
+	}
+
+	w := searchRecorder("order_field=nope&order_by=1")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadOrderField) {
+		t.Errorf("Expected %s, got: %d %s", ErrorBadOrderField, w.Code, w.Body.String())
+	}
+	if w := searchRecorder("search_field=Nope"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestOrderByDesc(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	find := func(field string, orderBy int) []User {
+		t.Helper()
+		resp, err := ts.client.FindUsers(SearchRequest{OrderField: field, OrderBy: orderBy, Limit: 25})
+		if err != nil {
+			t.Fatalf("Invalid error: %v", err)
+		}
+		return resp.Users
+	}
+	all := func(query string) []User {
+		return decodeUsers(t, searchRecorder("limit=0&"+query))
+	}
+
+	// name и id уникальны: по убыванию - ровно обратный порядок
+	for _, field := range []string{"name", "id"} {
+		asc := userIDs(all("order_field=" + field + "&order_by=1"))
+		slices.Reverse(asc)
+		if desc := userIDs(all("order_field=" + field + "&order_by=-1")); !slices.Equal(desc, asc) {
+			t.Errorf("%s: expected: %v, got: %v", field, asc, desc)
+		}
+		if desc := userIDs(find(field, OrderByDesc)); !slices.Equal(desc, asc[:25]) {
+			t.Errorf("%s: expected: %v, got: %v", field, asc[:25], desc)
+		}
+	}
+
+	// Возраст повторяется, равные по-прежнему идут по возрастанию id
+	desc := all("order_field=age&order_by=-1")
+	for idx := 1; idx < len(desc); idx++ {
+		prev, cur := desc[idx-1], desc[idx]
+		if prev.Age < cur.Age || prev.Age == cur.Age && prev.ID > cur.ID {
+			t.Errorf("Unexpected order: %d (%d) before %d (%d)", prev.ID, prev.Age, cur.ID, cur.Age)
+		}
+	}
+	if users := find("age", OrderByDesc); users[0].Age != desc[0].Age {
+		t.Errorf("Expected oldest first, got: %v", users[0])
+	}
+
+	// OrderByAsIs не сортирует
+	if asIs := userIDs(find("age", OrderByAsIs)); !slices.Equal(asIs, userIDs(all(""))[:25]) {
+		t.Errorf("Expected file order, got: %v", asIs)
+	}
+}
+
+func TestOrderByAboutAndGender(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	cases := []struct {
+		field    string
+		expected []int
+	}{
+		// Лексикографически по тексту About
+		{"about", []int{27, 13, 16, 28, 20, 23, 8, 4, 31, 21}},
+		// Сначала female, внутри - по возрастанию id
+		{"gender", []int{1, 5, 7, 9, 16, 22, 25, 27, 29, 32, 33, 0, 2, 3}},
+	}
+	for _, item := range cases {
+		resp, err := ts.client.FindUsers(SearchRequest{OrderField: item.field, OrderBy: OrderByAsc, Limit: len(item.expected)})
+		if err != nil {
+			t.Fatalf("%s: invalid error: %v", item.field, err)
+		}
+		if ids := userIDs(resp.Users); !slices.Equal(ids, item.expected) {
+			t.Errorf("%s: expected: %v, got: %v", item.field, item.expected, ids)
+		}
+
+		users := decodeUsers(t, searchRecorder("limit=0&order_by=1&order_field="+item.field))
+		for idx := 1; idx < len(users); idx++ {
+			prev, cur := users[idx-1], users[idx]
+			if item.field == "about" && prev.About > cur.About || item.field == "gender" && prev.Gender > cur.Gender {
+				t.Errorf("%s: unexpected order: %d before %d", item.field, prev.ID, cur.ID)
+			}
+		}
+	}
+
+	// Запрос по-прежнему ищет по всем полям
+	users := decodeUsers(t, searchRecorder("query=Boyd&order_field=gender&order_by=1"))
+	if len(users) != 1 || users[0].ID != 0 {
+		t.Errorf("Expected query to match Boyd, got: %v", userIDs(users))
+	}
+
+	if w := searchRecorder("order_field=abouts&order_by=1"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown order_field, got: %d", w.Code)
+	}
+}
+
+func TestPaginateBoundaries(t *testing.T) {
+	data := make([]User, 5)
+	for idx := range data {
+		data[idx].ID = idx
+	}
+
+	cases := []struct {
+		offset, limit int
+		expected      []int
+	}{
+		{0, 1, []int{0}},
+		{0, 0, []int{0, 1, 2, 3, 4}},
+		{0, 5, []int{0, 1, 2, 3, 4}},
+		{0, 6, []int{0, 1, 2, 3, 4}},
+		{3, 1, []int{3}},
+		{3, 2, []int{3, 4}},
+		{3, 100, []int{3, 4}},
+		{4, 1, []int{4}},
+		{4, 100, []int{4}},
+		{4, 0, []int{4}},
+		{5, 1, []int{}},
+		{6, 100, []int{}},
+	}
+	for _, item := range cases {
+		page := paginateData(data, item.offset, item.limit)
+		if page == nil || !slices.Equal(userIDs(page), item.expected) {
+			t.Errorf("offset=%d limit=%d: expected: %v, got: %v", item.offset, item.limit, item.expected, userIDs(page))
+		}
+	}
+
+	// То же через сервер на конце файла
+	all := userIDs(decodeUsers(t, searchRecorder("limit=0")))
+	last := len(all) - 1
+	users := decodeUsers(t, searchRecorder("offset="+strconv.Itoa(last)+"&limit=100"))
+	if !slices.Equal(userIDs(users), all[last:]) {
+		t.Errorf("Expected %v, got: %v", all[last:], userIDs(users))
+	}
+
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+	resp, err := ts.client.FindUsers(SearchRequest{Limit: 1})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	if len(resp.Users) != 1 || !resp.NextPage {
+		t.Errorf("Expected one user and next page, got: %v %v", userIDs(resp.Users), resp.NextPage)
+	}
+	resp, err = ts.client.FindUsers(SearchRequest{Offset: last, Limit: 1})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	if !slices.Equal(userIDs(resp.Users), all[last:]) || resp.NextPage {
+		t.Errorf("Expected last user without next page, got: %v %v", userIDs(resp.Users), resp.NextPage)
+	}
+}
+
+func TestTotalCount(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+
+	total := len(decodeUsers(t, searchRecorder("limit=0")))
+	boyd := len(decodeUsers(t, searchRecorder("query=Boyd&limit=0")))
+	cases := []struct {
+		req      SearchRequest
+		expected int
+	}{
+		{SearchRequest{Limit: 10}, total},
+		{SearchRequest{Limit: 10, Offset: 20}, total},
+		{SearchRequest{Limit: 1, Offset: total - 1}, total},
+		{SearchRequest{Limit: 5, OrderField: "age", OrderBy: OrderByAsc}, total},
+		{SearchRequest{Query: "Boyd", Limit: 1}, boyd},
+		{SearchRequest{Limit: 10, Offset: total + 5}, total},
+		{SearchRequest{Limit: 10, NoTotal: true}, -1},
+	}
+	for _, item := range cases {
+		resp, err := ts.client.FindUsers(item.req)
+		if err != nil {
+			t.Fatalf("%+v: invalid error: %v", item.req, err)
+		}
+		if resp.TotalCount != item.expected {
+			t.Errorf("%+v: expected: %d, got: %d", item.req, item.expected, resp.TotalCount)
+		}
+	}
+
+	// Без клиента общее число тоже отдается по умолчанию
+	if matched := searchRecorder("limit=4").Header().Get("X-Rows-Matched"); matched != strconv.Itoa(total) {
+		t.Errorf("Expected X-Rows-Matched %d, got: %v", total, matched)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// Ошибки разбора параметра cursor
+const (
+	ErrorBadCursor      = `cursor invalid`
+	ErrorCursorNeedSort = `cursor requires order_by`
+	// after_age и after_id задаются только вместе, целыми числами,
+	// при order_field=age&order_by=1 и без cursor
+	ErrorBadKeyset = `after_age and after_id invalid`
+	// direction не next и не prev или prev без cursor
+	ErrorBadDirection = `direction must be next or prev with cursor`
+)
+
+// Направление листания от cursor (параметр direction)
+const (
+	// Страница после курсора, как без direction
+	directionNext = "next"
+	// Страница перед курсором, курсор берется из X-Prev-Cursor
+	directionPrev = "prev"
+)
+
+// Содержимое курсора: поле сортировки, его значение и ID последнего
+// показанного пользователя. Вместе с ID значение однозначно задает позицию
+type cursorData struct {
+	Field string          `json:"f"`
+	Key   json.RawMessage `json:"k"`
+	ID    int             `json:"id"`
+}
+
+// encodeCursor кодирует позицию пользователя в непрозрачный курсор (base64 JSON)
+func encodeCursor(user User, orderField string) string {
+	key, _ := json.Marshal(sortKeyRef(&user, orderField))                      //nolint:errcheck
+	b, _ := json.Marshal(cursorData{Field: orderField, Key: key, ID: user.ID}) //nolint:errcheck
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor восстанавливает из курсора пользователя с полями, нужными
+// для сравнения. Курсор от другого order_field считается невалидным
+func decodeCursor(cursor, orderField string) (*User, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, &paramError{ErrorBadCursor}
+	}
+
+	data := cursorData{}
+	if err = json.Unmarshal(b, &data); err != nil || data.Field != orderField {
+		return nil, &paramError{ErrorBadCursor}
+	}
+
+	user := &User{ID: data.ID}
+	if err = json.Unmarshal(data.Key, sortKeyRef(user, orderField)); err != nil {
+		return nil, &paramError{ErrorBadCursor}
+	}
+	return user, nil
+}
+
+// parseKeyset разбирает позицию after_age/after_id для выдачи по возрастанию
+// возраста. Это явная форма курсора для order_field=age, nil - позиции нет
+func parseKeyset(afterAge, afterID string, params *queryDTO) (*User, error) {
+	if afterAge == "" && afterID == "" {
+		return nil, nil
+	}
+	if params.orderField != "age" || params.orderBy != OrderByAsc || params.after != nil || params.before != nil {
+		return nil, &paramError{ErrorBadKeyset}
+	}
+
+	age, err := strconv.Atoi(afterAge)
+	if err != nil {
+		return nil, &paramError{ErrorBadKeyset}
+	}
+	id, err := strconv.Atoi(afterID)
+	if err != nil {
+		return nil, &paramError{ErrorBadKeyset}
+	}
+	return &User{ID: id, Age: age}, nil
+}
+
+// resultAfter возвращает отсортированных пользователей строго после after
+func resultAfter(data []User, after User, isLess func(a, b User) bool) []User {
+	idx := sort.Search(len(data), func(i int) bool {
+		return isLess(after, data[i])
+	})
+	return data[idx:]
+}
+
+// resultBefore возвращает отсортированных пользователей строго перед before
+func resultBefore(data []User, before User, isLess func(a, b User) bool) []User {
+	idx := sort.Search(len(data), func(i int) bool {
+		return !isLess(data[i], before)
+	})
+	return data[:idx]
+}
+
+// paginateBefore отсчитывает offset и limit от конца: пользователи
+// разворачиваются, режутся как обычная страница и разворачиваются обратно,
+// чтобы страница шла в порядке сортировки
+func paginateBefore(data []User, offset, limit int) []User {
+	reversed := slices.Clone(data)
+	slices.Reverse(reversed)
+	page := paginateData(reversed, offset, limit)
+	slices.Reverse(page)
+	return page
+}
+
+// setPageCursors выставляет X-Next-Cursor, если после страницы page есть
+// еще пользователи, и X-Prev-Cursor, если они есть перед ней
+func setPageCursors(w http.ResponseWriter, result, page []User, params *queryDTO) {
+	if params.orderBy == OrderByAsIs || len(page) == 0 {
+		return
+	}
+
+	var hasNext, hasPrev bool
+	if params.before != nil {
+		// После страницы как минимум пользователь из курсора
+		hasNext = true
+		hasPrev = params.offset+len(page) < len(result)
+	} else {
+		hasNext = params.offset+len(page) < len(result)
+		hasPrev = params.offset > 0 || params.after != nil
+	}
+	if hasNext {
+		w.Header().Set("X-Next-Cursor", encodeCursor(page[len(page)-1], params.orderField))
+	}
+	if hasPrev {
+		w.Header().Set("X-Prev-Cursor", encodeCursor(page[0], params.orderField))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCursorPaging(t *testing.T) {
+	for _, orderField := range []string{"age", "name", "id"} {
+		expected := decodeUsers(t, searchRecorder("limit=0&order_by=1&order_field="+orderField))
+
+		var (
+			seen   = map[int]bool{}
+			cursor string
+			walked []User
+		)
+		for page := 0; page < 10; page++ {
+			params := url.Values{"order_field": {orderField}, "order_by": {"1"}, "limit": {"10"}}
+			if cursor != "" {
+				params.Set("cursor", cursor)
+			}
+
+			w := searchRecorder(params.Encode())
+			for _, user := range decodeUsers(t, w) {
+				if seen[user.ID] {
+					t.Errorf("Duplicate user %v for %s", user.ID, orderField)
+				}
+				seen[user.ID] = true
+				walked = append(walked, user)
+			}
+
+			cursor = w.Header().Get("X-Next-Cursor")
+			if cursor == "" {
+				break
+			}
+		}
+
+		if len(walked) != len(expected) {
+			t.Fatalf("Expected: %v for %s, got: %v", len(expected), orderField, len(walked))
+		}
+		for idx := range expected {
+			if walked[idx].ID != expected[idx].ID {
+				t.Errorf("Expected: %v for %s, got: %v", expected[idx].ID, orderField, walked[idx].ID)
+			}
+		}
+	}
+}
+
+func TestCursorBad(t *testing.T) {
+	valid := encodeCursor(User{ID: 3, Age: 27}, "age")
+
+	cases := []string{
+		"order_field=age&order_by=1&cursor=!!!",
+		"order_field=age&order_by=1&cursor=bm90IGpzb24",
+		// Курсор от другого поля сортировки
+		"order_field=name&order_by=1&cursor=" + valid,
+		// Курсор без сортировки
+		"order_field=age&order_by=0&cursor=" + valid,
+	}
+	for _, query := range cases {
+		if w := searchRecorder(query); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected: %d for %s, got: %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
+
+func TestKeysetAge(t *testing.T) {
+	full := decodeUsers(t, searchRecorder("order_field=age&order_by=1&limit=0"))
+
+	var walked []User
+	query := "order_field=age&order_by=1&limit=4"
+	for len(walked) < len(full)+1 {
+		page := decodeUsers(t, searchRecorder(query))
+		if len(page) == 0 {
+			break
+		}
+		walked = append(walked, page...)
+		last := page[len(page)-1]
+		query = "order_field=age&order_by=1&limit=4&after_age=" + strconv.Itoa(last.Age) + "&after_id=" + strconv.Itoa(last.ID)
+	}
+	if !slices.Equal(userIDs(walked), userIDs(full)) {
+		t.Errorf("Expected: %v, got: %v", userIDs(full), userIDs(walked))
+	}
+
+	// Строго после пары (age, id): ровесник с большим id остается
+	page := decodeUsers(t, searchRecorder("order_field=age&order_by=1&limit=2&after_age=40&after_id=13"))
+	if actual := userIDs(page); !slices.Equal(actual, []int{32}) {
+		t.Errorf("Expected: %v, got: %v", []int{32}, actual)
+	}
+
+	for _, query := range []string{
+		"order_field=age&order_by=1&after_age=30",
+		"order_field=age&order_by=1&after_age=x&after_id=1",
+		"order_field=id&order_by=1&after_age=30&after_id=1",
+		"order_field=age&order_by=-1&after_age=30&after_id=1",
+	} {
+		w := searchRecorder(query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadKeyset) {
+			t.Errorf("Expected: %d for %s, got: %d %s", http.StatusBadRequest, query, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCursorPagingBackward(t *testing.T) {
+	expected := userIDs(decodeUsers(t, searchRecorder("limit=0&order_by=1&order_field=age")))
+	page := func(cursor, direction string) ([]int, *httptest.ResponseRecorder) {
+		params := url.Values{"order_field": {"age"}, "order_by": {"1"}, "limit": {"4"}}
+		if cursor != "" {
+			params.Set("cursor", cursor)
+		}
+		if direction != "" {
+			params.Set("direction", direction)
+		}
+		w := searchRecorder(params.Encode())
+		return userIDs(decodeUsers(t, w)), w
+	}
+
+	// Первая страница без X-Prev-Cursor, дальше вперед до середины
+	ids, w := page("", "")
+	if w.Header().Get("X-Prev-Cursor") != "" {
+		t.Errorf("Expected no prev cursor on the first page")
+	}
+	for pageNum := 1; pageNum < 3; pageNum++ {
+		ids, w = page(w.Header().Get("X-Next-Cursor"), "")
+	}
+	if !slices.Equal(ids, expected[8:12]) {
+		t.Fatalf("Expected: %v, got: %v", expected[8:12], ids)
+	}
+
+	// Назад по X-Prev-Cursor - те же окна в обратном порядке
+	for start := 4; start >= 0; start -= 4 {
+		ids, w = page(w.Header().Get("X-Prev-Cursor"), "prev")
+		if !slices.Equal(ids, expected[start:start+4]) {
+			t.Errorf("Expected: %v, got: %v", expected[start:start+4], ids)
+		}
+		if w.Header().Get("X-Next-Cursor") == "" {
+			t.Errorf("Expected next cursor on a backward page")
+		}
+	}
+	if prev := w.Header().Get("X-Prev-Cursor"); prev != "" {
+		t.Errorf("Expected no prev cursor on the first page, got: %s", prev)
+	}
+
+	// И снова вперед с той же позиции
+	ids, _ = page(w.Header().Get("X-Next-Cursor"), "next")
+	if !slices.Equal(ids, expected[4:8]) {
+		t.Errorf("Expected: %v, got: %v", expected[4:8], ids)
+	}
+
+	// Неполная страница перед курсором у начала выдачи
+	ids, _ = page(encodeCursor(decodeUsers(t, searchRecorder("limit=0&order_by=1&order_field=age"))[2], "age"), "prev")
+	if !slices.Equal(ids, expected[:2]) {
+		t.Errorf("Expected: %v, got: %v", expected[:2], ids)
+	}
+
+	for _, query := range []string{"order_field=age&order_by=1&direction=prev", "order_field=age&order_by=1&direction=up"} {
+		if w := searchRecorder(query); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected: %d for %s, got: %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Загруженные в память данные из файла fileName
+type dataset struct {
+	fileName string
+	data     xmlData
+	loadedAt time.Time
+	// Время изменения файла на момент загрузки
+	modTime time.Time
+	// Последняя перезагрузка не удалась, отдаются старые данные
+	stale bool
+	// Индекс id -> позиция строки в data.Rows, при повторах id - первая
+	byID map[int]int
+	// Различные id по возрастанию для поиска since_id
+	ids []int
+}
+
+// buildIDIndex строит индекс id -> позиция строки
+func buildIDIndex(rows []row) map[int]int {
+	index := make(map[int]int, len(rows))
+	for idx, row := range rows {
+		if _, ok := index[row.ID]; !ok {
+			index[row.ID] = idx
+		}
+	}
+	return index
+}
+
+// sortedIDs возвращает id из индекса по возрастанию
+func sortedIDs(index map[int]int) []int {
+	ids := make([]int, 0, len(index))
+	for id := range index {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// rowsSinceID возвращает строки с id больше id по возрастанию id: начало
+// ищется двоичным поиском по ids, строки берутся по индексу. При повторах
+// id, как и в rowsByID, берется первая строка
+func (ds *dataset) rowsSinceID(id int) xmlData {
+	result := xmlData{XMLName: ds.data.XMLName}
+	start, _ := slices.BinarySearch(ds.ids, id+1)
+	for _, next := range ds.ids[start:] {
+		result.Rows = append(result.Rows, ds.data.Rows[ds.byID[next]])
+	}
+	return result
+}
+
+// rowsByID возвращает строки с id из ids по индексу, без перебора всех
+// строк. Отсутствующие id пропускаются, порядок и повторы не важны:
+// их учитывает usersInIDOrder
+func (ds *dataset) rowsByID(ids []int) xmlData {
+	result := xmlData{XMLName: ds.data.XMLName}
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if idx, ok := ds.byID[id]; ok && !seen[id] {
+			seen[id] = true
+			result.Rows = append(result.Rows, ds.data.Rows[idx])
+		}
+	}
+	return result
+}
+
+// CacheDataset - данные загружаются в память при первом поиске и
+// переиспользуются. false - файл читается заново на каждый запрос, как с
+// no_cache=1, а запросы без сортировки фильтруют строки прямо при чтении и
+// не держат в памяти весь файл, см. streamFilterData
+var CacheDataset = true
+
+var (
+	datasetMu sync.RWMutex
+	// Последние успешно загруженные данные по пути файла: серверы из NewServer
+	// с разными FileName не вытесняют данные друг друга
+	cachedDatasets = map[string]*dataset{}
+)
+
+// cachedDataset возвращает загруженные данные файла path или nil
+func cachedDataset(path string) *dataset {
+	datasetMu.RLock()
+	defer datasetMu.RUnlock()
+	return cachedDatasets[path]
+}
+
+// loadDataset читает и разбирает файл path целиком
+func loadDataset(path string) (*dataset, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readData(path)
+	if err != nil {
+		return nil, err
+	}
+	index := buildIDIndex(data.Rows)
+	return &dataset{
+		fileName: path,
+		data:     data,
+		loadedAt: time.Now(),
+		modTime:  info.ModTime(),
+		byID:     index,
+		ids:      sortedIDs(index),
+	}, nil
+}
+
+// getDataset возвращает данные файла path из кеша, загружая их при первом
+// обращении. Ошибка загрузки не затирает кеш
+func getDataset(path string) (*dataset, error) {
+	if ds := cachedDataset(path); ds != nil {
+		return ds, nil
+	}
+
+	ds, err := loadDataset(path)
+	if err != nil {
+		return nil, err
+	}
+
+	datasetMu.Lock()
+	cachedDatasets[path] = ds
+	datasetMu.Unlock()
+	return ds, nil
+}
+
+// LoadDataset загружает файл path в кеш заранее, чтобы первый поиск не ждал
+// разбора XML. Повторный вызов перечитывает файл, как /reload. Поиск по
+// другому файлу загрузит его сам при первом запросе
+func LoadDataset(path string) error {
+	_, err := reloadDataset(path)
+	return err
+}
+
+// datasetCached - данные файла path уже загружены в кеш
+func datasetCached(path string) bool {
+	return cachedDataset(path) != nil
+}
+
+// reloadDataset перечитывает файл path. Новые данные подменяют кеш только
+// после полного разбора, при ошибке остаются старые данные с пометкой stale
+func reloadDataset(path string) (*dataset, error) {
+	ds, err := loadDataset(path)
+
+	datasetMu.Lock()
+	defer datasetMu.Unlock()
+
+	if err != nil {
+		if cached := cachedDatasets[path]; cached != nil {
+			stale := *cached
+			stale.stale = true
+			cachedDatasets[path] = &stale
+		}
+		return nil, err
+	}
+
+	cachedDatasets[path] = ds
+	return ds, nil
+}
+
+// Ответ на успешную перезагрузку данных
+type reloadResponse struct {
+	Rows     int
+	LoadedAt time.Time
+}
+
+// reloadHandler перечитывает файл с данными. Перезагрузка меняет
+// состояние сервера, поэтому принимается только POST
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ds, err := reloadDataset(serverConfig(r).FileName)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "reload failed: "+err.Error())
+		return
+	}
+
+	sendResponse(w, reloadResponse{Rows: len(ds.data.Rows), LoadedAt: ds.loadedAt})
+}
+
+// Предупреждение о том, что данные устарели после неудачной перезагрузки
+func staleWarning(ds *dataset) string {
+	return `110 - "dataset is stale, last successful load at ` +
+		ds.loadedAt.UTC().Format(time.RFC3339) + `"`
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Копия dataset.xml во временном каталоге, которую тест может менять
+func useTempDataset(t *testing.T) string {
+	t.Helper()
+
+	b, err := os.ReadFile("dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dataset.xml")
+	if err = os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	originalFilePath := fileName
+	fileName = path
+	t.Cleanup(func() { fileName = originalFilePath })
+	return path
+}
+
+func reloadRecorder() *httptest.ResponseRecorder {
+	return serveRequest(httptest.NewRequest("POST", "/reload", nil))
+}
+
+func TestReload(t *testing.T) {
+	path := useTempDataset(t)
+
+	users := decodeUsers(t, searchRecorder("query=Boyd"))
+	if len(users) != 1 {
+		t.Fatalf("Expected: %v, got: %v", 1, len(users))
+	}
+
+	// Без перезагрузки изменения файла не видны
+	b, _ := os.ReadFile(path) //nolint:errcheck
+	err := os.WriteFile(path, []byte(strings.ReplaceAll(string(b), "Boyd", "Lloyd")), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users = decodeUsers(t, searchRecorder("query=Boyd")); len(users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(users))
+	}
+
+	if w := reloadRecorder(); w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+	if users = decodeUsers(t, searchRecorder("query=Boyd")); len(users) != 0 {
+		t.Errorf("Expected: %v, got: %v", 0, len(users))
+	}
+}
+
+func TestReloadMethod(t *testing.T) {
+	useTempDataset(t)
+
+	w := recorder("/reload", "")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected: %d, got: %d", http.StatusMethodNotAllowed, w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != http.MethodPost {
+		t.Errorf("Expected: %q, got: %q", http.MethodPost, allow)
+	}
+}
+
+func TestNoCache(t *testing.T) {
+	path := useTempDataset(t)
+
+	if users := decodeUsers(t, searchRecorder("query=Boyd")); len(users) != 1 {
+		t.Fatalf("Expected: %v, got: %v", 1, len(users))
+	}
+
+	b, _ := os.ReadFile(path) //nolint:errcheck
+	err := os.WriteFile(path, []byte(strings.ReplaceAll(string(b), "Boyd", "Lloyd")), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users := decodeUsers(t, searchRecorder("query=Lloyd&no_cache=1")); len(users) != 1 {
+		t.Errorf("Expected fresh data with no_cache=1, got: %v", users)
+	}
+
+	// Общий кеш остался прежним до перезагрузки
+	if users := decodeUsers(t, searchRecorder("query=Boyd")); len(users) != 1 {
+		t.Errorf("Expected cached data, got: %v", users)
+	}
+	if w := reloadRecorder(); w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+	if users := decodeUsers(t, searchRecorder("query=Boyd")); len(users) != 0 {
+		t.Errorf("Expected: %v, got: %v", 0, len(users))
+	}
+}
+
+func TestReloadBrokenFileKeepsData(t *testing.T) {
+	path := useTempDataset(t)
+
+	if w := searchRecorder("query=Boyd"); w.Header().Get("Warning") != "" {
+		t.Errorf("Unexpected warning: %s", w.Header().Get("Warning"))
+	}
+
+	if err := os.WriteFile(path, []byte("<root><row><id>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if w := reloadRecorder(); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected: %d, got: %d", http.StatusInternalServerError, w.Code)
+	}
+
+	w := searchRecorder("query=Boyd")
+	if users := decodeUsers(t, w); len(users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(users))
+	}
+	if warning := w.Header().Get("Warning"); !strings.Contains(warning, "last successful load at") {
+		t.Errorf("Expected stale warning, got: %q", warning)
+	}
+
+	// Успешная перезагрузка снимает предупреждение
+	b, _ := os.ReadFile("dataset.xml") //nolint:errcheck
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if w = reloadRecorder(); w.Code != http.StatusOK {
+		t.Errorf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+	if w = searchRecorder("query=Boyd"); w.Header().Get("Warning") != "" {
+		t.Errorf("Unexpected warning: %s", w.Header().Get("Warning"))
+	}
+}
+
+func TestXMLRootName(t *testing.T) {
+	fileName = "testdata/users_root.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	if w := searchRecorder(""); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected: %d, got: %d", http.StatusInternalServerError, w.Code)
+	}
+
+	XMLRootName = "users"
+	defer func() { XMLRootName = "root" }()
+
+	users := decodeUsers(t, searchRecorder("limit=0"))
+	if actual := userIDs(users); !slices.Equal(actual, []int{0, 1, 2, 3}) {
+		t.Errorf("Expected: %v, got: %v", []int{0, 1, 2, 3}, actual)
+	}
+	if users[0].City != "Oslo" {
+		t.Errorf("Expected: Oslo, got: %v", users[0].City)
+	}
+
+	XMLRootName = ""
+	fileName = "dataset.xml"
+	if users = decodeUsers(t, searchRecorder("limit=0")); len(users) != 35 {
+		t.Errorf("Expected: %v, got: %v", 35, len(users))
+	}
+}
+
+func TestDuplicateIDs(t *testing.T) {
+	fileName = "testdata/duplicates.xml"
+	defer func() { fileName = "dataset.xml" }()
+	defer func() { DuplicateIDs = DuplicateIDsAllow }()
+
+	cases := []struct {
+		mode     string
+		expected []string
+	}{
+		{DuplicateIDsAllow, []string{"Boyd Wolf", "Hilda Mayer", "Boyd Wolfe"}},
+		{DuplicateIDsKeepFirst, []string{"Boyd Wolf", "Hilda Mayer"}},
+		{DuplicateIDsKeepLast, []string{"Hilda Mayer", "Boyd Wolfe"}},
+	}
+	for _, c := range cases {
+		DuplicateIDs = c.mode
+		data, err := readData(fileName)
+		if err != nil {
+			t.Fatalf("Invalid error for %q: %v", c.mode, err)
+		}
+		var names []string
+		for _, row := range data.Rows {
+			names = append(names, row.FirstName+" "+row.LastName)
+		}
+		if !slices.Equal(names, c.expected) {
+			t.Errorf("Expected: %v for %q, got: %v", c.expected, c.mode, names)
+		}
+	}
+
+	DuplicateIDs = DuplicateIDsError
+	if _, err := readData(fileName); err == nil || !strings.Contains(err.Error(), "duplicate id 0") {
+		t.Errorf("Invalid error: %v", err)
+	}
+	if w := searchRecorder(""); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected: %d, got: %d", http.StatusInternalServerError, w.Code)
+	}
+
+	// Без повторов данные загружаются в любом режиме
+	fileName = "dataset.xml"
+	if data, err := readData(fileName); err != nil || len(data.Rows) != 35 {
+		t.Errorf("Expected 35 rows, got: %v, %v", len(data.Rows), err)
+	}
+}
+
+func TestAgeOutOfRange(t *testing.T) {
+	fileName = "testdata/ages.xml"
+	defer func() { fileName = "dataset.xml" }()
+	defer func() { AgeOutOfRange = AgeClamp }()
+
+	cases := []struct {
+		mode         string
+		expectedIDs  []int
+		expectedAges []int
+	}{
+		{AgeClamp, []int{0, 1, 2, 3}, []int{22, 150, 0, 27}},
+		{AgeDrop, []int{0, 3}, []int{22, 27}},
+	}
+	for _, c := range cases {
+		AgeOutOfRange = c.mode
+		data, err := readData(fileName)
+		if err != nil {
+			t.Fatalf("Invalid error: %v", err)
+		}
+		var ids, ages []int
+		for _, row := range data.Rows {
+			ids = append(ids, row.ID)
+			ages = append(ages, row.Age)
+		}
+		if !slices.Equal(ids, c.expectedIDs) || !slices.Equal(ages, c.expectedAges) {
+			t.Errorf("Expected: %v %v for %s, got: %v %v", c.expectedIDs, c.expectedAges, c.mode, ids, ages)
+		}
+	}
+}
+
+func TestWatchDataset(t *testing.T) {
+	path := useTempDataset(t)
+	defer func(interval, debounce time.Duration) {
+		WatchInterval, WatchDebounce = interval, debounce
+	}(WatchInterval, WatchDebounce)
+	WatchInterval, WatchDebounce = 10*time.Millisecond, 50*time.Millisecond
+
+	if users := decodeUsers(t, searchRecorder("query=Boyd")); len(users) != 1 {
+		t.Fatalf("Expected: %v, got: %v", 1, len(users))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchDataset(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	b, _ := os.ReadFile(path) //nolint:errcheck
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(string(b), "Boyd", "Lloyd")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Время изменения может не поменяться при записи в ту же секунду
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(decodeUsers(t, searchRecorder("query=Boyd"))) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected dataset to be reloaded after change")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if users := decodeUsers(t, searchRecorder("query=Lloyd")); len(users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(users))
+	}
+}
+
+// Перезапись того же размера с тем же временем изменения видна по содержимому
+func TestWatchDatasetSameModTime(t *testing.T) {
+	path := useTempDataset(t)
+	defer func(interval, debounce, resolution time.Duration) {
+		WatchInterval, WatchDebounce, WatchMTimeResolution = interval, debounce, resolution
+	}(WatchInterval, WatchDebounce, WatchMTimeResolution)
+	WatchInterval, WatchDebounce, WatchMTimeResolution = 10*time.Millisecond, 50*time.Millisecond, time.Minute
+
+	if users := decodeUsers(t, searchRecorder("query=Boyd")); len(users) != 1 {
+		t.Fatalf("Expected: %v, got: %v", 1, len(users))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchDataset(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	// Наблюдение успевает запомнить исходный файл
+	time.Sleep(200 * time.Millisecond)
+
+	b, _ := os.ReadFile(path) //nolint:errcheck
+	if err = os.WriteFile(path, []byte(strings.ReplaceAll(string(b), "Boyd", "Bold")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(decodeUsers(t, searchRecorder("query=Bold"))) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected dataset to be reloaded after same-size rewrite")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIDIndexAfterReload(t *testing.T) {
+	path := useTempDataset(t)
+
+	checkIndex := func() *dataset {
+		t.Helper()
+		ds, err := getDataset(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ds.byID) != len(ds.data.Rows) {
+			t.Errorf("Expected: %d ids in index, got: %d", len(ds.data.Rows), len(ds.byID))
+		}
+		for id, idx := range ds.byID {
+			if ds.data.Rows[idx].ID != id {
+				t.Errorf("Expected row %d to have id %d, got: %d", idx, id, ds.data.Rows[idx].ID)
+			}
+		}
+		return ds
+	}
+	checkIndex()
+
+	// Id 0 пропадает, остальные строки сдвигаются
+	b, _ := os.ReadFile(path) //nolint:errcheck
+	changed := strings.Replace(string(b), "<id>0</id>", "<id>1000</id>", 1)
+	if err := os.WriteFile(path, []byte(changed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if w := reloadRecorder(); w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+	ds := checkIndex()
+	if _, ok := ds.byID[0]; ok {
+		t.Errorf("Expected id 0 to be gone from index")
+	}
+
+	users := decodeUsers(t, searchRecorder("order_ids=1000,0,1"))
+	if ids := userIDs(users); !slices.Equal(ids, []int{1000, 1}) || users[0].Name != "Boyd Wolf" {
+		t.Errorf("Expected: [1000 1], got: %v", ids)
+	}
+}
+
+func BenchmarkIDLookup(b *testing.B) {
+	ds, err := loadDataset(fileName)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ids := []int{34, 0, 17}
+
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ds.rowsByID(ids)
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var rows []row
+			for _, id := range ids {
+				for _, row := range ds.data.Rows {
+					if row.ID == id {
+						rows = append(rows, row)
+						break
+					}
+				}
+			}
+		}
+	})
+}
+
+func TestTrimXMLText(t *testing.T) {
+	fileName = "testdata/indented.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	users := decodeUsers(t, searchRecorder("order_field=id&order_by=1"))
+	if len(users) != 2 {
+		t.Fatalf("Expected: %v, got: %v", 2, len(users))
+	}
+	expected := "Nulla cillum enim voluptate.\n      Sit commodo consectetur."
+	if users[0].About != expected || users[0].Name != "Boyd Wolf" {
+		t.Errorf("Expected trimmed fields, got: %q, %q", users[0].Name, users[0].About)
+	}
+	if users := decodeUsers(t, searchRecorder("query=Boyd+Wolf")); len(users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(users))
+	}
+
+	TrimXMLText = false
+	defer func() {
+		TrimXMLText = true
+		reloadDataset(fileName) //nolint:errcheck
+	}()
+	if _, err := reloadDataset(fileName); err != nil {
+		t.Fatal(err)
+	}
+	users = decodeUsers(t, searchRecorder("order_field=id&order_by=1"))
+	if !strings.HasPrefix(users[0].About, "\n") || users[1].About != "Velit ullamco est." {
+		t.Errorf("Expected About as in file, got: %q, %q", users[0].About, users[1].About)
+	}
+}
+
+func TestEmptyDataset(t *testing.T) {
+	fileName = "testdata/empty.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		method, target string
+		body           string
+		status         int
+		expected       string
+	}{
+		{"GET", "/?order_field=name&order_by=1", "", http.StatusOK, `[]`},
+		{"GET", "/?query=Boyd&limit=0", "", http.StatusOK, `[]`},
+		{"GET", "/?offset=5", "", http.StatusOK, `[]`},
+		{"GET", "/?stats=age", "", http.StatusOK, `{"min":null,"max":null,"avg":null,"median":null,"count":0}`},
+		{"GET", "/?digest=1", "", http.StatusOK, `{"digest":"4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945","count":0}`},
+		{"GET", "/?with_counts=1&order_field=age&order_by=-1", "", http.StatusOK, `{"users":[],"matched":0,"total_scanned":0}`},
+		{"GET", "/histogram?field=age&bucket=5", "", http.StatusOK, `[]`},
+		{"GET", "/index?omit_empty=1", "", http.StatusOK, `{}`},
+		{"GET", "/users/batch?ids=0,1", "", http.StatusOK, `[]`},
+		{"POST", "/batch", `[{"Query":"Boyd"},{}]`, http.StatusOK, `[0,0]`},
+		{"GET", "/rank?n=1", "", http.StatusNotFound, `{"Error":"rank out of range"}`},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		req.Header.Set("AccessToken", accessToken)
+		w := httptest.NewRecorder()
+		SearchServer(w, req)
+		if w.Code != c.status || strings.TrimSpace(w.Body.String()) != c.expected {
+			t.Errorf("Expected %d %s for %s %s, got: %d %s", c.status, c.expected, c.method, c.target, w.Code, w.Body.String())
+		}
+	}
+
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+	resp, err := ts.client.FindUsers(SearchRequest{Limit: 5, OrderField: "age", OrderBy: OrderByAsc})
+	if err != nil || len(resp.Users) != 0 || resp.NextPage {
+		t.Errorf("Expected empty response, got: %+v, %v", resp, err)
+	}
+	if count, err := ts.client.CountFast(SearchRequest{Query: "Boyd"}); err != nil || count != 0 {
+		t.Errorf("Expected count 0, got: %d, %v", count, err)
+	}
+}
+
+func TestLoadDataset(t *testing.T) {
+	if err := LoadDataset(fileName); err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	first, err := getDataset(fileName)
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	searchRecorder("query=Boyd")
+	// Файл не перечитывается между запросами
+	if second, _ := getDataset(fileName); second != first { //nolint:errcheck
+		t.Errorf("Expected cached dataset to be reused")
+	}
+
+	// Другой файл не подменяется закешированным
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+	fileName = "invalid.xml"
+	defer func() { fileName = "dataset.xml" }()
+	if _, err = ts.client.FindUsers(SearchRequest{}); err == nil || err.Error() != "SearchServer fatal error" {
+		t.Errorf("Invalid error: %v", err)
+	}
+	if err = LoadDataset(fileName); err == nil {
+		t.Errorf("Expected error for %s", fileName)
+	}
+}
+
+// Серверы с разными файлами не вытесняют данные друг друга из кеша
+func TestDatasetCachePerFile(t *testing.T) {
+	other := DefaultConfig()
+	other.FileName = "testdata/duplicates.xml"
+
+	searchRecorder("query=Boyd")
+	configRecorder(other, accessToken, "limit=1")
+	first, second := cachedDataset(fileName), cachedDataset(other.FileName)
+	if first == nil || second == nil {
+		t.Fatalf("Expected both files cached, got: %v %v", first, second)
+	}
+
+	searchRecorder("query=Boyd")
+	configRecorder(other, accessToken, "limit=1")
+	if cachedDataset(fileName) != first || cachedDataset(other.FileName) != second {
+		t.Errorf("Expected cached datasets to be reused")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// ErrorBadDiff отдается /diff, если тело не является объектом DiffRequest
+const ErrorBadDiff = `body must be a JSON object with from and to search requests`
+
+// DiffRequest - тело POST /diff: два поиска, результаты которых сравниваются.
+// Limit и Offset не учитываются
+type DiffRequest struct {
+	From SearchRequest `json:"from"`
+	To   SearchRequest `json:"to"`
+}
+
+// DiffResult - ответ /diff: id, которые есть только в To (Added) и только
+// во From (Removed), по возрастанию
+type DiffResult struct {
+	Added   []int `json:"added"`
+	Removed []int `json:"removed"`
+}
+
+// diffHandler принимает POST /diff с DiffRequest и отдает DiffResult
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		sendError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req DiffRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, ErrorBadDiff)
+		return
+	}
+	from, ok := searchAll(w, r, req.From, "from")
+	if !ok {
+		return
+	}
+	to, ok := searchAll(w, r, req.To, "to")
+	if !ok {
+		return
+	}
+
+	sendResponse(w, DiffResult{Added: idsMissing(to, from), Removed: idsMissing(from, to)})
+}
+
+// idsMissing возвращает id из users, которых нет в other, по возрастанию
+// и без повторов
+func idsMissing(users, other []User) []int {
+	known := make(map[int]bool, len(other))
+	for _, user := range other {
+		known[user.ID] = true
+	}
+	result := []int{}
+	for _, user := range users {
+		if !known[user.ID] {
+			known[user.ID] = true
+			result = append(result, user.ID)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// Diff отправляет POST /diff и возвращает id, появившиеся в выдаче to и
+// пропавшие из выдачи from
+func (srv *SearchClient) Diff(from, to SearchRequest) (DiffResult, error) {
+	var result DiffResult
+	payload, err := json.Marshal(DiffRequest{From: from, To: to})
+	if err != nil {
+		return result, err
+	}
+	req, _ := http.NewRequest(http.MethodPost, srv.endpoint("diff"), bytes.NewReader(payload)) //nolint:errcheck
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := srv.do(req)
+	if err != nil {
+		return result, fmt.Errorf("unknown error %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body) //nolint:errcheck
+
+	if resp.StatusCode == http.StatusBadRequest {
+		errResp := SearchErrorResponse{}
+		if err = json.Unmarshal(body, &errResp); err != nil {
+			return result, fmt.Errorf("cant unpack error json: %s", err)
+		}
+		return result, fmt.Errorf("bad diff request: %s", errResp.Error)
+	}
+	if err = countStatusError(resp.StatusCode); err != nil {
+		return result, err
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return result, fmt.Errorf("cant unpack result json: %s", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Ответ в режиме digest=1: хеш всей выборки вместо самих пользователей
+type digestResponse struct {
+	Digest string `json:"digest"`
+	Count  int    `json:"count"`
+}
+
+// newDigestResponse считает sha256 по JSON отфильтрованных и отсортированных
+// пользователей до пагинации. Одинаковые данные и запрос дают одинаковый хеш
+func newDigestResponse(users []User) (digestResponse, error) {
+	b, err := json.Marshal(users)
+	if err != nil {
+		return digestResponse{}, err
+	}
+	sum := sha256.Sum256(b)
+	return digestResponse{Digest: hex.EncodeToString(sum[:]), Count: len(users)}, nil
+}
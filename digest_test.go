@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Ответ digest=1 на запрос query
+func decodeDigest(t *testing.T, query string) digestResponse {
+	t.Helper()
+	w := searchRecorder("digest=1&" + query)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+	result := digestResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	return result
+}
+
+func TestDigest(t *testing.T) {
+	path := useTempDataset(t)
+
+	first := decodeDigest(t, "order_field=id&order_by=1&limit=5")
+	if first.Count != 35 || len(first.Digest) != 64 {
+		t.Errorf("Unexpected digest: %+v", first)
+	}
+
+	// Пагинация на хеш не влияет
+	if second := decodeDigest(t, "order_field=id&order_by=1&offset=3"); second != first {
+		t.Errorf("Expected: %+v, got: %+v", first, second)
+	}
+	if other := decodeDigest(t, "order_field=age&order_by=1"); other.Digest == first.Digest {
+		t.Errorf("Expected different digest for another order")
+	}
+
+	b, _ := os.ReadFile(path) //nolint:errcheck
+	err := os.WriteFile(path, []byte(strings.ReplaceAll(string(b), "Boyd", "Lloyd")), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := reloadRecorder(); w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+
+	if changed := decodeDigest(t, "order_field=id&order_by=1"); changed.Digest == first.Digest || changed.Count != 35 {
+		t.Errorf("Expected changed digest, got: %+v", changed)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+)
+
+// План выполнения поиска для explain_plan=1: какие оптимизации сработали,
+// сколько строк осталось после каждого этапа и сколько занял каждый этап.
+// Только для диагностики, формат может меняться
+type queryPlan struct {
+	// Данные уже были в кеше и не читались с диска
+	CacheHit bool
+	// Фильтрация остановилась, не дойдя до конца данных, см. filterStopAfter
+	EarlyExit bool
+	// Пользователи выбирались по индексу id, а не перебором
+	IndexUsed bool
+	// Строк в данных и строк после этапов scan, filter, sort и paginate
+	Rows map[string]int
+	// Время этапов filter, sort и paginate
+	Elapsed map[string]string
+}
+
+func newQueryPlan(cacheHit bool, total int) *queryPlan {
+	return &queryPlan{
+		CacheHit: cacheHit,
+		Rows:     map[string]int{"total": total},
+		Elapsed:  map[string]string{},
+	}
+}
+
+// stage записывает число строк после этапа name и время с start.
+// У nil-плана (без explain_plan) ничего не делает
+func (p *queryPlan) stage(name string, start time.Time, rows int) {
+	if p == nil {
+		return
+	}
+	p.Rows[name] = rows
+	p.Elapsed[name] = time.Since(start).String()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func explainRecorder(t *testing.T, query string) queryPlan {
+	t.Helper()
+	w := searchRecorder("explain_plan=1&" + query)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d (%s)", http.StatusOK, w.Code, w.Body.String())
+	}
+	plan := queryPlan{}
+	if err := json.Unmarshal(w.Body.Bytes(), &plan); err != nil {
+		t.Fatalf("Invalid plan: %v (%s)", err, w.Body.String())
+	}
+	return plan
+}
+
+func TestExplainPlan(t *testing.T) {
+	total := len(decodeUsers(t, searchRecorder("limit=0")))
+
+	plan := explainRecorder(t, "limit=5&no_total=1")
+	if !plan.EarlyExit || plan.Rows["scan"] != 5 || plan.Rows["paginate"] != 5 || plan.Rows["total"] != total {
+		t.Errorf("Expected early exit after 5 rows, got: %+v", plan)
+	}
+	if !plan.CacheHit {
+		t.Errorf("Expected cache hit, got: %+v", plan)
+	}
+	for _, stage := range []string{"filter", "sort", "paginate"} {
+		if plan.Elapsed[stage] == "" {
+			t.Errorf("Expected elapsed for %s, got: %v", stage, plan.Elapsed)
+		}
+	}
+
+	plan = explainRecorder(t, "limit=5&order_field=age&order_by=1")
+	if plan.EarlyExit || plan.Rows["scan"] != total || plan.Rows["sort"] != total || plan.Rows["paginate"] != 5 {
+		t.Errorf("Expected full scan for sorted query, got: %+v", plan)
+	}
+
+	plan = explainRecorder(t, "query=Boyd&order_field=age&order_by=1")
+	if plan.Rows["filter"] != 1 || plan.Rows["sort"] != 1 {
+		t.Errorf("Expected 1 filtered row, got: %+v", plan.Rows)
+	}
+}
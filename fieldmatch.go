@@ -0,0 +1,81 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// ErrorBadFieldMatch - match с неизвестным полем или режимом, либо вместе
+// с search_field или match_mode
+const ErrorBadFieldMatch = `match invalid`
+
+// Поле совпадает с query целиком, только для match
+const anchorExact = "exact"
+
+// Режимы match и соответствующие им привязки anchor
+var fieldMatchModes = map[string]string{
+	"prefix":    anchorStart,
+	"suffix":    anchorEnd,
+	"substring": anchorNone,
+	"exact":     anchorExact,
+}
+
+// Поля, которые можно указать в match
+var fieldMatchFields = []string{"name", "about", "city", "country", "email"}
+
+// fieldMatch - поле и привязка query к нему из match
+type fieldMatch struct {
+	field  string
+	anchor string
+}
+
+// parseFieldMatches разбирает match=name:prefix,about:substring. Пустое
+// значение - match не задан
+func parseFieldMatches(value string) ([]fieldMatch, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var result []fieldMatch
+	for _, item := range strings.Split(value, ",") {
+		field, mode, _ := strings.Cut(strings.TrimSpace(item), ":")
+		anchor, ok := fieldMatchModes[mode]
+		if !ok || !slices.Contains(fieldMatchFields, field) {
+			return nil, &paramError{ErrorBadFieldMatch}
+		}
+		result = append(result, fieldMatch{field: field, anchor: anchor})
+	}
+	return result, nil
+}
+
+// matchFields проверяет query по полям из match, каждое в своем режиме.
+// Достаточно совпадения в любом из полей
+func (q *queryDTO) matchFields(row row, query string) bool {
+	for _, m := range q.fieldMatches {
+		if q.matchField(row, m, query) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *queryDTO) matchField(row row, m fieldMatch, query string) bool {
+	var values []string
+	switch m.field {
+	case "name":
+		values = []string{row.FirstName, row.LastName, row.FirstName + " " + row.LastName}
+	case "about":
+		values = row.aboutParagraphs(q.lang)
+	case "city":
+		values = []string{row.City}
+	case "country":
+		values = []string{row.Country}
+	case "email":
+		values = []string{row.Email}
+	}
+	for _, value := range values {
+		if value != "" && q.containsAnchored(value, query, m.anchor) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Форматы ответа поиска (параметр format)
+const (
+	// JSON-массив пользователей
+	formatJSON = ""
+	// CSV-файл для скачивания
+	formatCSV = "csv"
+	// XML-документ <users><user>...</user></users>
+	formatXML = "xml"
+	// HTML-таблица для просмотра в браузере, только через параметр format
+	formatHTML = "html"
+	// Server-sent events: событие user на каждого пользователя и end в конце
+	formatSSE = "sse"
+	// []User в encoding/gob для клиентов на Go, только через параметр format
+	formatGob = "gob"
+	// Сообщение Users из users.proto
+	formatProtobuf = "protobuf"
+)
+
+// ErrorNoSingleUser отдается с 404 в режиме unwrap_single=1, если никто не найден
+const ErrorNoSingleUser = `user not found`
+
+// Content-Type ответа format=gob
+const gobContentType = "application/x-gob"
+
+// Поддерживаемые значения format
+var formats = []string{formatJSON, "json", formatCSV, formatXML, formatHTML, formatSSE, formatGob, formatProtobuf}
+
+// Форматы для типов из заголовка Accept
+var mediaFormats = map[string]string{
+	"application/json":  formatJSON,
+	"application/xml":   formatXML,
+	"text/xml":          formatXML,
+	"text/csv":          formatCSV,
+	"text/event-stream": formatSSE,
+	protobufContentType: formatProtobuf,
+	"application/*":     formatJSON,
+	"text/*":            formatCSV,
+	"*/*":               formatJSON,
+}
+
+// errNotAcceptable - клиент принимает только неподдерживаемые типы, ответ 406
+var errNotAcceptable = errors.New("no acceptable content type")
+
+// negotiateFormat выбирает формат ответа. Явный параметр format важнее
+// заголовка Accept; из Accept берется поддерживаемый тип с наибольшим q.
+// Без Accept отдается JSON, а если все перечисленные типы не поддерживаются,
+// возвращается errNotAcceptable
+func negotiateFormat(format, accept string) (string, error) {
+	if format != "" {
+		if !slices.Contains(formats, format) {
+			return "", &paramError{ErrorBadFormat}
+		}
+		if format == "json" {
+			return formatJSON, nil
+		}
+		return format, nil
+	}
+
+	if strings.TrimSpace(accept) == "" {
+		return formatJSON, nil
+	}
+
+	var (
+		best     = formatJSON
+		bestQ    = 0.0
+		accepted = false
+	)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, mediaParams, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		mediaFormat, ok := mediaFormats[mediaType]
+		if !ok {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := mediaParams["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ, accepted = mediaFormat, q, true
+		}
+	}
+
+	if !accepted {
+		return "", errNotAcceptable
+	}
+	return best, nil
+}
+
+// Заголовок CSV-файла
+var csvHeader = []string{"id", "name", "age", "gender", "about"}
+
+// sendUsers отправляет найденных пользователей в запрошенном формате
+func sendUsers(w http.ResponseWriter, users []User, params *queryDTO) {
+	// Поля вне projection (токен, fields=, DefaultHiddenFields) обнуляются для
+	// всех форматов, JSON дополнительно убирает их ключи
+	users = restrictUsers(users, params.projection)
+	if params.download {
+		w.Header().Set("Content-Disposition", `attachment; filename="search.`+downloadExt(params.format)+`"`)
+	}
+	switch params.format {
+	case formatCSV:
+		sendCSV(w, users)
+	case formatXML:
+		sendXML(w, users)
+	case formatHTML:
+		sendHTML(w, users, params)
+	case formatSSE:
+		sendSSE(w, users, params)
+	case formatGob:
+		sendGob(w, users)
+	case formatProtobuf:
+		sendProtobuf(w, users)
+	default:
+		var result interface{}
+		if params.unwrapSingle {
+			// Пустой выборке нечего разворачивать, объекта нет - 404
+			if len(users) == 0 {
+				sendError(w, http.StatusNotFound, ErrorNoSingleUser)
+				return
+			}
+			result = projectUser(users[0], params.projection, params.omitEmptyFields)
+		} else if params.asMap {
+			result = usersByID(users, params)
+		} else {
+			result = projectUsers(users, params.projection, params.omitEmptyFields)
+		}
+		if params.withCounts {
+			result = countsResponse{Users: result, Matched: params.matched, TotalScanned: params.totalRows}
+		}
+		if params.withSchema {
+			result = schemaResponse{Users: result, Schema: userSchema(params.projection)}
+		}
+		if params.links != nil {
+			result = linksResponse{Users: result, Links: *params.links}
+		}
+		if params.withMeta {
+			result = metaResponse{Users: result, Params: newParamsMeta(params)}
+		}
+		if params.version == 2 {
+			result = versionedResponse{Version: 2, Data: result}
+		}
+		sendJSON(w, result, params.pretty)
+	}
+}
+
+// downloadExt - расширение файла download=1 для формата ответа
+func downloadExt(format string) string {
+	switch format {
+	case formatJSON:
+		return "json"
+	case formatProtobuf:
+		return "pb"
+	}
+	return format
+}
+
+// CSVFlushRows - через сколько строк CSV отправляется клиенту, не дожидаясь
+// конца выгрузки. Данные и найденные пользователи к этому моменту уже в
+// памяти, потоково идет только запись ответа: клиент получает первые строки
+// сразу, а CSV-файл целиком в отдельный буфер не собирается
+var CSVFlushRows = 500
+
+// Отправка пользователей CSV-файлом с заголовком
+func sendCSV(w http.ResponseWriter, users []User) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	if w.Header().Get("Content-Disposition") == "" {
+		w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+	}
+
+	flusher, _ := w.(http.Flusher)
+	writer := csv.NewWriter(w)
+	_ = writer.Write(csvHeader) //nolint:errcheck
+	for idx, user := range users {
+		err := writer.Write([]string{
+			strconv.Itoa(user.ID),
+			user.Name,
+			strconv.Itoa(user.Age),
+			user.Gender,
+			user.About,
+		})
+		if err != nil {
+			// Клиент отключился, дописывать некуда
+			return
+		}
+		if CSVFlushRows > 0 && (idx+1)%CSVFlushRows == 0 {
+			writer.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	writer.Flush()
+}
+
+// Отправка пользователей потоком server-sent events: "event: user" с JSON
+// пользователя на каждого, затем "event: end" с их числом. Каждое событие
+// сразу сбрасывается клиенту; если ResponseWriter не поддерживает
+// http.Flusher, события уходят как обычное тело ответа. Соединение
+// закрывается после текущей выборки, новых событий не ждет
+func sendSSE(w http.ResponseWriter, users []User, params *queryDTO) {
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	flusher, _ := w.(http.Flusher)
+	for _, user := range users {
+		data, err := json.Marshal(projectUser(user, params.projection, params.omitEmptyFields))
+		if err != nil {
+			continue
+		}
+		if _, err = fmt.Fprintf(w, "event: user\ndata: %s\n\n", data); err != nil {
+			// Клиент отключился, дописывать некуда
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	_, _ = fmt.Fprintf(w, "event: end\ndata: {\"count\":%d}\n\n", len(users)) //nolint:errcheck
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// Отправка пользователей в gob. Проекция fields= не применяется: gob
+// передает структуру User целиком
+func sendGob(w http.ResponseWriter, users []User) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(users); err != nil {
+		http.Error(w, "cant encode gob", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", gobContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, _ = w.Write(buf.Bytes()) //nolint:errcheck
+}
+
+// Список пользователей в XML-ответе
+type xmlUsers struct {
+	XMLName xml.Name `xml:"users"`
+	Users   []User   `xml:"user"`
+}
+
+// Отправка пользователей XML-документом
+func sendXML(w http.ResponseWriter, users []User) {
+	b, err := xml.Marshal(xmlUsers{Users: users})
+	if err != nil {
+		http.Error(w, "cant marshal xml", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	_, err = w.Write(append([]byte(xml.Header), b...))
+	if err != nil {
+		http.Error(w, "cant write xml", http.StatusInternalServerError)
+	}
+}
+
+// Страница format=html. About и имена экранирует html/template
+var htmlTemplate = template.Must(template.New("users").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Users</title></head>
+<body>
+<table>
+<tr><th>ID</th><th>Name</th><th>Age</th><th>Gender</th><th>About</th></tr>
+{{- range .Users}}
+<tr><td>{{.ID}}</td><td>{{.Name}}</td><td>{{.Age}}</td><td>{{.Gender}}</td><td>{{.About}}</td></tr>
+{{- end}}
+</table>
+<p>
+{{- if .Prev}}<a href="{{.Prev}}">prev</a>{{end}}
+{{- if .Next}} <a href="{{.Next}}">next</a>{{end}}
+</p>
+</body>
+</html>
+`))
+
+// Данные для htmlTemplate
+type htmlPage struct {
+	Users []User
+	// Ссылки на соседние страницы, пустые, если страницы нет
+	Prev, Next string
+}
+
+// sendHTML отправляет страницу пользователей HTML-таблицей со ссылками на
+// соседние страницы. Следующая страница показывается, если текущая заполнена
+// целиком: общее число найденных для этого не нужно
+func sendHTML(w http.ResponseWriter, users []User, params *queryDTO) {
+	page := htmlPage{Users: users}
+	if params.offset > 0 {
+		page.Prev = pageLink(params, max(params.offset-params.limit, 0))
+	}
+	if params.limit > 0 && len(users) == params.limit {
+		page.Next = pageLink(params, params.offset+params.limit)
+	}
+
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, page); err != nil {
+		http.Error(w, "cant render html", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		http.Error(w, "cant write html", http.StatusInternalServerError)
+	}
+}
+
+// Ссылка на ту же выдачу с другим offset
+func pageLink(params *queryDTO, offset int) string {
+	values := url.Values{}
+	for key, value := range params.values {
+		values[key] = value
+	}
+	values.Set("offset", strconv.Itoa(offset))
+	return "?" + values.Encode()
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFormatCSV(t *testing.T) {
+	expected := decodeUsers(t, searchRecorder("order_field=id&order_by=1&limit=5"))
+
+	w := searchRecorder("order_field=id&order_by=1&limit=5&format=csv")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/csv") {
+		t.Errorf("Expected: text/csv, got: %s", contentType)
+	}
+	if disposition := w.Header().Get("Content-Disposition"); disposition != `attachment; filename="users.csv"` {
+		t.Errorf("Unexpected Content-Disposition: %s", disposition)
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	if !slices.Equal(records[0], csvHeader) {
+		t.Errorf("Expected: %v, got: %v", csvHeader, records[0])
+	}
+	if len(records)-1 != len(expected) {
+		t.Fatalf("Expected: %v, got: %v", len(expected), len(records)-1)
+	}
+
+	for idx, user := range expected {
+		record := records[idx+1]
+		expectedRecord := []string{strconv.Itoa(user.ID), user.Name, strconv.Itoa(user.Age), user.Gender, user.About}
+		if !slices.Equal(record, expectedRecord) {
+			t.Errorf("Expected: %v, got: %v", expectedRecord, record)
+		}
+	}
+}
+
+func TestBadFormat(t *testing.T) {
+	if w := searchRecorder("format=pdf"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func acceptRecorder(accept, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/?"+query, nil)
+	req.Header.Set("AccessToken", accessToken)
+	req.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	SearchServer(w, req)
+	return w
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	cases := []struct {
+		format, accept string
+		expected       string
+		err            error
+	}{
+		{"", "", formatJSON, nil},
+		{"", "*/*", formatJSON, nil},
+		{"", "application/xml", formatXML, nil},
+		{"", "text/csv;q=0.5, application/xml;q=0.9", formatXML, nil},
+		{"", "text/html, application/json;q=0.1", formatJSON, nil},
+		{"", "application/pdf", "", errNotAcceptable},
+		{"", "application/xml;q=0", "", errNotAcceptable},
+		// Явный format важнее Accept
+		{"csv", "application/pdf", formatCSV, nil},
+		{"json", "application/xml", formatJSON, nil},
+	}
+
+	for _, c := range cases {
+		format, err := negotiateFormat(c.format, c.accept)
+		if format != c.expected || !errors.Is(err, c.err) {
+			t.Errorf("Expected: %q %v for %q/%q, got: %q %v", c.expected, c.err, c.format, c.accept, format, err)
+		}
+	}
+}
+
+func TestAcceptXML(t *testing.T) {
+	w := acceptRecorder("application/xml", "order_field=id&order_by=1&limit=3")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/xml") {
+		t.Errorf("Expected: application/xml, got: %s", contentType)
+	}
+
+	result := xmlUsers{}
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	if ids := userIDs(result.Users); !slices.Equal(ids, []int{0, 1, 2}) {
+		t.Errorf("Expected: %v, got: %v", []int{0, 1, 2}, ids)
+	}
+}
+
+func TestAcceptAny(t *testing.T) {
+	w := acceptRecorder("*/*", "limit=3")
+	if users := decodeUsers(t, w); len(users) != 3 {
+		t.Errorf("Expected: %v, got: %v", 3, len(users))
+	}
+}
+
+func TestAcceptUnsupported(t *testing.T) {
+	w := acceptRecorder("application/pdf", "")
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("Expected: %d, got: %d", http.StatusNotAcceptable, w.Code)
+	}
+}
+
+func TestResponseVersion(t *testing.T) {
+	for _, query := range []string{"query=Boyd", "query=Boyd&v=1"} {
+		body := strings.TrimSpace(searchRecorder(query).Body.String())
+		if !strings.HasPrefix(body, "[") {
+			t.Errorf("Expected bare array for %s, got: %s", query, body)
+		}
+	}
+
+	w := searchRecorder("query=Boyd&v=2")
+	envelope := struct {
+		Version int    `json:"version"`
+		Data    []User `json:"data"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	if envelope.Version != 2 || len(envelope.Data) != 1 || envelope.Data[0].Name != "Boyd Wolf" {
+		t.Errorf("Unexpected envelope: %+v", envelope)
+	}
+
+	if w = searchRecorder("v=3"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+
+	// Клиент понимает обе версии
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+	for _, v := range []string{"1", "2"} {
+		srchResp, err := ts.client.FindUsers(SearchRequest{Query: "Boyd", Extra: map[string]string{"v": v}})
+		if err != nil {
+			t.Fatalf("Invalid error: %v", err.Error())
+		}
+		if len(srchResp.Users) != 1 || srchResp.Users[0].Name != "Boyd Wolf" {
+			t.Errorf("Expected: Boyd Wolf for v=%s, got: %v", v, srchResp.Users)
+		}
+	}
+}
+
+func TestFormatHTML(t *testing.T) {
+	fileName = "testdata/handle.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	w := searchRecorder("format=html&limit=1&offset=1&query=a")
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Expected text/html, got: %s", ct)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "<td>Hilda Mayer</td>") || strings.Contains(body, "Boyd Wolf") {
+		t.Errorf("Expected only Hilda Mayer, got: %s", body)
+	}
+	for _, link := range []string{`href="?format=html&amp;limit=1&amp;offset=0&amp;query=a"`, `href="?format=html&amp;limit=1&amp;offset=2&amp;query=a"`} {
+		if !strings.Contains(body, link) {
+			t.Errorf("Expected link %s, got: %s", link, body)
+		}
+	}
+
+	// Последняя неполная страница без ссылки вперед, первая - без ссылки назад
+	body = searchRecorder("format=html&limit=5").Body.String()
+	if strings.Contains(body, ">prev<") || strings.Contains(body, ">next<") {
+		t.Errorf("Unexpected links: %s", body)
+	}
+}
+
+func TestFormatHTMLEscaping(t *testing.T) {
+	path := useTempDataset(t)
+	b, _ := os.ReadFile(path) //nolint:errcheck
+	b = []byte(strings.Replace(string(b), "<first_name>Boyd</first_name>", "<first_name>Boyd</first_name><about>&lt;script&gt;alert(1)&lt;/script&gt; &amp; co</about>", 1))
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	body := searchRecorder("format=html&query=Boyd").Body.String()
+	if strings.Contains(body, "<script>") || !strings.Contains(body, "&lt;script&gt;alert(1)&lt;/script&gt; &amp; co") {
+		t.Errorf("Expected escaped About, got: %s", body)
+	}
+}
+
+// streamRecorder считает, сколько байт пришло в ответ и каким самым большим
+// куском, не сохраняя само тело
+type streamRecorder struct {
+	header   http.Header
+	lines    int
+	total    int
+	maxWrite int
+	flushes  int
+	// Сколько байт было отправлено к первому Flush
+	firstFlush int
+}
+
+func (w *streamRecorder) Header() http.Header { return w.header }
+
+func (w *streamRecorder) WriteHeader(int) {}
+
+func (w *streamRecorder) Write(b []byte) (int, error) {
+	w.total += len(b)
+	w.maxWrite = max(w.maxWrite, len(b))
+	w.lines += bytes.Count(b, []byte("\n"))
+	return len(b), nil
+}
+
+func (w *streamRecorder) Flush() {
+	if w.flushes == 0 {
+		w.firstFlush = w.total
+	}
+	w.flushes++
+}
+
+func TestFormatCSVStreaming(t *testing.T) {
+	const rows = 5000
+
+	var data bytes.Buffer
+	data.WriteString("<root>\n")
+	for id := 0; id < rows; id++ {
+		fmt.Fprintf(&data, "<row><id>%d</id><age>%d</age><first_name>User</first_name><last_name>N%d</last_name>"+
+			"<gender>male</gender><about>%s</about></row>\n", id, 20+id%50, id, strings.Repeat("lorem ipsum ", 20))
+	}
+	data.WriteString("</root>\n")
+
+	path := filepath.Join(t.TempDir(), "large.xml")
+	if err := os.WriteFile(path, data.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fileName = path
+	defer func() { fileName = "dataset.xml" }()
+
+	req := httptest.NewRequest("GET", "/?format=csv&limit=0", nil)
+	req.Header.Set("AccessToken", accessToken)
+	w := &streamRecorder{header: http.Header{}}
+	SearchServer(w, req)
+
+	if w.lines != rows+1 {
+		t.Errorf("Expected: %d lines, got: %d", rows+1, w.lines)
+	}
+	// Ответ пишется кусками по размеру буфера csv.Writer, а не одним файлом
+	if w.total < 1<<20 || w.maxWrite > 64<<10 {
+		t.Errorf("Expected chunked output, got %d bytes with max write %d", w.total, w.maxWrite)
+	}
+	// Первые строки уходят клиенту задолго до конца выгрузки
+	if w.flushes != rows/CSVFlushRows || w.firstFlush == 0 || w.firstFlush > w.total/5 {
+		t.Errorf("Expected: %d flushes starting early, got: %d after %d of %d bytes",
+			rows/CSVFlushRows, w.flushes, w.firstFlush, w.total)
+	}
+}
+
+func TestFormatSSE(t *testing.T) {
+	req := httptest.NewRequest("GET", "/?format=sse&query=Boyd&limit=0", nil)
+	req.Header.Set("AccessToken", accessToken)
+	w := httptest.NewRecorder()
+	SearchServer(w, req)
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("Expected: text/event-stream, got: %s", ct)
+	}
+	if !w.Flushed {
+		t.Error("Expected events to be flushed")
+	}
+
+	expected := len(decodeUsers(t, searchRecorder("query=Boyd&limit=0")))
+	events := strings.Split(strings.TrimSuffix(w.Body.String(), "\n\n"), "\n\n")
+	if len(events) != expected+1 {
+		t.Fatalf("Expected: %d events, got: %d (%s)", expected+1, len(events), w.Body.String())
+	}
+	for _, event := range events[:expected] {
+		var user User
+		data, ok := strings.CutPrefix(event, "event: user\ndata: ")
+		if !ok || json.Unmarshal([]byte(data), &user) != nil || !strings.Contains(user.Name+user.About, "Boyd") {
+			t.Errorf("Expected user event, got: %q", event)
+		}
+	}
+	if end := fmt.Sprintf("event: end\ndata: {\"count\":%d}", expected); events[expected] != end {
+		t.Errorf("Expected: %q, got: %q", end, events[expected])
+	}
+
+	// Без http.Flusher события уходят обычным телом
+	req = httptest.NewRequest("GET", "/?format=sse&limit=0", nil)
+	req.Header.Set("AccessToken", accessToken)
+	sw := &streamRecorder{header: http.Header{}}
+	SearchServer(struct{ http.ResponseWriter }{sw}, req)
+	if sw.lines != 3*(len(decodeUsers(t, searchRecorder("limit=0")))+1) || sw.flushes != 0 {
+		t.Errorf("Expected 3 lines per event without flushes, got: %d lines, %d flushes", sw.lines, sw.flushes)
+	}
+}
+
+func TestFormatGob(t *testing.T) {
+	expected := decodeUsers(t, searchRecorder("limit=0"))
+
+	w := searchRecorder("format=gob&limit=0")
+	if ct := w.Header().Get("Content-Type"); ct != gobContentType {
+		t.Errorf("Expected: %s, got: %s", gobContentType, ct)
+	}
+	var users []User
+	if err := gob.NewDecoder(w.Body).Decode(&users); err != nil {
+		t.Fatalf("Invalid gob: %v", err)
+	}
+	if !reflect.DeepEqual(users, expected) {
+		t.Errorf("Expected %d users as in JSON, got: %d", len(expected), len(users))
+	}
+
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+	client := NewSearchClient(accessToken, ts.server.URL, WithGob())
+	resp, err := client.FindUsers(SearchRequest{Query: "Boyd", Limit: 25})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Users, decodeUsers(t, searchRecorder("query=Boyd&limit=25"))) {
+		t.Errorf("Expected same users as JSON, got: %v", resp.Users)
+	}
+}
+
+func TestFormatProtobuf(t *testing.T) {
+	expected := decodeUsers(t, searchRecorder("limit=0&offsets=1&with_slug=1&query=e"))
+
+	w := acceptRecorder(protobufContentType, "limit=0&offsets=1&with_slug=1&query=e")
+	if ct := w.Header().Get("Content-Type"); ct != protobufContentType {
+		t.Errorf("Expected: %s, got: %s", protobufContentType, ct)
+	}
+	users, err := unmarshalUsersProto(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Invalid protobuf: %v", err)
+	}
+	if len(expected) == 0 || !reflect.DeepEqual(users, expected) {
+		t.Errorf("Expected %d users as in JSON, got: %d", len(expected), len(users))
+	}
+
+	if _, err := unmarshalUsersProto([]byte{0x0a, 0x05, 0x08}); err == nil {
+		t.Errorf("Expected error for truncated message")
+	}
+}
+
+func TestUnwrapSingle(t *testing.T) {
+	w := searchRecorder("unwrap_single=1&query=Boyd")
+	user := User{}
+	if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+		t.Fatalf("Expected single object, got: %s", w.Body.String())
+	}
+	if w.Code != http.StatusOK || user.ID != 0 || user.Name != "Boyd Wolf" {
+		t.Errorf("Unexpected user: %d %+v", w.Code, user)
+	}
+
+	// Отдается первый из нескольких найденных, limit не учитывается
+	w = searchRecorder("unwrap_single=1&order_field=age&order_by=-1&limit=5&fields=id,age")
+	fields := map[string]int{}
+	if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+		t.Fatalf("Expected single object, got: %s", w.Body.String())
+	}
+	if fields["Age"] != 40 || len(fields) != 2 {
+		t.Errorf("Expected oldest user, got: %v", fields)
+	}
+
+	w = searchRecorder("unwrap_single=1&query=nobody-matches-this")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), ErrorNoSingleUser) {
+		t.Errorf("Expected: %d %q, got: %d %s", http.StatusNotFound, ErrorNoSingleUser, w.Code, w.Body.String())
+	}
+}
+
+func TestDownload(t *testing.T) {
+	cases := []struct {
+		query       string
+		disposition string
+	}{
+		{"download=1&query=Boyd", `attachment; filename="search.json"`},
+		{"download=1&v=2&with_meta=1&query=Boyd", `attachment; filename="search.json"`},
+		{"download=1&format=csv&query=Boyd", `attachment; filename="search.csv"`},
+		{"download=1&format=xml&query=Boyd", `attachment; filename="search.xml"`},
+		{"format=csv&query=Boyd", `attachment; filename="users.csv"`},
+		{"query=Boyd", ""},
+	}
+	for _, c := range cases {
+		w := searchRecorder(c.query)
+		if disposition := w.Header().Get("Content-Disposition"); disposition != c.disposition {
+			t.Errorf("%s: expected: %q, got: %q", c.query, c.disposition, disposition)
+		}
+	}
+
+	// Тело не меняется
+	if w := searchRecorder("download=1&query=Boyd"); !reflect.DeepEqual(decodeUsers(t, w), decodeUsers(t, searchRecorder("query=Boyd"))) {
+		t.Errorf("Unexpected body: %s", w.Body.String())
+	}
+}
+
+func TestHiddenFieldsAllFormats(t *testing.T) {
+	DefaultHiddenFields = []string{"about"}
+	defer func() { DefaultHiddenFields = nil }()
+
+	// About Boyd Wolf начинается с "Nulla cillum"
+	for _, format := range []string{"json", "csv", "xml", "html", "sse", "gob", "protobuf"} {
+		w := searchRecorder("query=Boyd&format=" + format)
+		body := w.Body.Bytes()
+		switch format {
+		case "gob", "protobuf":
+			users, err := decodeUsersBody(w.Header().Get("Content-Type"), body)
+			if err != nil || len(users) != 1 {
+				t.Fatalf("%s: expected one user, got: %v %v", format, users, err)
+			}
+			body = []byte(users[0].About)
+		}
+		if w.Code != http.StatusOK || strings.Contains(string(body), "Nulla cillum") {
+			t.Errorf("%s: expected no About, got: %d %s", format, w.Code, body)
+		}
+	}
+
+	// Явно запрошенное поле отдается
+	if w := searchRecorder("query=Boyd&format=csv&fields=all"); !strings.Contains(w.Body.String(), "Nulla cillum") {
+		t.Errorf("Expected About with fields=all, got: %s", w.Body.String())
+	}
+}
+
+// Кодировщик в protobuf.go пишется вручную, поэтому users.proto сверяется с
+// ним: каждое поле сообщения User кодируется своим номером и разбирается
+// обратно, а каждое поле User, кроме SortKey, есть в сообщении
+func TestProtobufMatchesSchema(t *testing.T) {
+	schema, err := os.ReadFile("users.proto")
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	message := regexp.MustCompile(`(?s)message User \{(.*?)\}`).FindSubmatch(schema)
+	if message == nil {
+		t.Fatalf("No message User in users.proto")
+	}
+	fieldPattern := regexp.MustCompile(`(?m)^\s*(?:optional\s+)?(\w+)\s+(\w+)\s*=\s*(\d+);`)
+
+	userType := reflect.TypeOf(User{})
+	declared := map[string]bool{}
+	for _, match := range fieldPattern.FindAllSubmatch(message[1], -1) {
+		protoType, name := string(match[1]), string(match[2])
+		num, _ := strconv.Atoi(string(match[3])) //nolint:errcheck
+		declared[name] = true
+
+		field, ok := userType.FieldByNameFunc(func(goName string) bool { return snakeCase(goName) == name })
+		if !ok {
+			t.Errorf("%s: no such field in User", name)
+			continue
+		}
+		user := User{}
+		value := reflect.ValueOf(&user).Elem().FieldByIndex(field.Index)
+		switch {
+		case protoType == "string" && field.Type.Kind() == reflect.String:
+			value.SetString("x")
+		case protoType == "int64" && field.Type.Kind() == reflect.Int:
+			value.SetInt(7)
+		case protoType == "int64" && field.Type == reflect.TypeOf((*int)(nil)):
+			offset := 7
+			value.Set(reflect.ValueOf(&offset))
+		default:
+			t.Errorf("%s: proto type %s does not match %s", name, protoType, field.Type)
+			continue
+		}
+
+		var nums []int
+		walkProto(marshalUserProto(user), func(num int, _ uint64, _ []byte) error { //nolint:errcheck
+			nums = append(nums, num)
+			return nil
+		})
+		if !slices.Equal(nums, []int{num}) {
+			t.Errorf("%s: expected field %d, got: %v", name, num, nums)
+		}
+		if decoded, err := unmarshalUserProto(marshalUserProto(user)); err != nil || !reflect.DeepEqual(decoded, user) {
+			t.Errorf("%s: expected round trip, got: %+v %v", name, decoded, err)
+		}
+	}
+
+	for i := 0; i < userType.NumField(); i++ {
+		if name := snakeCase(userType.Field(i).Name); name != "sort_key" && !declared[name] {
+			t.Errorf("%s: missing in users.proto", name)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Словари для GenerateDataset
+var (
+	generatedFirstNames = []string{"Boyd", "Hilda", "Brooks", "Owen", "Glenn", "Rose", "Cohen", "Nicholson", "Whitley", "Gates"}
+	generatedLastNames  = []string{"Wolf", "Mayer", "Aguilar", "Lynn", "Jordan", "Chang", "Hines", "Newton", "Shannon", "Spencer"}
+	generatedCities     = []string{"Oslo", "Lima", "Kyiv", "Perth", "Quito", "Riga"}
+	generatedWords      = strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod " +
+		"tempor incididunt ut labore et dolore magna aliqua nulla cillum enim voluptate velit esse")
+)
+
+// GenerateDataset возвращает XML с n строками в формате dataset.xml для
+// тестов и бенчмарков на больших данных без большого файла в репозитории.
+// Строки псевдослучайные, при одинаковых n и seed результат одинаковый
+func GenerateDataset(n int, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed)) //nolint:gosec
+	pick := func(items []string) string {
+		return items[rnd.Intn(len(items))]
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString("<root>\n")
+	for id := 0; id < n; id++ {
+		gender := "male"
+		if rnd.Intn(2) == 0 {
+			gender = "female"
+		}
+		about := make([]string, 5+rnd.Intn(20))
+		for idx := range about {
+			about[idx] = pick(generatedWords)
+		}
+		fmt.Fprintf(&buf, "  <row><id>%d</id><age>%d</age><first_name>%s</first_name><last_name>%s</last_name>"+
+			"<gender>%s</gender><city>%s</city><about>%s.</about></row>\n",
+			id, 18+rnd.Intn(60), pick(generatedFirstNames), pick(generatedLastNames),
+			gender, pick(generatedCities), strings.Join(about, " "))
+	}
+	buf.WriteString("</root>\n")
+	return buf.Bytes()
+}
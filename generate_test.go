@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateDataset(t *testing.T) {
+	for _, n := range []int{0, 1, 1000} {
+		data := xmlData{}
+		if err := xml.Unmarshal(GenerateDataset(n, 42), &data); err != nil {
+			t.Fatalf("Invalid xml for %d rows: %v", n, err)
+		}
+		if len(data.Rows) != n {
+			t.Errorf("Expected: %d rows, got: %d", n, len(data.Rows))
+		}
+	}
+
+	if !bytes.Equal(GenerateDataset(100, 1), GenerateDataset(100, 1)) {
+		t.Error("Expected same dataset for same seed")
+	}
+	if bytes.Equal(GenerateDataset(100, 1), GenerateDataset(100, 2)) {
+		t.Error("Expected different datasets for different seeds")
+	}
+}
+
+func TestUnlimitedGenerated(t *testing.T) {
+	const rows = 300
+
+	path := filepath.Join(t.TempDir(), "generated.xml")
+	if err := os.WriteFile(path, GenerateDataset(rows, 1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fileName = path
+	defer func() { fileName = "dataset.xml" }()
+
+	// С настройками по умолчанию limit=0 отдает все строки
+	if users := decodeUsers(t, searchRecorder("limit=0")); len(users) != rows {
+		t.Errorf("Expected: %d, got: %d", rows, len(users))
+	}
+	if w := searchRecorder("limit=0&format=csv"); strings.Count(w.Body.String(), "\n") != rows+1 {
+		t.Errorf("Expected: %d CSV lines, got: %d", rows+1, strings.Count(w.Body.String(), "\n"))
+	}
+}
+
+func BenchmarkSearchServerGenerated(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "generated.xml")
+	if err := os.WriteFile(path, GenerateDataset(100_000, 1), 0o600); err != nil {
+		b.Fatal(err)
+	}
+	fileName = path
+	defer func() { fileName = "dataset.xml" }()
+	// Загрузка в кеш не входит в замер
+	if _, err := getDataset(fileName); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, query := range []string{"query=Boyd&limit=10", "query=nulla&order_field=age&order_by=1&limit=10", "limit=0"} {
+		b.Run(query, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest("GET", "/?"+query, nil)
+				req.Header.Set("AccessToken", accessToken)
+				w := httptest.NewRecorder()
+				SearchServer(w, req)
+				if w.Code != http.StatusOK {
+					b.Fatalf("Expected: %d, got: %d", http.StatusOK, w.Code)
+				}
+			}
+		})
+	}
+}
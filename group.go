@@ -0,0 +1,41 @@
+package main
+
+const (
+	// ErrorBadGroupBy отдается, если по полю group_by нельзя группировать
+	ErrorBadGroupBy = `group_by invalid`
+	// ErrorBadPerGroupLimit отдается на отрицательный или нечисловой per_group_limit
+	ErrorBadPerGroupLimit = `per_group_limit invalid`
+)
+
+// Поля, по которым работает group_by
+var groupFields = []string{"gender", "city", "country"}
+
+// groupKey возвращает значение поля field, по которому группируется user
+func groupKey(user User, field string) string {
+	switch field {
+	case "city":
+		return user.City
+	case "country":
+		return user.Country
+	}
+	return user.Gender
+}
+
+// newGroupsResponse раскладывает найденных и уже отсортированных users по
+// значениям поля group_by. Порядок внутри группы - как в выборке, страница
+// offset/per_group_limit берется в каждой группе отдельно
+func newGroupsResponse(users []User, params *queryDTO) map[string]interface{} {
+	groups := map[string][]User{}
+	for _, user := range users {
+		key := groupKey(user, params.groupBy)
+		groups[key] = append(groups[key], user)
+	}
+
+	result := make(map[string]interface{}, len(groups))
+	for key, group := range groups {
+		page := paginateData(group, params.offset, params.perGroupLimit)
+		annotateUsers(page, params)
+		result[key] = projectUsers(restrictUsers(page, params.allowedFields), params.projection, params.omitEmptyFields)
+	}
+	return result
+}
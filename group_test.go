@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func decodeGroups(t *testing.T, query string) map[string][]User {
+	t.Helper()
+	w := searchRecorder(query)
+	groups := map[string][]User{}
+	if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil {
+		t.Fatalf("Invalid error: %v, body: %s", err, w.Body.String())
+	}
+	return groups
+}
+
+func TestGroupBy(t *testing.T) {
+	groups := decodeGroups(t, "group_by=gender&order_field=age&order_by=1&per_group_limit=3")
+	if len(groups) != 2 {
+		t.Fatalf("Expected male and female groups, got: %v", groups)
+	}
+	for gender, users := range groups {
+		// Группа совпадает с первой страницей поиска по этому полу
+		expected := decodeUsers(t, searchRecorder("gender="+gender+"&order_field=age&order_by=1&limit=3"))
+		if len(users) != 3 || !reflect.DeepEqual(users, expected) {
+			t.Errorf("%s: expected: %v, got: %v", gender, userIDs(expected), userIDs(users))
+		}
+	}
+
+	// offset тоже в каждой группе
+	groups = decodeGroups(t, "group_by=gender&order_field=id&order_by=-1&offset=2&per_group_limit=2&query=e")
+	for gender, users := range groups {
+		expected := decodeUsers(t, searchRecorder("gender="+gender+"&order_field=id&order_by=-1&offset=2&limit=2&query=e"))
+		if !reflect.DeepEqual(users, expected) {
+			t.Errorf("%s: expected: %v, got: %v", gender, userIDs(expected), userIDs(users))
+		}
+	}
+
+	// Без per_group_limit группа ограничивается limit
+	for gender, users := range decodeGroups(t, "group_by=gender&limit=4") {
+		if len(users) != 4 {
+			t.Errorf("%s: expected 4 users, got: %d", gender, len(users))
+		}
+	}
+
+	for _, query := range []string{"group_by=about", "group_by=gender&per_group_limit=-1", "group_by=gender&per_group_limit=x"} {
+		if w := searchRecorder(query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected: %d, got: %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
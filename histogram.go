@@ -0,0 +1,70 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+const (
+	// ErrorBadHistogramField отдается /histogram для нечислового поля field
+	ErrorBadHistogramField = `histogram field must be numeric`
+	// ErrorBadHistogramBucket отдается /histogram, если bucket не является положительным числом
+	ErrorBadHistogramBucket = `bucket invalid`
+)
+
+// Одна группа гистограммы: диапазон вида "20-24" и число пользователей в нем
+type histogramBucket struct {
+	Range string `json:"range"`
+	Count int    `json:"count"`
+}
+
+// histogramHandler отдает гистограмму числового поля по найденным
+// пользователям: /histogram?field=age&bucket=5&gender=male. Группы идут по
+// возрастанию от минимального значения до максимального, пустые группы
+// между ними тоже попадают в ответ. bucket по умолчанию - AgeBucketWidth
+func histogramHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	field := query.Get("field")
+	if !slices.Contains(statsFields, field) {
+		sendError(w, http.StatusBadRequest, ErrorBadHistogramField)
+		return
+	}
+	width := AgeBucketWidth
+	if bucket := query.Get("bucket"); bucket != "" {
+		var err error
+		if width, err = strconv.Atoi(bucket); err != nil || width <= 0 {
+			sendError(w, http.StatusBadRequest, ErrorBadHistogramBucket)
+			return
+		}
+	}
+
+	result, params, ok := searchUsers(w, r, false)
+	if !ok {
+		return
+	}
+	if !params.fieldAllowed(field) {
+		sendError(w, http.StatusBadRequest, ErrorBadHistogramField)
+		return
+	}
+	sendResponse(w, newHistogram(result, field, width))
+}
+
+// newHistogram раскладывает значения field по группам ширины width
+func newHistogram(users []User, field string, width int) []histogramBucket {
+	counts := map[int]int{}
+	for _, user := range users {
+		counts[bucketLow(sortKey(user, field).(int), width)]++
+	}
+	if len(counts) == 0 {
+		return []histogramBucket{}
+	}
+
+	lows := slices.Sorted(maps.Keys(counts))
+	var result []histogramBucket
+	for low := lows[0]; low <= lows[len(lows)-1]; low += width {
+		result = append(result, histogramBucket{Range: ageBucket(low, width), Count: counts[low]})
+	}
+	return result
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	w := recorder("/histogram", "field=age&bucket=5")
+	var buckets []histogramBucket
+	if err := json.Unmarshal(w.Body.Bytes(), &buckets); err != nil {
+		t.Fatalf("Invalid error: %v, body: %s", err, w.Body.String())
+	}
+	expected := []histogramBucket{
+		{"20-24", 5}, {"25-29", 8}, {"30-34", 12}, {"35-39", 8}, {"40-44", 2},
+	}
+	if !slices.Equal(buckets, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, buckets)
+	}
+
+	// Пустые группы между крайними значениями остаются в ответе, возрасты 21 и 36
+	buckets = nil
+	w = recorder("/histogram", "field=age&bucket=5&order_ids=1,33")
+	if err := json.Unmarshal(w.Body.Bytes(), &buckets); err != nil {
+		t.Fatalf("Invalid error: %v, body: %s", err, w.Body.String())
+	}
+	expected = []histogramBucket{{"20-24", 1}, {"25-29", 0}, {"30-34", 0}, {"35-39", 1}}
+	if !slices.Equal(buckets, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, buckets)
+	}
+
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		{"field=name", ErrorBadHistogramField},
+		{"bucket=5", ErrorBadHistogramField},
+		{"field=age&bucket=0", ErrorBadHistogramBucket},
+		{"field=age&bucket=x", ErrorBadHistogramBucket},
+	}
+	for _, c := range cases {
+		w := recorder("/histogram", c.query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), c.expected) {
+			t.Errorf("Expected %q for %s, got: %d %s", c.expected, c.query, w.Code, w.Body.String())
+		}
+	}
+}
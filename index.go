@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ключ /index для имен, начинающихся не с латинской буквы
+const indexOther = "#"
+
+// indexHandler возвращает число найденных пользователей по первой букве
+// имени для алфавитного указателя: {"A":0,"B":7,...,"#":0}. Буквы A-Z
+// есть всегда, с omit_empty=1 нулевые не отдаются. Имена на другие буквы
+// и символы считаются под "#"
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	result, _, ok := searchUsers(w, r, false)
+	if !ok {
+		return
+	}
+
+	counts := map[string]int{indexOther: 0}
+	for letter := 'A'; letter <= 'Z'; letter++ {
+		counts[string(letter)] = 0
+	}
+	for _, user := range result {
+		first, _ := utf8.DecodeRuneInString(user.Name)
+		key := string(unicode.ToUpper(first))
+		if _, ok := counts[key]; !ok {
+			key = indexOther
+		}
+		counts[key]++
+	}
+
+	if flagParam(r.URL.Query().Get("omit_empty")) {
+		for key, count := range counts {
+			if count == 0 {
+				delete(counts, key)
+			}
+		}
+	}
+	sendResponse(w, counts)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func indexRecorder(query string) map[string]int {
+	w := recorder("/index", query)
+	result := map[string]int{}
+	_ = json.Unmarshal(w.Body.Bytes(), &result) //nolint:errcheck
+	return result
+}
+
+func TestIndex(t *testing.T) {
+	users := decodeUsers(t, searchRecorder("limit=0"))
+	expectedB := 0
+	for _, user := range users {
+		if strings.HasPrefix(user.Name, "B") {
+			expectedB++
+		}
+	}
+
+	counts := indexRecorder("")
+	if counts["B"] != expectedB || expectedB == 0 {
+		t.Errorf("Expected: %v, got: %v", expectedB, counts["B"])
+	}
+	if count, ok := counts["Q"]; !ok || count != 0 {
+		t.Errorf("Expected Q with 0, got: %v", counts)
+	}
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	if total != len(users) {
+		t.Errorf("Expected: %v, got: %v", len(users), total)
+	}
+
+	// Считается отфильтрованная выборка, limit не влияет
+	counts = indexRecorder("query=Boyd&omit_empty=1&limit=1")
+	if len(counts) != 1 || counts["B"] != 1 {
+		t.Errorf("Expected only B: 1, got: %v", counts)
+	}
+}
+
+func TestIndexOther(t *testing.T) {
+	fileName = "testdata/collation.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	// Имена на É и Å не попадают в A-Z, строчная буква приводится к заглавной
+	counts := indexRecorder("omit_empty=1")
+	expected := map[string]int{"A": 1, "Z": 1, indexOther: 2}
+	if !maps.Equal(counts, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, counts)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// MaxConcurrent - сколько запросов RunServer обрабатывает одновременно.
+// Остальные сразу получают 503 с Retry-After. 0 - без ограничения
+var MaxConcurrent = 0
+
+// RetryAfterSeconds - значение Retry-After в ответах 503
+var RetryAfterSeconds = 1
+
+// limitConcurrency пропускает к next не больше n запросов одновременно.
+// При n <= 0 возвращает next без ограничения
+func limitConcurrency(n int, next http.Handler) http.Handler {
+	if n <= 0 {
+		return next
+	}
+
+	sem := make(chan struct{}, n)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			next.ServeHTTP(w, r)
+		default:
+			sendUnavailable(w, "server is busy")
+		}
+	})
+}
+
+// sendUnavailable отправляет 503 с Retry-After, чтобы клиент знал, когда повторить
+func sendUnavailable(w http.ResponseWriter, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(RetryAfterSeconds))
+	sendError(w, http.StatusServiceUnavailable, msg)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimitConcurrency(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	// Медленный обработчик держит единственный слот, пока его не отпустят
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("slow") == "1" {
+			close(started)
+			<-release
+		}
+		SearchServer(w, r)
+	})
+
+	server := httptest.NewServer(limitConcurrency(1, slow))
+	defer server.Close()
+
+	get := func(query string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/?"+query, nil) //nolint:errcheck
+		req.Header.Set("AccessToken", accessToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("Invalid error: %v", err)
+			return nil
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	done := make(chan *http.Response)
+	go func() { done <- get("slow=1&query=Boyd") }()
+	<-started
+
+	resp := get("query=Boyd")
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("Expected: %d with Retry-After, got: %d %v", http.StatusServiceUnavailable, resp.StatusCode, resp.Header)
+	}
+
+	// Клиент возвращает подсказку, когда повторить
+	client := SearchClient{AccessToken: accessToken, URL: server.URL}
+	_, err := client.FindUsers(SearchRequest{Query: "Boyd"})
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) || unavailable.RetryAfter != time.Second || unavailable.Message != "server is busy" {
+		t.Errorf("Expected UnavailableError with retry after 1s, got: %v", err)
+	}
+
+	close(release)
+	if resp = <-done; resp == nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected slow request to succeed, got: %v", resp)
+	}
+
+	// Слот освободился
+	if resp = get("query=Boyd"); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected: %d, got: %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestLimitConcurrencyDisabled(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/?query=Boyd", nil)
+	req.Header.Set("AccessToken", accessToken)
+	limitConcurrency(0, http.HandlerFunc(SearchServer)).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestUnavailableErrorRetryAfter(t *testing.T) {
+	cases := []struct {
+		retryAfter string
+		min, max   time.Duration
+	}{
+		{"3", 3 * time.Second, 3 * time.Second},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+		{"", 0, 0},
+		{"soon", 0, 0},
+	}
+	for _, c := range cases {
+		resp := &http.Response{Header: http.Header{}}
+		if c.retryAfter != "" {
+			resp.Header.Set("Retry-After", c.retryAfter)
+		}
+		err := newUnavailableError(resp, []byte(`{"Error":"server is busy"}`))
+		if err.RetryAfter < c.min || err.RetryAfter > c.max || err.Message != "server is busy" {
+			t.Errorf("Unexpected error for Retry-After %q: %+v", c.retryAfter, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// Итоговые параметры поиска после подстановки значений по умолчанию и ограничений
+type paramsMeta struct {
+	Query       string `json:"query"`
+	SearchField string `json:"search_field"`
+	MatchMode   string `json:"match_mode"`
+	OrderField  string `json:"order_field"`
+	OrderBy     int    `json:"order_by"`
+	Offset      int    `json:"offset"`
+	Limit       int    `json:"limit"`
+}
+
+// Ответ в режиме with_meta=1
+type metaResponse struct {
+	Users  interface{} `json:"users"`
+	Params paramsMeta  `json:"params"`
+}
+
+// Ответ count_only=1 и fields=count
+type countOnlyResponse struct {
+	Count int `json:"count"`
+}
+
+// Ответ в режиме with_counts=1: сколько пользователей нашлось всего до
+// пагинации и сколько строк в данных
+type countsResponse struct {
+	Users        interface{} `json:"users"`
+	Matched      int         `json:"matched"`
+	TotalScanned int         `json:"total_scanned"`
+}
+
+// Ответ в режиме with_schema=1: типы полей пользователей по ключам JSON
+type schemaResponse struct {
+	Users  interface{}       `json:"users"`
+	Schema map[string]string `json:"schema"`
+}
+
+// Ссылки на страницы поиска для links=1: тот же запрос с другим offset.
+// next и prev нет на последней и первой страницах
+type pageLinks struct {
+	Self  string `json:"self"`
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	First string `json:"first"`
+	Last  string `json:"last"`
+}
+
+// Ответ в режиме links=1
+type linksResponse struct {
+	Users interface{} `json:"users"`
+	Links pageLinks   `json:"links"`
+}
+
+// newPageLinks строит ссылки на страницы по адресу запроса target и числу
+// найденных total. При limit=0 вся выборка - одна страница
+func newPageLinks(target *url.URL, total int, params *queryDTO) pageLinks {
+	link := func(offset int) string {
+		query := target.Query()
+		query.Set("offset", strconv.Itoa(offset))
+		return (&url.URL{Path: target.Path, RawQuery: query.Encode()}).String()
+	}
+
+	last := 0
+	if params.limit > 0 && total > 0 {
+		last = (total - 1) / params.limit * params.limit
+	}
+	result := pageLinks{Self: link(params.offset), First: link(0), Last: link(last)}
+	if params.limit > 0 && params.offset+params.limit < total {
+		result.Next = link(params.offset + params.limit)
+	}
+	if params.limit > 0 && params.offset > 0 {
+		result.Prev = link(max(params.offset-params.limit, 0))
+	}
+	return result
+}
+
+// Ответ с v=2: пользователи лежат в data, version - версия формата
+type versionedResponse struct {
+	Version int         `json:"version"`
+	Data    interface{} `json:"data"`
+}
+
+func newParamsMeta(params *queryDTO) paramsMeta {
+	return paramsMeta{
+		Query:       params.query,
+		SearchField: params.searchField,
+		MatchMode:   params.matchMode,
+		OrderField:  params.orderField,
+		OrderBy:     params.orderBy,
+		Offset:      params.offset,
+		Limit:       params.limit,
+	}
+}
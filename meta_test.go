@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestWithMeta(t *testing.T) {
+	defer func(maxLimit int) { MaxLimit = maxLimit }(MaxLimit)
+	MaxLimit = MaxPageSize
+
+	w := searchRecorder("with_meta=1&query=e&order_by=1&limit=100")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+
+	result := struct {
+		Users  []User     `json:"users"`
+		Params paramsMeta `json:"params"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+
+	expected := paramsMeta{Query: "e", OrderField: "name", OrderBy: OrderByAsc, Limit: MaxPageSize}
+	if result.Params != expected {
+		t.Errorf("Expected: %+v, got: %+v", expected, result.Params)
+	}
+	if len(result.Users) != MaxPageSize {
+		t.Errorf("Expected: %v, got: %v", MaxPageSize, len(result.Users))
+	}
+}
+
+func TestMaxLimitUnlimited(t *testing.T) {
+	defer func(maxLimit int) { MaxLimit = maxLimit }(MaxLimit)
+	MaxLimit = 20
+
+	w := searchRecorder("limit=0")
+	if users := decodeUsers(t, w); len(users) != 20 {
+		t.Errorf("Expected: %v, got: %v", 20, len(users))
+	}
+	if w.Header().Get("X-Search-Warnings") != "limit clamped to 20" {
+		t.Errorf("Unexpected warnings: %s", w.Header().Get("X-Search-Warnings"))
+	}
+}
+
+func TestWithCounts(t *testing.T) {
+	cases := []struct {
+		query string
+		page  int
+	}{
+		{"query=voluptate&limit=2", 2},
+		{"gender=female&limit=3&offset=2", 3},
+		{"query=Boyd", 1},
+		{"query=nobody-matches-this", 0},
+	}
+	for _, c := range cases {
+		// Первое значение параметра важнее следующих
+		matched := len(decodeUsers(t, searchRecorder("limit=0&offset=0&"+c.query)))
+
+		w := searchRecorder("with_counts=1&" + c.query)
+		result := struct {
+			Users        []User `json:"users"`
+			Matched      int    `json:"matched"`
+			TotalScanned int    `json:"total_scanned"`
+		}{}
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Invalid error: %v for %s", err, c.query)
+		}
+		if result.TotalScanned != 35 || result.Matched != matched || result.Matched > result.TotalScanned || len(result.Users) != c.page {
+			t.Errorf("Expected matched %d and %d users for %s, got: %+v", matched, c.page, c.query, result)
+		}
+		// Без сортировки с with_counts все равно просматриваются все строки
+		if scanned := w.Header().Get("X-Rows-Scanned"); scanned != "35" {
+			t.Errorf("Expected full scan for %s, got: %s", c.query, scanned)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	decode := func(query string) (users []User, links pageLinks) {
+		t.Helper()
+		result := struct {
+			Users []User    `json:"users"`
+			Links pageLinks `json:"links"`
+		}{}
+		if err := json.Unmarshal(searchRecorder(query).Body.Bytes(), &result); err != nil {
+			t.Fatalf("Invalid error: %v for %s", err, query)
+		}
+		return result.Users, result.Links
+	}
+	link := func(offset string) string {
+		return "/?gender=female&limit=5&links=1&offset=" + offset
+	}
+
+	// 11 женщин: страницы с offset 0, 5 и 10
+	cases := []struct {
+		offset     string
+		users      int
+		next, prev string
+	}{
+		{"0", 5, link("5"), ""},
+		{"5", 5, link("10"), link("0")},
+		{"10", 1, "", link("5")},
+	}
+	for _, c := range cases {
+		users, links := decode("links=1&gender=female&limit=5&offset=" + c.offset)
+		expected := pageLinks{Self: link(c.offset), Next: c.next, Prev: c.prev, First: link("0"), Last: link("10")}
+		if len(users) != c.users || links != expected {
+			t.Errorf("Expected %d users and %+v for offset %s, got: %d %+v", c.users, expected, c.offset, len(users), links)
+		}
+	}
+
+	// Все на одной странице
+	_, links := decode("links=1&limit=0")
+	if links.Next != "" || links.Prev != "" || links.First != links.Last {
+		t.Errorf("Unexpected links for limit=0: %+v", links)
+	}
+}
+
+func TestCountOnly(t *testing.T) {
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+	client := NewSearchClient(accessToken, ts.server.URL)
+	all, err := client.FindAllUsers(SearchRequest{Query: "e"})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+
+	// Сортировка и пагинация не учитываются
+	for _, query := range []string{
+		"count_only=1&query=e",
+		"fields=count&query=e",
+		"fields=count&query=e&limit=2&offset=5&order_field=age&order_by=1",
+	} {
+		var resp map[string]int
+		w := searchRecorder(query)
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp) != 1 || resp["count"] != len(all) {
+			t.Errorf("%s: expected count %d, got: %d %s", query, len(all), w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestWithSchema(t *testing.T) {
+	var resp struct {
+		Users  []map[string]interface{}
+		Schema map[string]string
+	}
+	w := searchRecorder("with_schema=1&fields=id,name,age&query=Boyd")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Users) != 1 {
+		t.Fatalf("Invalid response: %v, body: %s", err, w.Body.String())
+	}
+	expected := map[string]string{"ID": "int", "Name": "string", "Age": "int"}
+	if !reflect.DeepEqual(resp.Schema, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, resp.Schema)
+	}
+	for key := range resp.Users[0] {
+		if _, ok := resp.Schema[key]; !ok {
+			t.Errorf("Field %s missing in schema %v", key, resp.Schema)
+		}
+	}
+
+	resp.Schema = nil
+	w = searchRecorder("with_schema=1&query=Boyd")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid response: %v, body: %s", err, w.Body.String())
+	}
+	for key, typ := range map[string]string{"ID": "int", "Name": "string", "Gender": "string", "About": "string", "MatchOffset": "int", "SortKey": "any"} {
+		if resp.Schema[key] != typ {
+			t.Errorf("Expected %s: %s, got: %v", key, typ, resp.Schema)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Префикс адреса сохраненного запроса: /named/staff
+const namedPrefix = "/named/"
+
+// ErrorUnknownNamedQuery отдается на /named/ с незарегистрированным именем
+const ErrorUnknownNamedQuery = `named query not found`
+
+// NamedQueries - сохраненные запросы по имени, например
+// "staff": {"gender": {"female"}, "order_field": {"age"}, "order_by": {"1"}}.
+// Доступны по /named/staff, параметры запроса заменяют одноименные
+// сохраненные. Заполняется до запуска сервера
+var NamedQueries = map[string]url.Values{}
+
+// namedRequest возвращает копию r для поиска по сохраненному запросу name
+// с параметрами r поверх сохраненных. false, если такого запроса нет
+func namedRequest(r *http.Request, name string) (*http.Request, bool) {
+	saved, ok := NamedQueries[name]
+	if !ok {
+		return nil, false
+	}
+
+	query := url.Values{}
+	for key, values := range saved {
+		query[key] = append([]string(nil), values...)
+	}
+	for key, values := range r.URL.Query() {
+		query[key] = values
+	}
+
+	named := r.Clone(r.Context())
+	named.URL.Path = "/"
+	named.URL.RawQuery = query.Encode()
+	return named, true
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNamedQuery(t *testing.T) {
+	NamedQueries["staff"] = url.Values{
+		"gender":      {"female"},
+		"order_field": {"age"},
+		"order_by":    {"1"},
+		"limit":       {"0"},
+	}
+	defer delete(NamedQueries, "staff")
+
+	expected := userIDs(decodeUsers(t, searchRecorder("gender=female&order_field=age&order_by=1&limit=0")))
+	users := decodeUsers(t, recorder("/named/staff", ""))
+	if len(expected) == 0 || !slices.Equal(userIDs(users), expected) {
+		t.Errorf("Expected: %v, got: %v", expected, userIDs(users))
+	}
+	for _, user := range users {
+		if user.Gender != "female" {
+			t.Errorf("Expected only female users, got: %v", user)
+		}
+	}
+
+	// Параметр запроса заменяет сохраненный
+	if users = decodeUsers(t, recorder("/named/staff", "limit=3")); !slices.Equal(userIDs(users), expected[:3]) {
+		t.Errorf("Expected: %v, got: %v", expected[:3], userIDs(users))
+	}
+
+	w := recorder("/named/unknown", "")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), ErrorUnknownNamedQuery) {
+		t.Errorf("Expected %d %q, got: %d %s", http.StatusNotFound, ErrorUnknownNamedQuery, w.Code, w.Body.String())
+	}
+}
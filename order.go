@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	// ErrorBadOrderDirection отдается, если направление поля в order_field не asc и не desc
+	ErrorBadOrderDirection = `order_field direction must be asc or desc`
+	// ErrorBadCISortFields отдается, если в ci_sort_fields есть не строковое поле
+	ErrorBadCISortFields = `ci_sort_fields invalid`
+)
+
+// CaseInsensitiveSortFields - строковые поля, которые по умолчанию
+// сортируются без учета регистра. Запрос переопределяет список через
+// ci_sort_fields, пустой ci_sort_fields= - все поля с учетом регистра
+var CaseInsensitiveSortFields []string
+
+// Поля, для которых есть выбор регистра в ci_sort_fields. email всегда
+// сравнивается без учета регистра
+var ciSortableFields = []string{"name", "city", "email"}
+
+// Поле сортировки из order_field=age:desc,name:asc
+type orderKey struct {
+	field string
+	// OrderByAsc или OrderByDesc, OrderByAsIs - направление из order_by
+	direction int
+}
+
+// parseOrderKeys разбирает order_field из нескольких полей через запятую,
+// каждое с необязательным направлением ":asc" или ":desc". Для одного поля
+// без направления возвращает nil: сортировка идет как раньше по orderField
+func parseOrderKeys(value string) ([]orderKey, error) {
+	if !strings.ContainsAny(value, ",:") {
+		return nil, nil
+	}
+
+	var keys []orderKey
+	for _, item := range strings.Split(value, ",") {
+		field, direction, hasDirection := strings.Cut(item, ":")
+		key := orderKey{field: field}
+		if hasDirection {
+			switch direction {
+			case "asc":
+				key.direction = OrderByAsc
+			case "desc":
+				key.direction = OrderByDesc
+			default:
+				return nil, &paramError{ErrorBadOrderDirection}
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// knownOrderFields - все поля сортировки из order_field поддерживаются
+func (q *queryDTO) knownOrderFields() bool {
+	if len(q.orderKeys) == 0 {
+		return slices.Contains(orderFields, q.orderField)
+	}
+	for _, key := range q.orderKeys {
+		if !slices.Contains(orderFields, key.field) {
+			return false
+		}
+	}
+	return true
+}
+
+// orderCompare сравнивает пользователей по полям сортировки с учетом
+// направлений, без ID при равенстве
+func orderCompare(params *queryDTO) (func(a, b User) int, error) {
+	keys := params.orderKeys
+	if len(keys) == 0 {
+		keys = []orderKey{{field: params.orderField}}
+	}
+
+	compares := make([]func(a, b User) int, len(keys))
+	for idx, key := range keys {
+		compare, err := compareFunc(key.field, params)
+		if err != nil {
+			return nil, err
+		}
+		direction := key.direction
+		if direction == OrderByAsIs {
+			direction = params.orderBy
+		}
+		compares[idx] = directedCompare(compare, direction)
+	}
+
+	return func(a, b User) int {
+		for _, compare := range compares {
+			if result := compare(a, b); result != 0 {
+				return result
+			}
+		}
+		return 0
+	}, nil
+}
+
+// directedCompare применяет направление к сравнению по возрастанию:
+// для OrderByDesc порядок полностью обратный
+func directedCompare(compare func(a, b User) int, direction int) func(a, b User) int {
+	if direction != OrderByDesc {
+		return compare
+	}
+	return func(a, b User) int { return compare(b, a) }
+}
+
+// parseCISortFields разбирает ci_sort_fields, без параметра берется
+// CaseInsensitiveSortFields
+func parseCISortFields(values url.Values) (map[string]bool, error) {
+	fields := CaseInsensitiveSortFields
+	if values.Has("ci_sort_fields") {
+		fields = nil
+		if value := values.Get("ci_sort_fields"); value != "" {
+			fields = strings.Split(value, ",")
+		}
+	}
+
+	result := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if !slices.Contains(ciSortableFields, field) {
+			return nil, &paramError{ErrorBadCISortFields}
+		}
+		result[field] = true
+	}
+	return result, nil
+}
+
+// sortFold возвращает приведение значения поля field перед сравнением:
+// нижний регистр для полей из ci_sort_fields, иначе значение как есть
+func (q *queryDTO) sortFold(field string) func(string) string {
+	if q.ciSortFields[field] {
+		return strings.ToLower
+	}
+	return func(s string) string { return s }
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CertPinError возвращается, если сертификат сервера не совпал с закрепленным
+type CertPinError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *CertPinError) Error() string {
+	return fmt.Sprintf("certificate fingerprint mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// Приведение отпечатка к виду hex в нижнем регистре без двоеточий
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+// CertFingerprint возвращает SHA-256 отпечаток сертификата в hex
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// WithPinnedCert закрепляет сертификат сервера по SHA-256 отпечатку (hex,
+// допускаются двоеточия). Проверка цепочки CA заменяется сравнением отпечатка,
+// поэтому работает и с самоподписанными сертификатами. При несовпадении
+// FindUsers возвращает ошибку, содержащую *CertPinError
+func WithPinnedCert(fingerprint string) ClientOption {
+	expected := normalizeFingerprint(fingerprint)
+
+	return func(srv *SearchClient) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec // заменяется проверкой отпечатка
+			VerifyConnection: func(state tls.ConnectionState) error {
+				if len(state.PeerCertificates) == 0 {
+					return &CertPinError{Expected: expected}
+				}
+				actual := CertFingerprint(state.PeerCertificates[0].Raw)
+				if actual != expected {
+					return &CertPinError{Expected: expected, Actual: actual}
+				}
+				return nil
+			},
+		}
+
+		srv.httpClient = &http.Client{Timeout: client.Timeout, Transport: transport}
+	}
+}
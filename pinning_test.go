@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPinnedCert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+
+	fingerprint := CertFingerprint(server.Certificate().Raw)
+
+	// Отпечаток в верхнем регистре с двоеточиями тоже принимается
+	var colonized []string
+	for i := 0; i < len(fingerprint); i += 2 {
+		colonized = append(colonized, strings.ToUpper(fingerprint[i:i+2]))
+	}
+
+	for _, pin := range []string{fingerprint, strings.Join(colonized, ":")} {
+		client := NewSearchClient(accessToken, server.URL, WithPinnedCert(pin))
+		srchResp, err := client.FindUsers(SearchRequest{Query: "Boyd"})
+		if err != nil {
+			t.Fatalf("Invalid error: %v", err.Error())
+		}
+		if len(srchResp.Users) != 1 {
+			t.Errorf("Expected: %v, got: %v", 1, len(srchResp.Users))
+		}
+	}
+}
+
+func TestPinnedCertMismatch(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+
+	client := NewSearchClient(accessToken, server.URL, WithPinnedCert(strings.Repeat("00", 32)))
+	_, err := client.FindUsers(SearchRequest{})
+
+	var pinErr *CertPinError
+	if !errors.As(err, &pinErr) {
+		t.Fatalf("Expected CertPinError, got: %v", err)
+	}
+	if pinErr.Actual != CertFingerprint(server.Certificate().Raw) {
+		t.Errorf("Expected: %v, got: %v", CertFingerprint(server.Certificate().Raw), pinErr.Actual)
+	}
+}
+
+func TestRequireHTTPS(t *testing.T) {
+	RequireHTTPS = true
+	defer func() { RequireHTTPS = false }()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+
+	client := SearchClient{AccessToken: accessToken, URL: server.URL, httpClient: server.Client()}
+	srchResp, err := client.FindUsers(SearchRequest{Query: "Boyd"})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if len(srchResp.Users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(srchResp.Users))
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer plain.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, plain.URL+"/?query=Boyd", nil) //nolint:errcheck
+	req.Header.Set("AccessToken", accessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("Expected: %d, got: %d", http.StatusUpgradeRequired, resp.StatusCode)
+	}
+}
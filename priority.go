@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrorBadPriorityIDs отдается, если priority_ids не список пар id:вес через запятую
+const ErrorBadPriorityIDs = `priority_ids invalid`
+
+// parsePriorityIDs разбирает priority_ids=9:100,2:50 в вес по id. Без
+// order_field сортировка идет по убыванию веса (order_field=priority), у
+// id не из списка вес 0. С order_field приоритет можно указать среди полей:
+// order_field=priority,name
+func parsePriorityIDs(q *queryDTO) error {
+	value := q.values.Get("priority_ids")
+	if value == "" {
+		return nil
+	}
+
+	q.priority = map[int]float64{}
+	for _, item := range strings.Split(value, ",") {
+		idValue, weightValue, ok := strings.Cut(item, ":")
+		if !ok {
+			return &paramError{ErrorBadPriorityIDs}
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(idValue))
+		if err != nil {
+			return &paramError{ErrorBadPriorityIDs}
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(weightValue), 64)
+		if err != nil || math.IsInf(weight, 0) || math.IsNaN(weight) {
+			return &paramError{ErrorBadPriorityIDs}
+		}
+		q.priority[id] = weight
+	}
+
+	if q.orderField == "" {
+		q.orderField = "priority"
+		if q.orderBy == OrderByAsIs {
+			q.orderBy = OrderByAsc
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// ErrorBadFields отдается, если в fields указано неизвестное поле
+	ErrorBadFields = `fields invalid`
+	// ErrorTooManyFields отдается, если fields перечисляет больше MaxFields полей
+	ErrorTooManyFields = `too many fields`
+)
+
+// MaxFields - сколько разных полей можно перечислить в fields=. 0 - без
+// ограничения. Запрос без fields= получает все поля и не ограничивается
+var MaxFields = 0
+
+// DefaultHiddenFields - поля, которые не отдаются, если запрос не перечислил
+// их явно в fields=. Например []string{"about"}, чтобы не отдавать длинный About
+var DefaultHiddenFields []string
+
+// userFields сопоставляет имена полей для fields= (snake_case) с ключами JSON User
+var userFields = jsonFieldNames(reflect.TypeOf(User{}))
+
+// Имена полей структуры в JSON по именам для параметров: "AgeBucket" -> "age_bucket"
+func jsonFieldNames(t reflect.Type) map[string]string {
+	result := map[string]string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if key := jsonFieldKey(field); key != "-" {
+			result[snakeCase(field.Name)] = key
+		}
+	}
+	return result
+}
+
+// jsonFieldKey - ключ поля структуры в JSON, "-" для пропускаемых
+func jsonFieldKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if key == "" {
+		key = field.Name
+	}
+	return key
+}
+
+// snakeCase переводит имя поля Go в snake_case: "ID" -> "id", "AgeBucket" -> "age_bucket"
+func snakeCase(name string) string {
+	var result strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) ||
+			i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			result.WriteByte('_')
+		}
+		result.WriteRune(unicode.ToLower(r))
+	}
+	return result.String()
+}
+
+// fields=all - все поля, включая DefaultHiddenFields
+const fieldsAll = "all"
+
+// fields=count - только число найденных, см. count_only
+const fieldsCount = "count"
+
+// parseFields возвращает ключи JSON, которые нужно отдать, или nil, если
+// отдаются все поля. Без fields= скрываются DefaultHiddenFields. Поля из
+// exclude убираются из выбранных, в том числе перечисленных в fields:
+// исключение важнее, fields=all&exclude_fields=about - все, кроме About
+func parseFields(fields, exclude string) (map[string]bool, error) {
+	if fields == "" && exclude == "" && len(DefaultHiddenFields) == 0 {
+		return nil, nil
+	}
+
+	result := map[string]bool{}
+	switch fields {
+	case "", fieldsAll:
+		for _, key := range userFields {
+			result[key] = true
+		}
+		if fields == "" {
+			for _, name := range DefaultHiddenFields {
+				delete(result, userFields[name])
+			}
+		}
+	default:
+		keys, err := fieldKeys(fields)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			result[key] = true
+		}
+	}
+
+	if exclude != "" {
+		keys, err := fieldKeys(exclude)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			delete(result, key)
+		}
+	}
+
+	if fields != "" && MaxFields > 0 && len(result) > MaxFields {
+		return nil, &paramError{ErrorTooManyFields}
+	}
+	return result, nil
+}
+
+// userSchema возвращает типы полей User, которые могут прийти в ответе с
+// projection (nil - все поля): "int", "string" или "any" для SortKey
+func userSchema(projection map[string]bool) map[string]string {
+	t := reflect.TypeOf(User{})
+	result := map[string]string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := jsonFieldKey(field)
+		if key == "-" || projection != nil && !projection[key] {
+			continue
+		}
+		kind := field.Type.Kind()
+		if kind == reflect.Pointer {
+			kind = field.Type.Elem().Kind()
+		}
+		switch kind {
+		case reflect.Int:
+			result[key] = "int"
+		case reflect.String:
+			result[key] = "string"
+		default:
+			result[key] = "any"
+		}
+	}
+	return result
+}
+
+// restrictProjection оставляет в projection только поля из allowed. nil
+// projection (все поля) становится allowed
+func restrictProjection(projection, allowed map[string]bool) map[string]bool {
+	if allowed == nil {
+		return projection
+	}
+	result := map[string]bool{}
+	for key := range allowed {
+		if projection == nil || projection[key] {
+			result[key] = true
+		}
+	}
+	return result
+}
+
+// restrictUsers возвращает копии users с обнуленными полями не из allowed,
+// чтобы их не было и в форматах без fields=: CSV, XML, gob
+func restrictUsers(users []User, allowed map[string]bool) []User {
+	if allowed == nil {
+		return users
+	}
+	t := reflect.TypeOf(User{})
+	result := make([]User, len(users))
+	for idx, user := range users {
+		v := reflect.ValueOf(&user).Elem()
+		for i := 0; i < t.NumField(); i++ {
+			if !allowed[jsonFieldKey(t.Field(i))] {
+				v.Field(i).SetZero()
+			}
+		}
+		result[idx] = user
+	}
+	return result
+}
+
+// fieldKeys переводит имена полей через запятую в ключи JSON
+func fieldKeys(fields string) ([]string, error) {
+	var result []string
+	for _, name := range strings.Split(fields, ",") {
+		key, ok := userFields[strings.TrimSpace(name)]
+		if !ok {
+			return nil, &paramError{ErrorBadFields}
+		}
+		result = append(result, key)
+	}
+	return result, nil
+}
+
+// projectUsers оставляет у пользователей только поля из projection.
+// При omitEmpty у каждого пользователя убираются поля с нулевыми значениями
+func projectUsers(users []User, projection map[string]bool, omitEmpty bool) interface{} {
+	if projection == nil && !omitEmpty {
+		return users
+	}
+
+	result := make([]interface{}, 0, len(users))
+	for _, user := range users {
+		result = append(result, projectUser(user, projection, omitEmpty))
+	}
+	return result
+}
+
+// projectUser оставляет у пользователя только поля из projection, а при
+// omitEmpty еще и только непустые. ID 0 при omitEmpty остается: без него
+// пользователя нельзя отличить
+func projectUser(user User, projection map[string]bool, omitEmpty bool) interface{} {
+	if projection == nil && !omitEmpty {
+		return user
+	}
+
+	b, _ := json.Marshal(user) //nolint:errcheck
+	fields := map[string]json.RawMessage{}
+	_ = json.Unmarshal(b, &fields) //nolint:errcheck
+	for key, value := range fields {
+		if projection != nil && !projection[key] || omitEmpty && key != userFields["id"] && isZeroJSON(value) {
+			delete(fields, key)
+		}
+	}
+	return fields
+}
+
+// Нулевое значение в JSON: пустая строка, 0, false, null, пустые массив и объект
+func isZeroJSON(value json.RawMessage) bool {
+	switch string(value) {
+	case `""`, `0`, `false`, `null`, `[]`, `{}`:
+		return true
+	}
+	return false
+}
+
+// usersByID собирает пользователей в объект с ключами-id для as_map=1.
+// При повторе id остается первый пользователь, о повторе пишется в лог
+// с request_id запроса
+func usersByID(users []User, params *queryDTO) map[string]interface{} {
+	result := make(map[string]interface{}, len(users))
+	for _, user := range users {
+		key := strconv.Itoa(user.ID)
+		if _, ok := result[key]; ok {
+			slog.Warn("as_map: duplicate id, keeping the first user",
+				"id", user.ID,
+				"request_id", params.requestID,
+			)
+			continue
+		}
+		result[key] = projectUser(user, params.projection, params.omitEmptyFields)
+	}
+	return result
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSnakeCase(t *testing.T) {
+	cases := map[string]string{"ID": "id", "Name": "name", "AgeBucket": "age_bucket", "City": "city"}
+	for name, expected := range cases {
+		if actual := snakeCase(name); actual != expected {
+			t.Errorf("Expected: %s, got: %s", expected, actual)
+		}
+	}
+}
+
+// Ключи JSON каждого пользователя из ответа
+func responseKeys(t *testing.T, query string) []map[string]json.RawMessage {
+	t.Helper()
+	w := searchRecorder(query)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+	result := []map[string]json.RawMessage{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	return result
+}
+
+func TestFieldsProjection(t *testing.T) {
+	for _, user := range responseKeys(t, "fields=id,name&limit=3") {
+		if len(user) != 2 || user["ID"] == nil || user["Name"] == nil {
+			t.Errorf("Expected only ID and Name, got: %v", user)
+		}
+	}
+
+	if w := searchRecorder("fields=id,password"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestExcludeFields(t *testing.T) {
+	keys := func(query string) []string {
+		t.Helper()
+		users := responseKeys(t, query+"&query=Boyd")
+		if len(users) != 1 {
+			t.Fatalf("Expected 1 user for %s, got: %v", query, users)
+		}
+		return slices.Sorted(maps.Keys(users[0]))
+	}
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{"fields=id,name", []string{"ID", "Name"}},
+		// Телефон отдается, только если запрошен явно
+		{"exclude_fields=about", []string{"Age", "Email", "Gender", "ID", "Name"}},
+		{"fields=all&exclude_fields=about,email", []string{"Age", "Gender", "ID", "Name", "Phone"}},
+		{"fields=all&exclude_fields=about,email,phone", []string{"Age", "Gender", "ID", "Name"}},
+		// Исключение важнее перечисления в fields
+		{"fields=id,about&exclude_fields=about", []string{"ID"}},
+	}
+	for _, c := range cases {
+		if actual := keys(c.query); !slices.Equal(actual, c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, actual)
+		}
+	}
+
+	// fields=all отдает и скрытые по умолчанию поля
+	DefaultHiddenFields = []string{"about"}
+	defer func() { DefaultHiddenFields = nil }()
+	if actual := keys("fields=all&exclude_fields=email,phone"); !slices.Equal(actual, []string{"About", "Age", "Gender", "ID", "Name"}) {
+		t.Errorf("Expected About with fields=all, got: %v", actual)
+	}
+	if actual := keys("exclude_fields=email,phone"); !slices.Equal(actual, []string{"Age", "Gender", "ID", "Name"}) {
+		t.Errorf("Expected hidden About, got: %v", actual)
+	}
+
+	if w := searchRecorder("exclude_fields=password"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestMaxFields(t *testing.T) {
+	MaxFields = 2
+	defer func() { MaxFields = 0 }()
+
+	w := searchRecorder("fields=id,name,age")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorTooManyFields) {
+		t.Errorf("Expected %d %q, got: %d %s", http.StatusBadRequest, ErrorTooManyFields, w.Code, w.Body.String())
+	}
+
+	for _, user := range responseKeys(t, "fields=id,name,id&limit=3") {
+		if len(user) != 2 {
+			t.Errorf("Expected ID and Name, got: %v", user)
+		}
+	}
+}
+
+func TestDefaultHiddenFields(t *testing.T) {
+	DefaultHiddenFields = []string{"about"}
+	defer func() { DefaultHiddenFields = nil }()
+
+	for _, user := range responseKeys(t, "limit=3") {
+		if _, ok := user["About"]; ok {
+			t.Errorf("Expected About to be hidden, got: %v", user)
+		}
+		if user["Name"] == nil || user["Age"] == nil {
+			t.Errorf("Expected other fields, got: %v", user)
+		}
+	}
+
+	for _, user := range responseKeys(t, "limit=3&fields=id,about") {
+		if user["About"] == nil || len(user) != 2 {
+			t.Errorf("Expected ID and About, got: %v", user)
+		}
+	}
+
+	// Клиент разбирает ответ без скрытых полей
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+	srchResp, err := ts.client.FindUsers(SearchRequest{Query: "Boyd"})
+	if err != nil {
+		t.Fatalf("Invalid error: %v", err.Error())
+	}
+	if len(srchResp.Users) != 1 || srchResp.Users[0].About != "" || srchResp.Users[0].Name != "Boyd Wolf" {
+		t.Errorf("Unexpected users: %v", srchResp.Users)
+	}
+}
+
+func TestAsMap(t *testing.T) {
+	w := searchRecorder("as_map=1&order_field=id&order_by=1&limit=5")
+	result := map[string]User{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	if len(result) != 5 {
+		t.Errorf("Expected: %v, got: %v", 5, len(result))
+	}
+	for key, user := range result {
+		if key != strconv.Itoa(user.ID) {
+			t.Errorf("Expected key %d, got: %s", user.ID, key)
+		}
+	}
+
+	// Повтор id не теряет первого пользователя и пишется в лог с request_id
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	users := usersByID([]User{{ID: 1, Name: "first"}, {ID: 1, Name: "second"}, {ID: 2}}, &queryDTO{requestID: "req-1"})
+	if len(users) != 2 || users["1"].(User).Name != "first" {
+		t.Errorf("Unexpected map: %v", users)
+	}
+	if line := buf.String(); !strings.Contains(line, "id=1") || !strings.Contains(line, "request_id=req-1") {
+		t.Errorf("Expected duplicate id with request_id in log, got: %s", line)
+	}
+}
+
+func TestOmitEmptyFieldsKeepsID(t *testing.T) {
+	users := responseKeys(t, "omitempty_fields=1&query=Boyd")
+	if len(users) != 1 || string(users[0]["ID"]) != "0" {
+		t.Errorf("Expected ID 0 for Boyd Wolf, got: %v", users)
+	}
+}
+
+func TestOmitEmptyFields(t *testing.T) {
+	fileName = "testdata/about.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	users := responseKeys(t, "omitempty_fields=1")
+	if len(users) != 3 {
+		t.Fatalf("Expected: %v, got: %v", 3, len(users))
+	}
+	if users[0]["About"] == nil {
+		t.Errorf("Expected About for user with description, got: %v", users[0])
+	}
+	// About из одних пробелов после TrimXMLText пустой, как и отсутствующий
+	if _, ok := users[1]["About"]; ok {
+		t.Errorf("Expected trimmed About to be omitted, got: %v", users[1])
+	}
+	if _, ok := users[2]["About"]; ok {
+		t.Errorf("Expected About to be omitted, got: %v", users[2])
+	}
+	// ID 0 - нулевое значение, но идентификатор не убирается
+	if string(users[0]["ID"]) != "0" || users[0]["Name"] == nil || users[2]["ID"] == nil {
+		t.Errorf("Unexpected fields: %v, %v", users[0], users[2])
+	}
+
+	for _, user := range responseKeys(t, "") {
+		if _, ok := user["About"]; !ok {
+			t.Errorf("Expected About without omitempty_fields, got: %v", user)
+		}
+	}
+}
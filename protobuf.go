@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Content-Type ответа format=protobuf
+const protobufContentType = "application/x-protobuf"
+
+// Типы значений protobuf, которые встречаются в сообщениях users.proto
+const (
+	protoVarint = 0
+	protoBytes  = 2
+)
+
+// errBadProtobuf - ответ format=protobuf не разбирается
+var errBadProtobuf = errors.New("invalid protobuf")
+
+// Отправка пользователей сообщением Users из users.proto. SortKey и
+// поля, которых нет в сообщении, не передаются. Скрытые поля sendUsers уже
+// обнулил, а нулевые значения в proto3 не пишутся
+func sendProtobuf(w http.ResponseWriter, users []User) {
+	b := marshalUsersProto(users)
+	w.Header().Set("Content-Type", protobufContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	_, _ = w.Write(b) //nolint:errcheck
+}
+
+// marshalUsersProto кодирует users сообщением Users
+func marshalUsersProto(users []User) []byte {
+	var b []byte
+	for _, user := range users {
+		b = appendProtoBytes(b, 1, marshalUserProto(user))
+	}
+	return b
+}
+
+// marshalUserProto кодирует user сообщением User. Нулевые значения не
+// пишутся, как в proto3, кроме match_offset
+func marshalUserProto(user User) []byte {
+	var b []byte
+	b = appendProtoInt(b, 1, user.ID)
+	b = appendProtoInt(b, 3, user.Age)
+	fields := protoStrings(&user)
+	for _, num := range slices.Sorted(maps.Keys(fields)) {
+		b = appendProtoString(b, num, *fields[num])
+	}
+	if user.MatchOffset != nil {
+		b = binary.AppendUvarint(b, uint64(13<<3|protoVarint))
+		b = binary.AppendUvarint(b, uint64(int64(*user.MatchOffset)))
+	}
+	return b
+}
+
+// protoStrings - строковые поля User по номерам из users.proto
+func protoStrings(user *User) map[int]*string {
+	return map[int]*string{
+		2:  &user.Name,
+		4:  &user.About,
+		5:  &user.Gender,
+		6:  &user.City,
+		7:  &user.Country,
+		8:  &user.Handle,
+		9:  &user.Email,
+		10: &user.Phone,
+		11: &user.AgeBucket,
+		12: &user.Snippet,
+		14: &user.Slug,
+		15: &user.AgeLabel,
+	}
+}
+
+func appendProtoInt(b []byte, num, value int) []byte {
+	if value == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(num<<3|protoVarint))
+	return binary.AppendUvarint(b, uint64(int64(value)))
+}
+
+func appendProtoString(b []byte, num int, value string) []byte {
+	if value == "" {
+		return b
+	}
+	return appendProtoBytes(b, num, []byte(value))
+}
+
+func appendProtoBytes(b []byte, num int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num<<3|protoBytes))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// unmarshalUsersProto разбирает сообщение Users. Неизвестные поля
+// пропускаются
+func unmarshalUsersProto(b []byte) ([]User, error) {
+	users := []User{}
+	err := walkProto(b, func(num int, value uint64, data []byte) error {
+		if num != 1 || data == nil {
+			return nil
+		}
+		user, err := unmarshalUserProto(data)
+		if err != nil {
+			return err
+		}
+		users = append(users, user)
+		return nil
+	})
+	return users, err
+}
+
+func unmarshalUserProto(b []byte) (User, error) {
+	var user User
+	err := walkProto(b, func(num int, value uint64, data []byte) error {
+		if data == nil {
+			switch num {
+			case 1:
+				user.ID = int(int64(value))
+			case 3:
+				user.Age = int(int64(value))
+			case 13:
+				offset := int(int64(value))
+				user.MatchOffset = &offset
+			}
+			return nil
+		}
+		if field, ok := protoStrings(&user)[num]; ok {
+			*field = string(data)
+		}
+		return nil
+	})
+	return user, err
+}
+
+// walkProto вызывает fn для каждого поля сообщения b: value для varint,
+// data для строк и вложенных сообщений (не nil, даже если пустые)
+func walkProto(b []byte, fn func(num int, value uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errBadProtobuf
+		}
+		b = b[n:]
+		num := int(key >> 3)
+
+		switch key & 7 {
+		case protoVarint:
+			value, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errBadProtobuf
+			}
+			b = b[n:]
+			if err := fn(num, value, nil); err != nil {
+				return err
+			}
+		case protoBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errBadProtobuf
+			}
+			data := b[n : n+int(size) : n+int(size)]
+			b = b[n+int(size):]
+			if err := fn(num, 0, data); err != nil {
+				return err
+			}
+		default:
+			return errBadProtobuf
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// ErrorBadRank отдается /rank, если n не является положительным числом
+const ErrorBadRank = `n must be > 0`
+
+// rankHandler возвращает одного пользователя на позиции n (с единицы)
+// после фильтрации и сортировки, например третьего по возрасту для
+// /rank?order_field=age&order_by=1&n=3. Если позиции нет - 404
+func rankHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n < 1 {
+		sendError(w, http.StatusBadRequest, ErrorBadRank)
+		return
+	}
+
+	result, params, ok := searchUsers(w, r, false)
+	if !ok {
+		return
+	}
+
+	if n > len(result) {
+		sendError(w, http.StatusNotFound, "rank out of range")
+		return
+	}
+
+	// Поля ограничиваются так же, как в поиске: по токену, fields= и DefaultHiddenFields
+	user := restrictUsers(result[n-1:n], params.allowedFields)[0]
+	sendResponse(w, projectUser(user, params.projection, params.omitEmptyFields))
+}
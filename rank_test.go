@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRank(t *testing.T) {
+	cases := []struct {
+		query      string
+		expectedID int
+	}{
+		{"order_field=id&order_by=1&n=1", 0},
+		{"order_field=id&order_by=1&n=35", 34},
+		{"order_field=name&order_by=1&n=1", 15},
+		{"order_field=name&order_by=1&n=3", 19},
+		{"order_field=id&order_by=1&query=Boyd&n=1", 0},
+		// Без сортировки limit по умолчанию не ограничивает выборку
+		{"n=20", 19},
+	}
+
+	for _, c := range cases {
+		w := recorder("/rank", c.query)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected: %d for %s, got: %d", http.StatusOK, c.query, w.Code)
+			continue
+		}
+
+		user := User{}
+		if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+			t.Errorf("Invalid error: %v", err)
+		}
+		if user.ID != c.expectedID {
+			t.Errorf("Expected: %v for %s, got: %v", c.expectedID, c.query, user.ID)
+		}
+	}
+}
+
+func TestRankOutOfRange(t *testing.T) {
+	for _, query := range []string{"order_field=id&order_by=1&n=36", "query=nobody-matches&n=1"} {
+		w := recorder("/rank", query)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected: %d for %s, got: %d", http.StatusNotFound, query, w.Code)
+		}
+	}
+}
+
+func TestRankBadN(t *testing.T) {
+	for _, query := range []string{"n=0", "n=-1", "n=abc", ""} {
+		w := recorder("/rank", query)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected: %d for %s, got: %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
+
+func TestRankFields(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TokenFields = map[string][]string{"partner": {"id", "name"}}
+
+	rank := func(token, query string) map[string]interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/rank?"+query, nil)
+		req.Header.Set("AccessToken", token)
+		NewServer(cfg).ServeHTTP(w, req)
+		user := map[string]interface{}{}
+		if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+			t.Fatalf("Invalid error: %v, body: %s", err, w.Body.String())
+		}
+		return user
+	}
+
+	if user := rank("partner", "order_field=id&order_by=1&n=1"); len(user) != 2 || user["Name"] != "Boyd Wolf" {
+		t.Errorf("Expected ID and Name only, got: %v", user)
+	}
+	if user := rank("partner", "order_field=id&order_by=1&n=1&fields=name,email"); len(user) != 1 || user["Name"] != "Boyd Wolf" {
+		t.Errorf("Expected Name only, got: %v", user)
+	}
+
+	DefaultHiddenFields = []string{"about"}
+	defer func() { DefaultHiddenFields = nil }()
+	if user := rank(accessToken, "order_field=id&order_by=1&n=1"); user["About"] != nil || user["Email"] == nil {
+		t.Errorf("Expected all fields but About, got: %v", user)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// freshness_boost не является неотрицательным числом
+	ErrorBadFreshnessBoost = `freshness_boost invalid`
+	// now не в формате RFC 3339
+	ErrorBadNow = `now invalid`
+)
+
+// FreshnessHalfLife - за сколько времени вклад freshness_boost в
+// релевантность строки уменьшается вдвое
+var FreshnessHalfLife = 30 * 24 * time.Hour
+
+// parseRelevance разбирает freshness_boost и now для order_field=relevance.
+// now задает момент, от которого считается давность updated_at, по
+// умолчанию текущее время
+func parseRelevance(q *queryDTO) error {
+	if boost := q.values.Get("freshness_boost"); boost != "" {
+		value, err := strconv.ParseFloat(boost, 64)
+		if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+			return &paramError{ErrorBadFreshnessBoost}
+		}
+		q.freshnessBoost = value
+	}
+
+	q.now = time.Now()
+	if now := q.values.Get("now"); now != "" {
+		value, err := time.Parse(time.RFC3339, now)
+		if err != nil {
+			return &paramError{ErrorBadNow}
+		}
+		q.now = value
+	}
+
+	if q.orderField == "relevance" || slices.ContainsFunc(q.orderKeys, func(key orderKey) bool { return key.field == "relevance" }) {
+		q.relevance = map[int]float64{}
+	}
+	return nil
+}
+
+// relevanceScore - релевантность строки запросу: сколько раз слова query
+// встречаются в имени и About, плюс freshnessBoost * 0.5^(давность/FreshnessHalfLife)
+// для строк с updated_at. Свежесть не больше freshnessBoost, поэтому при
+// freshness_boost < 1 она только упорядочивает строки с равным числом совпадений
+func relevanceScore(row row, params *queryDTO) float64 {
+	text := row.FirstName + " " + row.LastName + " " + row.about(params.lang)
+	if params.normalize {
+		text = foldText(text)
+	} else if params.caseInsensitive {
+		text = strings.ToLower(text)
+	}
+
+	score := 0.0
+	for _, term := range params.include {
+		score += float64(strings.Count(text, term))
+	}
+
+	if params.freshnessBoost > 0 {
+		if updatedAt, err := time.Parse(time.RFC3339, row.UpdatedAt); err == nil {
+			age := max(params.now.Sub(updatedAt), 0)
+			score += params.freshnessBoost * math.Pow(0.5, float64(age)/float64(FreshnessHalfLife))
+		}
+	}
+	return score
+}
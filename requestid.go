@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Заголовок с идентификатором запроса для сквозной трассировки
+const requestIDHeader = "X-Request-ID"
+
+// Ключ идентификатора запроса в контексте
+type requestIDKey struct{}
+
+// withRequestID берет X-Request-ID из запроса или создает новый, отдает его
+// в ответе и кладет в контекст r. См. requestID
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// newRequestID возвращает случайный идентификатор из 32 hex-символов
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b) //nolint:errcheck
+	return hex.EncodeToString(b)
+}
+
+// requestID возвращает идентификатор запроса из ctx, пустой вне обработчика
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string) //nolint:errcheck
+	return id
+}
+
+// AccessLog пишет в лог с уровнем Info строку на каждый запрос: метод,
+// путь, статус, время обработки и request_id
+var AccessLog = false
+
+// RequestInfo - сведения о выполненном запросе для RequestObserver
+type RequestInfo struct {
+	RequestID string
+	Method    string
+	Path      string
+	Status    int
+	Elapsed   time.Duration
+}
+
+// RequestObserver вызывается после каждого запроса, например чтобы
+// записать метрики с RequestID в метках. nil - не вызывается
+var RequestObserver func(RequestInfo)
+
+// logRequest пишет запрос r со статусом status в access log и передает в
+// RequestObserver
+func logRequest(r *http.Request, status int, start time.Time) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	info := RequestInfo{
+		RequestID: requestID(r.Context()),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Status:    status,
+		Elapsed:   time.Since(start),
+	}
+	if AccessLog {
+		slog.Info("request",
+			"method", info.Method,
+			"path", info.Path,
+			"status", info.Status,
+			"elapsed", info.Elapsed,
+			"request_id", info.RequestID,
+		)
+	}
+	if RequestObserver != nil {
+		RequestObserver(info)
+	}
+}
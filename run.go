@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ShutdownTimeout - сколько ждать завершения текущих запросов при остановке
+var ShutdownTimeout = 10 * time.Second
+
+// RunServer запускает SearchServer на addr и работает до отмены ctx.
+// После отмены новые соединения не принимаются, а текущие запросы
+// дорабатывают в пределах ShutdownTimeout. Одновременных запросов не
+// больше MaxConcurrent. С WatchDataset данные перезагружаются при
+// изменении файла
+func RunServer(addr string, ctx context.Context) error { //nolint:revive
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if WatchDataset {
+		go watchDataset(ctx)
+	}
+	return serve(ctx, listener, limitConcurrency(MaxConcurrent, http.HandlerFunc(SearchServer)))
+}
+
+// serve обслуживает запросы на listener до отмены ctx
+func serve(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	if serveErr := <-errCh; !errors.Is(serveErr, http.ErrServerClosed) && err == nil {
+		err = serveErr
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRunServerGracefulShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		SearchServer(w, r)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, listener, handler)
+	}()
+
+	type result struct {
+		resp *SearchResponse
+		err  error
+	}
+	requestDone := make(chan result, 1)
+	go func() {
+		client := SearchClient{AccessToken: accessToken, URL: "http://" + listener.Addr().String()}
+		resp, err := client.FindUsers(SearchRequest{Query: "Boyd"})
+		requestDone <- result{resp, err}
+	}()
+
+	<-entered
+	cancel()
+
+	// Пока запрос не завершен, сервер не останавливается
+	select {
+	case err = <-done:
+		t.Fatalf("Server stopped with in-flight request: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	res := <-requestDone
+	if res.err != nil {
+		t.Fatalf("Invalid error: %v", res.err)
+	}
+	if len(res.resp.Users) != 1 {
+		t.Errorf("Expected: %v, got: %v", 1, len(res.resp.Users))
+	}
+
+	if err = <-done; err != nil {
+		t.Errorf("Invalid error: %v", err)
+	}
+
+	// После остановки новые соединения не принимаются
+	if _, err = net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("Expected connection error after shutdown")
+	}
+}
+
+func TestRunServerBadAddr(t *testing.T) {
+	if err := RunServer("bad-addr", context.Background()); err == nil {
+		t.Error("Expected listen error")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Значение order_by для случайного порядка
+const orderByRandom = "random"
+
+// ErrorBadSeed отдается, если seed не является целым числом
+const ErrorBadSeed = `seed invalid`
+
+// ErrorBadTieSeed отдается, если tie_seed не является целым числом
+const ErrorBadTieSeed = `tie_seed invalid`
+
+// parseSeed разбирает зерно для order_by=random. Без seed берется текущее
+// время, и выборка каждый раз разная
+func parseSeed(value string) (int64, error) {
+	if value == "" {
+		return time.Now().UnixNano(), nil
+	}
+
+	seed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, &paramError{ErrorBadSeed}
+	}
+	return seed, nil
+}
+
+// shuffleUsers перемешивает пользователей. При одинаковых seed и входных
+// данных порядок всегда одинаковый
+func shuffleUsers(users []User, seed int64) {
+	rnd := rand.New(rand.NewSource(seed)) //nolint:gosec
+	rnd.Shuffle(len(users), func(i, j int) {
+		users[i], users[j] = users[j], users[i]
+	})
+}
+
+// shuffleTies перемешивает пользователей внутри групп с равным ключом
+// сортировки (compare == 0), не меняя порядок самих групп. users уже
+// отсортированы. При одинаковых seed и входных данных порядок одинаковый
+func shuffleTies(users []User, seed int64, compare func(a, b User) int) {
+	rnd := rand.New(rand.NewSource(seed)) //nolint:gosec
+	for start := 0; start < len(users); {
+		end := start + 1
+		for end < len(users) && compare(users[start], users[end]) == 0 {
+			end++
+		}
+		group := users[start:end]
+		rnd.Shuffle(len(group), func(i, j int) {
+			group[i], group[j] = group[j], group[i]
+		})
+		start = end
+	}
+}
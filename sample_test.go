@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// ID пользователей из ответа
+func userIDs(users []User) []int {
+	ids := make([]int, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+func TestRandomSample(t *testing.T) {
+	first := userIDs(decodeUsers(t, searchRecorder("order_by=random&seed=42&limit=5")))
+	second := userIDs(decodeUsers(t, searchRecorder("order_by=random&seed=42&limit=5")))
+	if len(first) != 5 {
+		t.Fatalf("Expected: %v, got: %v", 5, len(first))
+	}
+	if !slices.Equal(first, second) {
+		t.Errorf("Expected same sample for same seed, got: %v and %v", first, second)
+	}
+
+	other := userIDs(decodeUsers(t, searchRecorder("order_by=random&seed=43&limit=5")))
+	if slices.Equal(first, other) {
+		t.Errorf("Expected different samples for different seeds, got: %v", first)
+	}
+
+	// Перемешиваются только найденные пользователи
+	for _, user := range decodeUsers(t, searchRecorder("order_by=random&seed=1&query=female&search_field=any")) {
+		if user.Gender != "female" {
+			t.Errorf("Expected: female, got: %v", user.Gender)
+		}
+	}
+}
+
+func TestRandomSampleBadSeed(t *testing.T) {
+	if w := searchRecorder("order_by=random&seed=abc"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestTieSeed(t *testing.T) {
+	ages := func(users []User) []int {
+		result := make([]int, len(users))
+		for idx, user := range users {
+			result[idx] = user.Age
+		}
+		return result
+	}
+
+	plain := decodeUsers(t, searchRecorder("order_field=age&order_by=1&limit=0"))
+	first := decodeUsers(t, searchRecorder("order_field=age&order_by=1&limit=0&tie_seed=1"))
+	second := decodeUsers(t, searchRecorder("order_field=age&order_by=1&limit=0&tie_seed=2"))
+
+	if !slices.Equal(ages(first), ages(plain)) || !slices.Equal(ages(second), ages(plain)) {
+		t.Errorf("Expected age order to be kept, got: %v and %v", ages(first), ages(second))
+	}
+	if slices.Equal(userIDs(first), userIDs(second)) {
+		t.Errorf("Expected different order within ages, got: %v", userIDs(first))
+	}
+
+	again := decodeUsers(t, searchRecorder("order_field=age&order_by=1&limit=0&tie_seed=1"))
+	if !slices.Equal(userIDs(first), userIDs(again)) {
+		t.Errorf("Expected same order for same tie_seed, got: %v and %v", userIDs(first), userIDs(again))
+	}
+
+	if w := searchRecorder("order_field=age&order_by=1&tie_seed=abc"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -1,155 +1,1679 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
-// Структура для разбора XML-данных
+// Структура для разбора XML-данных. Имя корня проверяется в readData по XMLRootName
 type xmlData struct {
-	XMLName xml.Name `xml:"root"`
-	Rows    []row    `xml:"row"`
+	XMLName xml.Name
+	Rows    []row `xml:"row"`
 }
 
+// XMLRootName - ожидаемое имя корневого элемента файла с данными.
+// Пустое значение принимает любой корень
+var XMLRootName = "root"
+
 // Структура строки данных из XML
 type row struct {
 	ID        int    `xml:"id"`
 	FirstName string `xml:"first_name"`
 	LastName  string `xml:"last_name"`
 	Age       int    `xml:"age"`
-	About     string `xml:"about"`
-	Gender    string `xml:"gender"`
+	// Варианты About на разных языках, см. about
+	Abouts  []aboutText `xml:"about"`
+	Gender  string      `xml:"gender"`
+	City    string      `xml:"city"`
+	Country string      `xml:"country"`
+	Handle  string      `xml:"handle"`
+	Email   string      `xml:"email"`
+	// Телефон в произвольном формате: "(555) 123-4567", "+1 555 1234567"
+	Phone string `xml:"phone"`
+	// День рождения в формате MM-DD, может отсутствовать
+	Birthday string `xml:"birthday"`
+	// Время последнего изменения строки в RFC3339, может отсутствовать
+	UpdatedAt string `xml:"updated_at"`
+	// Активен ли пользователь, nil - элемента нет, см. isActive
+	Active *bool `xml:"active"`
+}
+
+// isActive - пользователь активен. Строки без <active> считаются активными
+func (r row) isActive() bool {
+	return r.Active == nil || *r.Active
+}
+
+// Элемент <about>, язык указывается атрибутом lang и может отсутствовать
+type aboutText struct {
+	Lang string `xml:"lang,attr"`
+	Text string `xml:",chardata"`
+	// Абзацы <p>, если About из них состоит. Text тогда собирается из них
+	Paragraphs []string `xml:"p"`
+}
+
+// Разделитель абзацев About в тексте ответа
+const aboutParagraphSep = "\n\n"
+
+// joinParagraphs собирает Text из абзацев <p>, если они есть
+func (a *aboutText) joinParagraphs() {
+	if len(a.Paragraphs) > 0 {
+		a.Text = strings.Join(a.Paragraphs, aboutParagraphSep)
+	}
+}
+
+// DefaultAboutLang - язык About, если в запросе нет lang
+var DefaultAboutLang = "en"
+
+// about возвращает About на языке lang. Если такого варианта нет, берется
+// вариант на DefaultAboutLang, затем вариант без lang, затем первый
+func (r row) about(lang string) string {
+	return r.aboutVariant(lang).Text
+}
+
+// aboutParagraphs возвращает абзацы About на языке lang, About без <p> - одним абзацем
+func (r row) aboutParagraphs(lang string) []string {
+	about := r.aboutVariant(lang)
+	if len(about.Paragraphs) > 0 {
+		return about.Paragraphs
+	}
+	return []string{about.Text}
+}
+
+// Вариант About на языке lang по правилам about
+func (r row) aboutVariant(lang string) aboutText {
+	if len(r.Abouts) == 0 {
+		return aboutText{}
+	}
+	for _, want := range []string{lang, DefaultAboutLang} {
+		for _, about := range r.Abouts {
+			if want != "" && about.Lang == want {
+				return about
+			}
+		}
+	}
+	for _, about := range r.Abouts {
+		if about.Lang == "" {
+			return about
+		}
+	}
+	return r.Abouts[0]
+}
+
+// Поля, по которым может идти поиск (параметр search_field)
+const (
+	// FirstName, LastName и About
+	searchFieldDefault = ""
+	// Инициалы из FirstName и LastName, например "BW" для "Boyd Wolf".
+	// Сравнение регистронезависимое
+	searchFieldInitials = "initials"
+	// Поля по умолчанию и Gender. Gender сравнивается целиком без учета
+	// регистра, чтобы "male" не находил "female"
+	searchFieldAny = "any"
+	// City и Country
+	searchFieldLocation = "location"
+	// Поля по умолчанию, ID и Age как текст: "21" найдет и возраст 21, и id 21
+	searchFieldAll = "all"
+	// Handle без учета регистра, "@" в начале запроса и поля необязателен
+	searchFieldHandle = "handle"
+	// Только имя "FirstName LastName": слова query ищутся в нем в любом
+	// порядке, "Wolf Boyd" найдет "Boyd Wolf"
+	searchFieldName = "name"
+	// Email без учета регистра и пробелов по краям: query без "@" ищется в
+	// части до "@", с "@" - во всем адресе
+	searchFieldEmail = "email"
+	// Только цифры телефона: "5551234" найдет "(555) 123-4567"
+	searchFieldPhone = "phone"
+)
+
+// Поддерживаемые значения search_field
+var searchFields = []string{searchFieldDefault, searchFieldInitials, searchFieldAny, searchFieldLocation, searchFieldAll, searchFieldHandle, searchFieldName, searchFieldEmail, searchFieldPhone}
+
+// Режимы сравнения query с полями (параметр match_mode)
+const (
+	// Поиск подстроки
+	matchModeContains = ""
+	// Совпадение по звучанию (Soundex) с FirstName или LastName
+	matchModeSoundex = "soundex"
+)
+
+// Поддерживаемые значения match_mode
+var matchModes = []string{matchModeContains, matchModeSoundex}
+
+// Привязка query к началу или концу поля (параметр anchor)
+const (
+	// Подстрока в любом месте поля, как без anchor
+	anchorNone = "none"
+	// Поле начинается с query
+	anchorStart = "start"
+	// Поле заканчивается на query
+	anchorEnd = "end"
+)
+
+// Поддерживаемые значения anchor
+var anchors = []string{"", anchorNone, anchorStart, anchorEnd}
+
+// Gender пустой или не из списка genders, подставляется при чтении данных
+const genderUnknown = "unknown"
+
+// Значения Gender, по которым можно фильтровать
+var genders = []string{"male", "female", genderUnknown}
+
+// normalizeGender приводит пустой или неизвестный Gender к genderUnknown
+func normalizeGender(gender string) string {
+	gender = strings.ToLower(strings.TrimSpace(gender))
+	if !slices.Contains(genders, gender) {
+		return genderUnknown
+	}
+	return gender
 }
 
-// queryDTO содержит параметры запроса
-type queryDTO struct {
-	query      string
-	orderField string
-	orderBy    int
-	offset     int
-	limit      int
+// Допустимые символы order_field и search_field: буквы, "_", запятые для
+// нескольких полей и ":" для их направлений. Остальное отклоняется до разбора значения
+var fieldNamePattern = regexp.MustCompile(`^[A-Za-z_,:]*$`)
+
+// Поддерживаемые значения order_field, должны совпадать с compareFunc
+var orderFields = []string{"", "name", "id", "age", "city", "about_words", "about_len", "about", "gender", "relevance", "email", "priority"}
+
+// Ошибки, которые сервер отдает с кодом 400
+const (
+	// search_field не поддерживается
+	ErrorBadSearchField = `SearchField invalid`
+	// match_mode не поддерживается
+	ErrorBadMatchMode = `MatchMode invalid`
+	// format не поддерживается
+	ErrorBadFormat = `format invalid`
+	// Отрицательный limit
+	ErrorBadLimit = `limit must be >= 0`
+	// query короче MinQueryLen
+	ErrorQueryTooShort = `query too short`
+	// name_format без {first} и {last}
+	ErrorBadNameFormat = `name_format must contain {first} or {last}`
+	// Пустой query при EmptyQueryBehavior = EmptyQueryError
+	ErrorEmptyQuery = `query required`
+	// Неизвестное значение gender
+	ErrorBadGender = `gender invalid`
+	// order_ids не является списком целых чисел через запятую
+	ErrorBadOrderIDs = `order_ids invalid`
+	// query вместе с order_ids при StrictOrderIDs
+	ErrorQueryWithOrderIDs = `query and order_ids are mutually exclusive`
+	// active не true и не false
+	ErrorBadActive = `active invalid`
+	// birth_month не число от 1 до 12
+	ErrorBadBirthMonth = `birth_month invalid`
+	// anchor не поддерживается или задан вместе с match_mode, отличным от поиска подстроки
+	ErrorBadAnchor = `anchor invalid`
+	// since_id не является целым числом
+	ErrorBadSinceID = `since_id invalid`
+	// exclude_ids не список целых чисел через запятую
+	ErrorBadExcludeIDs = `exclude_ids invalid`
+	// Неподдерживаемая версия формата ответа v
+	ErrorBadVersion = `v invalid`
+	// updated_since не в формате RFC3339
+	ErrorBadUpdatedSince = `updated_since invalid`
+	// Параметров больше, чем MaxQueryParams
+	ErrorTooManyParams = `too many query parameters`
+	// max_match_about_len не является неотрицательным целым числом
+	ErrorBadMaxMatchAboutLen = `max_match_about_len invalid`
+	// min_match не является неотрицательным целым числом
+	ErrorBadMinMatch = `min_match invalid`
+	// Запрошена сортировка, когда она отключена через AllowSort
+	ErrorSortDisabled = `sorting is disabled`
+	// offset больше MaxOffset
+	ErrorOffsetTooLarge = `offset too large, use cursor pagination`
+)
+
+// Ограничения на размер запроса
+var (
+	// Максимальное число значений параметров в строке запроса
+	MaxQueryParams = 50
+	// Максимальный размер тела запроса в байтах
+	MaxBodyBytes int64 = 1 << 20
+)
+
+// MinQueryLen - минимальная длина непустого query в символах, чтобы
+// запросы вроде query=a не находили почти всех. 0 - без ограничения,
+// пустой query регулируется EmptyQueryBehavior
+var MinQueryLen = 0
+
+// Что делать с пустым query (EmptyQueryBehavior)
+const (
+	// Все пользователи, как без ограничения
+	EmptyQueryAll = "all"
+	// Пустой список
+	EmptyQueryNone = "none"
+	// Ошибка 400
+	EmptyQueryError = "error"
+)
+
+// EmptyQueryBehavior - ответ на пустой query, чтобы он не отдавал все
+// данные разом. Выборки по order_ids (и /users/batch) не ограничиваются
+var EmptyQueryBehavior = EmptyQueryAll
+
+// StopWords - слова query, которые не ищутся, например {"the", "a"}, без
+// учета регистра. Query только из стоп-слов считается пустым, см.
+// EmptyQueryBehavior. nil - все слова ищутся как есть
+var StopWords []string
+
+// StrictOrderIDs запрещает query вместе с order_ids: такой запрос получает
+// 400. По умолчанию сначала применяется query (и остальные фильтры), затем
+// из найденных остаются пользователи с id из order_ids в их порядке
+var StrictOrderIDs = false
+
+// DefaultLimit - размер страницы, если limit не передан совсем.
+// Явный limit=0 по-прежнему означает "без ограничения"
+var DefaultLimit = 10
+
+// MaxMatchAboutLen - по умолчанию для max_match_about_len: сколько первых
+// символов About участвуют в поиске. Быстрее на длинных About, но
+// совпадения дальше не находятся. 0 - About ищется целиком
+var MaxMatchAboutLen = 0
+
+// EmptyOffsetIs404: offset за пределами найденного отдает 404 с ошибкой
+// вместо пустого списка с 200
+var EmptyOffsetIs404 = false
+
+// MaxLimit ограничивает limit сверху, включая limit=0 ("без ограничения").
+// 0 - без ограничения
+var MaxLimit = 0
+
+// MaxOffset ограничивает offset сверху: глубокие страницы требуют найти и
+// отсортировать все предыдущие. Дальше листают через cursor. 0 - без ограничения
+var MaxOffset = 0
+
+// MinVisibleAge скрывает из поиска пользователей младше этого возраста
+// независимо от параметров запроса. 0 - без ограничения
+var MinVisibleAge = 0
+
+// TokenFields - токены для отдельных клиентов и поля, которые им отдаются,
+// по именам для fields=, например "partner": {"id", "name", "age"}. Такие
+// токены принимаются наравне с AccessToken. Остальные поля не попадают в
+// ответ ни в каком формате, даже если перечислены в fields=
+var TokenFields = map[string][]string{}
+
+// AllowSort разрешает сортировку. Если false, то запросы с order_by,
+// отличным от OrderByAsIs, отклоняются - на больших данных сортировка
+// занимает большую часть времени ответа
+var AllowSort = true
+
+// paramError - ошибка в параметрах запроса, на которую сервер отвечает 400
+type paramError struct {
+	msg string
+}
+
+func (e *paramError) Error() string {
+	return e.msg
+}
+
+// queryDTO содержит параметры запроса
+type queryDTO struct {
+	query       string
+	searchField string
+	matchMode   string
+	orderField  string
+	// Поля order_field=age:desc,name:asc по порядку, nil для одного поля без направления
+	orderKeys []orderKey
+	orderBy   int
+	offset    int
+	limit     int
+	// Слова из query, которые должны найтись, и которые не должны
+	include []string
+	exclude []string
+	// Сравнение без учета регистра, по умолчанию включено
+	caseInsensitive bool
+	// Сравнение без учета регистра и диакритики, см. foldText
+	normalize bool
+	// Привязка query к началу или концу поля
+	anchor string
+	// About сравнивается только целыми словами, см. wordPattern
+	wordBoundary bool
+	wordPatterns map[string]*regexp.Regexp
+	// Позиция из параметра cursor, выдача начинается строго после нее
+	after *User
+	// Позиция из cursor при direction=prev: выдача - страница строго перед ней
+	before *User
+	// order_by=random: перемешивание найденных пользователей с зерном seed
+	random bool
+	seed   int64
+	// tie_seed: перемешивание пользователей с равным ключом сортировки
+	tieSeed *int64
+	// Строковые поля, которые сортируются без учета регистра
+	ciSortFields map[string]bool
+	// Сколько первых символов About участвуют в поиске, 0 - все
+	maxMatchAboutLen int
+	// Сколько слов из include должно совпасть, 0 - все
+	minMatch int
+	// match=name:prefix,about:substring: поля со своими режимами вместо search_field
+	fieldMatches []fieldMatch
+	// explain_plan=1: вместо пользователей отдается план выполнения plan
+	explainPlan bool
+	plan        *queryPlan
+	// Допустимые значения Gender, пустое множество - без фильтра
+	genders map[string]bool
+	// Формат ответа
+	format string
+	// download=1: ответ сохраняется браузером файлом search.<формат>
+	download bool
+	// Вернуть вместе с пользователями итоговые параметры запроса
+	withMeta bool
+	// with_schema=1: вместе с пользователями типы их полей
+	withSchema bool
+	// count_only=1 (fields=count): вместо пользователей только их число,
+	// без сортировки и пагинации
+	countOnly bool
+	// with_counts=1: вместе с пользователями matched и total_scanned
+	withCounts bool
+	// links=1: вместе с пользователями ссылки на страницы links
+	withLinks bool
+	links     *pageLinks
+	// Для with_counts: найдено до пагинации и строк в данных
+	matched, totalRows int
+	// Добавить пользователям AgeBucket
+	ageBuckets bool
+	// age_label=1: добавить пользователям AgeLabel на языке ageLabelLang
+	ageLabel     bool
+	ageLabelLang string
+	// Добавить пользователям Snippet
+	snippet bool
+	// Добавить пользователям MatchOffset
+	offsets bool
+	// Добавить пользователям Slug
+	withSlug bool
+	// Добавить пользователям SortKey
+	withSortKey bool
+	// Пропускать пользователей с пустым About
+	requireAbout bool
+	// collapse_spaces=1: имя сравнивается с query без пробелов, см. containsName
+	collapseSpaces bool
+	// Шаблон Name из name_format
+	nameFormat string
+	// distinct_names=1: из пользователей с одинаковым Name остается один с меньшим ID
+	distinctNames bool
+	// Пустой query при EmptyQueryBehavior = EmptyQueryNone: ничего не найдено
+	matchNothing bool
+	// order_ids: только пользователи с этими id строго в этом порядке
+	orderIDs []int
+	// Месяц дня рождения 1-12, 0 - без фильтра
+	birthMonth int
+	// Только пользователи с ID больше этого, nil - все
+	sinceID *int
+	// Пользователи с этими ID не попадают в выдачу
+	excludeIDs map[int]bool
+	// no_total=1: клиенту не нужно общее число найденных и признак следующей
+	// страницы, X-Rows-Matched и предупреждения о total не отдаются, а
+	// страница без сортировки ищется только до offset+limit найденных
+	noTotal bool
+	// Исходные параметры запроса, например для ссылок на соседние страницы
+	values url.Values
+	// Ключи JSON полей, которые нужно отдать, nil - все поля
+	projection map[string]bool
+	// Поля, которые разрешено отдавать токену запроса, nil - все. См. TokenFields
+	allowedFields map[string]bool
+	// Отдавать телефон: он не входит в поля по умолчанию и отдается, только
+	// если запрошен в fields= или перечислен в полях токена из TokenFields
+	showPhone bool
+	// Не отдавать поля с нулевыми значениями
+	omitEmptyFields bool
+	// Идентификатор запроса для сообщений в логе, см. requestID
+	requestID string
+	// Только строки, измененные не раньше этого времени, nil - все строки
+	updatedSince *time.Time
+	// active=true|false: только активные или только неактивные, nil - все
+	active *bool
+	// Вклад свежести updated_at в релевантность и момент, от которого она считается
+	freshnessBoost float64
+	now            time.Time
+	// Релевантность найденных строк по id для order_field=relevance
+	relevance map[int]float64
+	// Вес пользователей по id из priority_ids для order_field=priority
+	priority map[int]float64
+	// JSON с отступами для чтения человеком
+	pretty bool
+	// Язык About для поиска и ответа, пусто - DefaultAboutLang
+	lang string
+	// Вернуть хеш всей выборки вместо пользователей
+	digest bool
+	// stats=<поле>: вместо пользователей статистика по числовому полю
+	stats string
+	// group_by=<поле>: пользователи объектом с группами по значению поля,
+	// offset и perGroupLimit применяются к каждой группе
+	groupBy       string
+	perGroupLimit int
+	// Отдать пользователей объектом с ключами-id вместо массива
+	asMap bool
+	// unwrap_single=1: один пользователь объектом вместо массива
+	unwrapSingle bool
+	// Версия формата ответа: 1 - массив, 2 - объект с version и data
+	version int
+	// Некритичные странности запроса, которые сервер исправил сам
+	warnings []string
+	// Правила сравнения имен по Accept-Language, nil - побайтовое сравнение
+	collator *collator
+}
+
+// needsFullScan - ответ строится по всем найденным пользователям, а не по
+// первым offset+limit: сортировка, перемешивание, order_ids, limit=0,
+// страница перед курсором и режимы, которые считают или обходят всю выборку. Новый такой режим нужно
+// добавить сюда, иначе без сортировки он получит только часть выборки
+func (q *queryDTO) needsFullScan() bool {
+	return q.orderBy != OrderByAsIs || q.random || len(q.orderIDs) > 0 || q.limit == 0 || q.before != nil ||
+		q.digest || q.stats != "" || q.groupBy != "" || q.countOnly || q.distinctNames ||
+		q.withCounts || q.withLinks
+}
+
+func (q *queryDTO) parseParams(r *http.Request) error {
+	var (
+		queryValues = r.URL.Query()
+		err         error
+	)
+
+	paramsCount := 0
+	for _, values := range queryValues {
+		paramsCount += len(values)
+	}
+	if paramsCount > MaxQueryParams {
+		return &paramError{ErrorTooManyParams}
+	}
+	q.resolveAliases(queryValues)
+	q.values = queryValues
+	q.requestID = requestID(r.Context())
+
+	q.query = queryValues.Get("query")
+	if queryLen := utf8.RuneCountInString(strings.TrimSpace(q.query)); queryLen > 0 && queryLen < MinQueryLen {
+		return &paramError{ErrorQueryTooShort}
+	}
+	// Поиск без учета регистра по умолчанию, case_insensitive=0 - с учетом
+	q.caseInsensitive = true
+	if value, err := strconv.ParseBool(queryValues.Get("case_insensitive")); err == nil {
+		q.caseInsensitive = value
+	}
+	q.normalize = flagParam(queryValues.Get("normalize"))
+	if q.normalize {
+		q.include, q.exclude = parseQueryTerms(foldText(q.query))
+	} else if q.caseInsensitive {
+		q.include, q.exclude = parseQueryTerms(strings.ToLower(q.query))
+	} else {
+		q.include, q.exclude = parseQueryTerms(q.query)
+	}
+	if len(StopWords) > 0 {
+		q.include = removeStopWords(q.include)
+		if len(q.include) == 0 && len(q.exclude) == 0 {
+			q.query = ""
+		}
+	}
+	if value := queryValues.Get("min_match"); value != "" {
+		q.minMatch, err = strconv.Atoi(value)
+		if err != nil || q.minMatch < 0 {
+			return &paramError{ErrorBadMinMatch}
+		}
+		q.minMatch = min(q.minMatch, len(q.include))
+	}
+	q.wordBoundary = flagParam(queryValues.Get("word_boundary"))
+	if q.wordBoundary {
+		q.wordPatterns = map[string]*regexp.Regexp{}
+		for _, term := range slices.Concat(q.include, q.exclude) {
+			q.wordPatterns[term] = wordPattern(term, q.caseInsensitive)
+		}
+	}
+	// Имена полей сравниваются без учета регистра и пробелов по краям:
+	// order_field=" Name " - то же, что order_field=name
+	q.orderField = normalizeFieldName(queryValues.Get("order_field"))
+	if !fieldNamePattern.MatchString(q.orderField) {
+		return &paramError{ErrorBadOrderField}
+	}
+	q.orderKeys, err = parseOrderKeys(q.orderField)
+	if err != nil {
+		return err
+	}
+	if len(q.orderKeys) > 0 {
+		// Остальной код (курсор, with_sort_key, meta) смотрит на первое поле
+		q.orderField = q.orderKeys[0].field
+	}
+	q.collator = collatorFor(r.Header.Get("Accept-Language"))
+
+	q.searchField = normalizeFieldName(queryValues.Get("search_field"))
+	if !fieldNamePattern.MatchString(q.searchField) || !slices.Contains(searchFields, q.searchField) {
+		return &paramError{ErrorBadSearchField}
+	}
+
+	q.matchMode = queryValues.Get("match_mode")
+	if !slices.Contains(matchModes, q.matchMode) {
+		return &paramError{ErrorBadMatchMode}
+	}
+
+	q.fieldMatches, err = parseFieldMatches(queryValues.Get("match"))
+	if err != nil {
+		return err
+	}
+	if len(q.fieldMatches) > 0 && (q.matchMode != matchModeContains || q.searchField != searchFieldDefault) {
+		return &paramError{ErrorBadFieldMatch}
+	}
+
+	q.anchor = queryValues.Get("anchor")
+	if !slices.Contains(anchors, q.anchor) {
+		return &paramError{ErrorBadAnchor}
+	}
+	// Привязка имеет смысл только для поиска подстроки
+	if q.matchMode != matchModeContains && q.anchor != anchorNone && q.anchor != "" {
+		return &paramError{ErrorBadAnchor}
+	}
+
+	q.format, err = negotiateFormat(queryValues.Get("format"), r.Header.Get("Accept"))
+	if err != nil {
+		return err
+	}
+
+	if queryValues.Get("order_by") == orderByRandom {
+		q.random = true
+		q.seed, err = parseSeed(queryValues.Get("seed"))
+		if err != nil {
+			return err
+		}
+	} else {
+		q.orderBy, err = atoiParam(queryValues.Get("order_by"))
+		if err != nil {
+			q.orderBy = 0
+		}
+	}
+
+	if tieSeed := queryValues.Get("tie_seed"); tieSeed != "" {
+		seed, err := strconv.ParseInt(tieSeed, 10, 64)
+		if err != nil {
+			return &paramError{ErrorBadTieSeed}
+		}
+		q.tieSeed = &seed
+	}
+
+	q.offset, err = atoiParam(queryValues.Get("offset"))
+	if err != nil {
+		q.offset = 0
+	}
+	if MaxOffset > 0 && q.offset > MaxOffset {
+		return &paramError{ErrorOffsetTooLarge}
+	}
+
+	// strict_fields=0: неизвестный order_field заменяется сортировкой по
+	// умолчанию вместо ошибки, для клиентов, которые шлют поля новых версий
+	if strict, err := strconv.ParseBool(queryValues.Get("strict_fields")); err == nil && !strict &&
+		!q.knownOrderFields() {
+		q.warn("unknown order_field " + queryValues.Get("order_field") + ", using default order")
+		q.orderField, q.orderKeys = "", nil
+	}
+
+	if err = parsePriorityIDs(q); err != nil {
+		return err
+	}
+
+	// since_id: только ID > since_id по возрастанию ID для простой
+	// инкрементальной выборки. Строки в этом порядке отдает индекс id, см.
+	// rowsSinceID, так что сортировка из запроса отбрасывается, а не
+	// заменяется сортировкой по id: since_id работает и без AllowSort
+	if sinceID := queryValues.Get("since_id"); sinceID != "" {
+		id, err := strconv.Atoi(sinceID)
+		if err != nil {
+			return &paramError{ErrorBadSinceID}
+		}
+		q.sinceID = &id
+		if q.orderField != "" && q.orderField != "id" || q.orderBy == OrderByDesc || q.random {
+			q.warn("since_id sorts by id ascending")
+		}
+		q.orderField, q.orderKeys, q.orderBy, q.random = "", nil, OrderByAsIs, false
+	}
+
+	if excludeIDs := queryValues.Get("exclude_ids"); excludeIDs != "" {
+		q.excludeIDs = map[int]bool{}
+		for _, item := range strings.Split(excludeIDs, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(item))
+			if err != nil {
+				return &paramError{ErrorBadExcludeIDs}
+			}
+			q.excludeIDs[id] = true
+		}
+	}
+
+	// Поля с направлениями сортируются и без order_by
+	if len(q.orderKeys) > 0 && q.orderBy == OrderByAsIs {
+		q.orderBy = OrderByAsc
+	}
+
+	if q.orderBy != OrderByAsIs && q.orderField == "" {
+		q.warn("order_by without order_field, sorting by name")
+		q.orderField = "name"
+	}
+
+	if queryValues.Get("limit") == "" {
+		q.limit = serverConfig(r).DefaultLimit
+	} else {
+		q.limit, err = atoiParam(queryValues.Get("limit"))
+		if err != nil {
+			q.limit = 0
+		}
+	}
+	// Ошибка разбора чисел возвращается в конце, после разбора остальных параметров
+	numErr := err
+
+	if q.limit < 0 {
+		return &paramError{ErrorBadLimit}
+	}
+	if maxLimit := serverConfig(r).MaxLimit; numErr == nil && maxLimit > 0 && (q.limit == 0 || q.limit > maxLimit) {
+		q.warn("limit clamped to " + strconv.Itoa(maxLimit))
+		q.limit = maxLimit
+	}
+
+	q.withMeta = flagParam(queryValues.Get("with_meta"))
+	q.download = flagParam(queryValues.Get("download"))
+	q.withCounts = flagParam(queryValues.Get("with_counts"))
+	q.withSchema = flagParam(queryValues.Get("with_schema"))
+	q.withLinks = flagParam(queryValues.Get("links"))
+	q.ageBuckets = flagParam(queryValues.Get("age_buckets"))
+	q.ageLabel = flagParam(queryValues.Get("age_label"))
+	q.snippet = flagParam(queryValues.Get("snippet"))
+	q.offsets = flagParam(queryValues.Get("offsets"))
+	q.withSlug = flagParam(queryValues.Get("with_slug"))
+	q.withSortKey = flagParam(queryValues.Get("with_sort_key"))
+	q.explainPlan = flagParam(queryValues.Get("explain_plan"))
+	q.distinctNames = flagParam(queryValues.Get("distinct_names"))
+	q.collapseSpaces = flagParam(queryValues.Get("collapse_spaces"))
+
+	q.nameFormat = DefaultNameFormat
+	if nameFormat := queryValues.Get("name_format"); nameFormat != "" {
+		if !strings.Contains(nameFormat, "{first}") && !strings.Contains(nameFormat, "{last}") {
+			return &paramError{ErrorBadNameFormat}
+		}
+		q.nameFormat = nameFormat
+	}
+
+	q.ciSortFields, err = parseCISortFields(queryValues)
+	if err != nil {
+		return err
+	}
+
+	q.maxMatchAboutLen = MaxMatchAboutLen
+	if value := queryValues.Get("max_match_about_len"); value != "" {
+		q.maxMatchAboutLen, err = strconv.Atoi(value)
+		if err != nil || q.maxMatchAboutLen < 0 {
+			return &paramError{ErrorBadMaxMatchAboutLen}
+		}
+	}
+	q.requireAbout = flagParam(queryValues.Get("require_about"))
+	q.noTotal = flagParam(queryValues.Get("no_total"))
+
+	q.orderIDs, err = parseOrderIDs(queryValues.Get("order_ids"))
+	if err != nil {
+		return err
+	}
+	if StrictOrderIDs && len(q.orderIDs) > 0 && strings.TrimSpace(q.query) != "" {
+		return &paramError{ErrorQueryWithOrderIDs}
+	}
+	if strings.TrimSpace(q.query) == "" && len(q.orderIDs) == 0 {
+		switch EmptyQueryBehavior {
+		case EmptyQueryNone:
+			q.matchNothing = true
+		case EmptyQueryError:
+			return &paramError{ErrorEmptyQuery}
+		}
+	}
+
+	if birthMonth := queryValues.Get("birth_month"); birthMonth != "" {
+		q.birthMonth, err = strconv.Atoi(birthMonth)
+		if err != nil || q.birthMonth < 1 || q.birthMonth > 12 {
+			return &paramError{ErrorBadBirthMonth}
+		}
+	}
+	q.pretty = flagParam(queryValues.Get("pretty"))
+	q.lang = queryValues.Get("lang")
+	q.ageLabelLang = ageLabelLang(q.lang, r.Header.Get("Accept-Language"))
+	q.digest = flagParam(queryValues.Get("digest"))
+	q.stats = queryValues.Get("stats")
+	if q.stats != "" && !slices.Contains(statsFields, q.stats) {
+		return &paramError{ErrorBadStats}
+	}
+	q.asMap = flagParam(queryValues.Get("as_map"))
+	// Отдается только первый найденный, остальные не нужны
+	q.unwrapSingle = flagParam(queryValues.Get("unwrap_single"))
+	if q.unwrapSingle {
+		q.limit = 1
+	}
+
+	switch queryValues.Get("v") {
+	case "", "1":
+		q.version = 1
+	case "2":
+		q.version = 2
+	default:
+		return &paramError{ErrorBadVersion}
+	}
+
+	// fields=count - псевдоним count_only=1, чтобы клиенты собирали адрес
+	// так же, как для выбора полей
+	fields := queryValues.Get("fields")
+	q.countOnly = flagParam(queryValues.Get("count_only")) || fields == fieldsCount
+	if fields == fieldsCount {
+		fields = ""
+	}
+	q.projection, err = parseFields(fields, queryValues.Get("exclude_fields"))
+	if err != nil {
+		return err
+	}
+	q.allowedFields = serverConfig(r).allowedFields(r.Header.Get("AccessToken"))
+	q.projection = restrictProjection(q.projection, q.allowedFields)
+	q.showPhone = q.projection[userFields["phone"]] && (fields != "" || q.allowedFields != nil)
+	if err = q.checkTokenFields(); err != nil {
+		return err
+	}
+	q.groupBy = queryValues.Get("group_by")
+	if q.groupBy != "" && (!slices.Contains(groupFields, q.groupBy) ||
+		q.allowedFields != nil && !q.allowedFields[userFields[q.groupBy]]) {
+		return &paramError{ErrorBadGroupBy}
+	}
+	q.perGroupLimit = q.limit
+	if value := queryValues.Get("per_group_limit"); value != "" {
+		q.perGroupLimit, err = strconv.Atoi(value)
+		if err != nil || q.perGroupLimit < 0 {
+			return &paramError{ErrorBadPerGroupLimit}
+		}
+	}
+	q.omitEmptyFields = flagParam(queryValues.Get("omitempty_fields"))
+
+	if active := queryValues.Get("active"); active != "" {
+		value, err := strconv.ParseBool(active)
+		if err != nil {
+			return &paramError{ErrorBadActive}
+		}
+		q.active = &value
+	}
+
+	if updatedSince := queryValues.Get("updated_since"); updatedSince != "" {
+		since, err := time.Parse(time.RFC3339, updatedSince)
+		if err != nil {
+			return &paramError{ErrorBadUpdatedSince}
+		}
+		q.updatedSince = &since
+	}
+
+	if err = parseRelevance(q); err != nil {
+		return err
+	}
+
+	q.genders, err = parseGenders(queryValues["gender"])
+	if err != nil {
+		return err
+	}
+
+	if cursor := queryValues.Get("cursor"); cursor != "" {
+		if q.orderBy == OrderByAsIs {
+			return &paramError{ErrorCursorNeedSort}
+		}
+		if len(q.orderKeys) > 1 {
+			// Курсор хранит значение только одного поля
+			return &paramError{ErrorBadCursor}
+		}
+		position, err := decodeCursor(cursor, q.orderField)
+		if err != nil {
+			return err
+		}
+		switch queryValues.Get("direction") {
+		case "", directionNext:
+			q.after = position
+		case directionPrev:
+			q.before = position
+		default:
+			return &paramError{ErrorBadDirection}
+		}
+	} else if direction := queryValues.Get("direction"); direction != "" && direction != directionNext {
+		return &paramError{ErrorBadDirection}
+	}
+
+	keyset, err := parseKeyset(queryValues.Get("after_age"), queryValues.Get("after_id"), q)
+	if err != nil {
+		return err
+	}
+	if keyset != nil {
+		q.after = keyset
+	}
+
+	return numErr
+}
+
+// warn добавляет предупреждение для заголовка X-Search-Warnings
+func (q *queryDTO) warn(msg string) {
+	q.warnings = append(q.warnings, msg)
+}
+
+// setSearchModeHeaders сообщает, как на самом деле сравнивался query
+// после подстановки значений по умолчанию: X-Search-Mode (contains или
+// soundex), X-Search-Field (default, если не задан) и X-Search-Folding
+// (none, case или accent - регистр и диакритика)
+func setSearchModeHeaders(w http.ResponseWriter, params *queryDTO) {
+	mode := params.matchMode
+	if mode == matchModeContains {
+		mode = "contains"
+	}
+	field := params.searchField
+	if field == searchFieldDefault {
+		field = "default"
+	}
+	folding := "none"
+	if params.normalize {
+		folding = "accent"
+	} else if params.caseInsensitive {
+		folding = "case"
+	}
+	w.Header().Set("X-Search-Mode", mode)
+	w.Header().Set("X-Search-Field", field)
+	w.Header().Set("X-Search-Folding", folding)
+}
+
+// Отправка накопленных предупреждений в X-Search-Warnings через "; "
+func setWarnings(w http.ResponseWriter, params *queryDTO) {
+	if len(params.warnings) > 0 {
+		w.Header().Set("X-Search-Warnings", strings.Join(params.warnings, "; "))
+	}
+}
+
+// Разбор фильтра по полу: gender=male,female или несколько параметров gender
+func parseGenders(values []string) (map[string]bool, error) {
+	result := map[string]bool{}
+	for _, value := range values {
+		for _, gender := range strings.Split(value, ",") {
+			gender = strings.TrimSpace(gender)
+			if !slices.Contains(genders, gender) {
+				return nil, &paramError{ErrorBadGender}
+			}
+			result[gender] = true
+		}
+	}
+	return result, nil
+}
+
+// normalizeFieldName приводит имя поля из параметра к нижнему регистру и
+// убирает пробелы по краям, в том числе у полей списка через запятую
+func normalizeFieldName(value string) string {
+	items := strings.Split(strings.ToLower(value), ",")
+	for idx, item := range items {
+		items[idx] = strings.TrimSpace(item)
+	}
+	return strings.Join(items, ",")
+}
+
+// Разбор флага вида with_meta=1, все кроме true-значений ParseBool - false
+func flagParam(value string) bool {
+	flag, _ := strconv.ParseBool(value) //nolint:errcheck
+	return flag
+}
+
+// Разбор целочисленного параметра, отсутствующий параметр равен 0
+func atoiParam(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// Разбор query на слова, разделенные пробелами. Текст в двойных кавычках
+// считается одной фразой и ищется целиком, незакрытая кавычка длится до
+// конца запроса. Строка должна содержать все слова и фразы (AND), а слова
+// и фразы с префиксом "-" наоборот не должны в ней встречаться.
+// Одиночный "-" и пустые кавычки игнорируются
+func parseQueryTerms(query string) (include, exclude []string) {
+	for _, term := range tokenizeQuery(query) {
+		if negated, ok := strings.CutPrefix(term, "-"); ok {
+			if negated != "" {
+				exclude = append(exclude, negated)
+			}
+			continue
+		}
+		include = append(include, term)
+	}
+	return include, exclude
+}
+
+// removeStopWords убирает из terms слова из StopWords
+func removeStopWords(terms []string) []string {
+	return slices.DeleteFunc(terms, func(term string) bool {
+		return slices.ContainsFunc(StopWords, func(word string) bool {
+			return strings.EqualFold(term, word)
+		})
+	})
+}
+
+// Разбиение query на слова и фразы в кавычках, кавычки в результат не попадают
+func tokenizeQuery(query string) []string {
+	var (
+		tokens  []string
+		current strings.Builder
+		quoted  bool
+	)
+
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+		}
+		current.Reset()
+	}
+
+	for _, r := range query {
+		switch {
+		case r == '"':
+			if quoted {
+				flush()
+			}
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// Проверка строки на соответствие всем словам запроса. С min_match
+// достаточно совпадения minMatch слов из include
+func isQueryMatching(row row, params *queryDTO) bool {
+	for _, term := range params.exclude {
+		if isRowMatching(row, term, params) {
+			return false
+		}
+	}
+	if params.minMatch > 0 {
+		matched := 0
+		for idx, term := range params.include {
+			if isRowMatching(row, term, params) {
+				matched++
+			}
+			if matched >= params.minMatch {
+				return true
+			}
+			// Оставшихся слов уже не хватит
+			if matched+len(params.include)-idx-1 < params.minMatch {
+				return false
+			}
+		}
+		return false
+	}
+	for _, term := range params.include {
+		if !isRowMatching(row, term, params) {
+			return false
+		}
+	}
+	return true
+}
+
+func isRowMatching(row row, query string, params *queryDTO) bool {
+	if len(params.fieldMatches) > 0 {
+		return params.matchFields(row, query)
+	}
+	if params.matchMode == matchModeSoundex {
+		return soundexMatch(query, row.FirstName, row.LastName)
+	}
+
+	switch params.searchField {
+	case searchFieldInitials:
+		return params.anchored(initials(row), strings.ToUpper(query))
+	case searchFieldLocation:
+		return params.contains(row.City, query) ||
+			params.contains(row.Country, query)
+	case searchFieldHandle:
+		return row.Handle != "" && params.anchored(normalizeHandle(row.Handle), normalizeHandle(query))
+	case searchFieldName:
+		return params.containsName(row, query)
+	case searchFieldEmail:
+		email, query := normalizeEmail(row.Email), normalizeEmail(query)
+		if !strings.Contains(query, "@") {
+			email, _, _ = strings.Cut(email, "@")
+		}
+		return email != "" && params.anchored(email, query)
+	case searchFieldPhone:
+		phone, query := phoneDigits(row.Phone), phoneDigits(query)
+		return phone != "" && query != "" && params.anchored(phone, query)
+	}
+
+	if params.searchField == searchFieldAny && strings.EqualFold(row.Gender, query) {
+		return true
+	}
+
+	if params.searchField == searchFieldAll &&
+		(params.anchored(strconv.Itoa(row.ID), query) || params.anchored(strconv.Itoa(row.Age), query)) {
+		return true
+	}
+
+	// Полное имя нужно для фраз вроде "Boyd Wolf"
+	return params.contains(row.FirstName, query) ||
+		params.contains(row.LastName, query) ||
+		params.containsName(row, query) ||
+		params.matchAbout(row.aboutParagraphs(params.lang), query)
+}
+
+// containsName ищет query в полном имени "FirstName LastName". С
+// collapse_spaces=1 пробельные символы убираются из имени и query, и
+// "BoydWolf" находит "Boyd Wolf". About это не затрагивает
+func (q *queryDTO) containsName(row row, query string) bool {
+	name := row.FirstName + " " + row.LastName
+	if q.collapseSpaces {
+		return q.contains(removeSpaces(name), removeSpaces(query))
+	}
+	return q.contains(name, query)
+}
+
+// removeSpaces убирает из s все пробельные символы
+func removeSpaces(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+// matchAbout ищет query в каждом абзаце About отдельно, чтобы фраза не
+// совпадала на стыке абзацев. max_match_about_len отсчитывается от начала
+// всего About
+func (q *queryDTO) matchAbout(paragraphs []string, query string) bool {
+	budget := q.maxMatchAboutLen
+	for _, paragraph := range paragraphs {
+		if q.maxMatchAboutLen > 0 {
+			if budget <= 0 {
+				return false
+			}
+			paragraph = truncateRunes(paragraph, budget)
+			budget -= utf8.RuneCountInString(paragraph) + utf8.RuneCountInString(aboutParagraphSep)
+		}
+		if q.containsAbout(paragraph, query) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateRunes оставляет первые n символов s, n <= 0 - без ограничения
+func truncateRunes(s string, n int) string {
+	if n <= 0 || len(s) <= n {
+		return s
+	}
+	count := 0
+	for idx := range s {
+		if count == n {
+			return s[:idx]
+		}
+		count++
+	}
+	return s
+}
+
+// containsAbout ищет query в About, с word_boundary=1 - только целым словом
+func (q *queryDTO) containsAbout(about, query string) bool {
+	if !q.wordBoundary {
+		return q.contains(about, query)
+	}
+	pattern, ok := q.wordPatterns[query]
+	if !ok {
+		pattern = wordPattern(query, q.caseInsensitive)
+	}
+	if q.normalize {
+		about = foldText(about)
+	}
+	return pattern.MatchString(about)
+}
+
+// wordPattern находит query целым словом: соседние буквы, цифры и "_" не
+// допускаются, пробелы и знаки препинания допускаются. В отличие от \b
+// работает и для не-ASCII букв, и для query, оканчивающегося знаком препинания
+func wordPattern(query string, caseInsensitive bool) *regexp.Regexp {
+	pattern := `(?:^|[^\pL\pN_])` + regexp.QuoteMeta(query) + `(?:$|[^\pL\pN_])`
+	if caseInsensitive {
+		pattern = `(?i)` + pattern
+	}
+	return regexp.MustCompile(pattern)
+}
+
+// normalizeHandle убирает "@" в начале и приводит к нижнему регистру
+func normalizeHandle(handle string) string {
+	return strings.ToLower(strings.TrimPrefix(handle, "@"))
+}
+
+// normalizeEmail убирает пробелы по краям и приводит к нижнему регистру
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// phoneDigits оставляет в телефоне только цифры
+func phoneDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// contains ищет query в поле с учетом case_insensitive и normalize.
+// В этих режимах слова запроса уже приведены к тому же виду в parseParams
+func (q *queryDTO) contains(field, query string) bool {
+	return q.containsAnchored(field, query, q.anchor)
+}
+
+// containsAnchored - contains с привязкой anchor вместо параметра запроса
+func (q *queryDTO) containsAnchored(field, query, anchor string) bool {
+	if q.normalize {
+		field = foldText(field)
+	} else if q.caseInsensitive {
+		field = strings.ToLower(field)
+	}
+	return anchoredBy(anchor, field, query)
+}
+
+// anchored ищет query в начале, в конце или в любом месте поля по anchor
+func (q *queryDTO) anchored(field, query string) bool {
+	return anchoredBy(q.anchor, field, query)
+}
+
+func anchoredBy(anchor, field, query string) bool {
+	switch anchor {
+	case anchorStart:
+		return strings.HasPrefix(field, query)
+	case anchorEnd:
+		return strings.HasSuffix(field, query)
+	case anchorExact:
+		return field == query
+	}
+	return strings.Contains(field, query)
+}
+
+// Инициалы в верхнем регистре, составленные из FirstName и LastName
+func initials(row row) string {
+	var result strings.Builder
+	for _, name := range []string{row.FirstName, row.LastName} {
+		for _, r := range name {
+			result.WriteRune(unicode.ToUpper(r))
+			break
+		}
+	}
+	return result.String()
+}
+
+// Имя xml-файла с данными
+var fileName = "dataset.xml"
+
+// Фильтрация данных по заданному query.
+// Порядок строк сохраняется, на этом основан OrderByAsIs.
+// stopAfter > 0 прекращает просмотр, как только найдено столько строк.
+// Возвращает найденных пользователей и число просмотренных строк.
+// Результат никогда не nil, чтобы пустой ответ кодировался как [], а не null
+func filterData(ctx context.Context, data xmlData, params *queryDTO, stopAfter int) ([]User, int, error) {
+	result := make([]User, 0)
+	if params.matchNothing {
+		return result, len(data.Rows), nil
+	}
+
+	for idx, row := range data.Rows {
+		if stopAfter > 0 && len(result) == stopAfter {
+			return result, idx, nil
+		}
+		if idx%stageCheckEvery == 0 {
+			if err := stageErr(ctx, stageFilter); err != nil {
+				return nil, idx, err
+			}
+		}
+
+		if user, ok := matchRow(row, params); ok {
+			result = append(result, user)
+		}
+	}
+	return result, len(data.Rows), nil
+}
+
+// DefaultNameFormat - шаблон Name, если в запросе нет name_format.
+// {first} и {last} заменяются на FirstName и LastName, остальное - как есть
+var DefaultNameFormat = "{first} {last}"
+
+// formatName собирает Name по шаблону name_format. Поиск по имени от
+// шаблона не зависит, сортировка по name идет по готовому Name
+func formatName(format, first, last string) string {
+	if format == "{first} {last}" {
+		return first + " " + last
+	}
+	return strings.NewReplacer("{first}", first, "{last}", last).Replace(format)
+}
+
+// distinctNames оставляет по одному пользователю на каждое Name - с
+// наименьшим ID. Оставленный пользователь стоит на месте первого с этим Name
+func distinctNames(users []User) []User {
+	byName := make(map[string]int, len(users))
+	result := users[:0:0]
+	for _, user := range users {
+		idx, ok := byName[user.Name]
+		if !ok {
+			byName[user.Name] = len(result)
+			result = append(result, user)
+			continue
+		}
+		if user.ID < result[idx].ID {
+			result[idx] = user
+		}
+	}
+	return result
+}
+
+// matchRow проверяет строку фильтрами запроса и MinVisibleAge и возвращает
+// пользователя для ответа
+func matchRow(row row, params *queryDTO) (User, bool) {
+	if len(params.genders) > 0 && !params.genders[row.Gender] {
+		return User{}, false
+	}
+
+	if params.sinceID != nil && row.ID <= *params.sinceID {
+		return User{}, false
+	}
+
+	if params.excludeIDs[row.ID] {
+		return User{}, false
+	}
+
+	if params.updatedSince != nil && !isUpdatedSince(row, *params.updatedSince) {
+		return User{}, false
+	}
+
+	if params.active != nil && row.isActive() != *params.active {
+		return User{}, false
+	}
+
+	if params.requireAbout && strings.TrimSpace(row.about(params.lang)) == "" {
+		return User{}, false
+	}
+
+	if params.birthMonth != 0 && birthMonth(row.Birthday) != params.birthMonth {
+		return User{}, false
+	}
+
+	if params.query != "" {
+		// Проверка соответствия запросу в полях FirstName, LastName и About
+		if !isQueryMatching(row, params) {
+			return User{}, false
+		}
+	}
+
+	if row.Age < MinVisibleAge {
+		return User{}, false
+	}
+
+	if params.relevance != nil {
+		params.relevance[row.ID] = relevanceScore(row, params)
+	}
+
+	return User{
+		ID:      row.ID,
+		Name:    formatName(params.nameFormat, row.FirstName, row.LastName),
+		Age:     row.Age,
+		About:   row.about(params.lang),
+		Gender:  row.Gender,
+		City:    row.City,
+		Country: row.Country,
+		Handle:  row.Handle,
+		Email:   row.Email,
+		Phone:   params.phone(row),
+	}, true
 }
 
-func (q *queryDTO) parseParams(r *http.Request) error {
-	var (
-		queryValues = r.URL.Query()
-		err         error
-	)
+// phone возвращает телефон строки, если он отдается в ответе, см. showPhone
+func (q *queryDTO) phone(row row) string {
+	if !q.showPhone {
+		return ""
+	}
+	return row.Phone
+}
 
-	q.query = queryValues.Get("query")
-	q.orderField = queryValues.Get("order_field")
+// filterStopAfter - сколько строк достаточно найти для ответа. Без сортировки
+// страница состоит из первых offset+limit найденных, остальные строки можно
+// не просматривать, но только с no_total=1: иначе нужен X-Rows-Matched.
+// Если ответу нужна вся выборка, см. needsFullScan, то 0
+func filterStopAfter(params *queryDTO) int {
+	if !params.noTotal || params.needsFullScan() {
+		return 0
+	}
+	return params.offset + params.limit
+}
 
-	q.orderBy, err = strconv.Atoi(queryValues.Get("order_by"))
+// birthMonth возвращает месяц из дня рождения MM-DD, 0 - если его нет или формат неверный
+func birthMonth(birthday string) int {
+	date, err := time.Parse("01-02", birthday)
 	if err != nil {
-		q.orderBy = 0
+		return 0
 	}
+	return int(date.Month())
+}
 
-	q.offset, err = strconv.Atoi(queryValues.Get("offset"))
+// Строка изменена не раньше since. Строки без updated_at не подходят
+func isUpdatedSince(row row, since time.Time) bool {
+	updatedAt, err := time.Parse(time.RFC3339, row.UpdatedAt)
 	if err != nil {
-		q.offset = 0
+		return false
 	}
+	return !updatedAt.Before(since)
+}
 
-	q.limit, err = strconv.Atoi(queryValues.Get("limit"))
-	if err != nil {
-		q.limit = 0
+// Сравнение пользователей по полю сортировки: меньше нуля, ноль или больше нуля
+func compareFunc(orderField string, params *queryDTO) (func(a, b User) int, error) {
+	switch orderField {
+	case "", "name":
+		if params.collator != nil {
+			collator, fold := params.collator, params.sortFold("name")
+			return func(a, b User) int { return collator.compare(fold(a.Name), fold(b.Name)) }, nil
+		}
+		fold := params.sortFold("name")
+		return func(a, b User) int { return strings.Compare(fold(a.Name), fold(b.Name)) }, nil
+	case "id":
+		return func(a, b User) int { return cmp.Compare(a.ID, b.ID) }, nil
+	case "email":
+		return func(a, b User) int { return strings.Compare(normalizeEmail(a.Email), normalizeEmail(b.Email)) }, nil
+	case "relevance":
+		// По возрастанию - от самых релевантных
+		return func(a, b User) int { return cmp.Compare(params.relevance[b.ID], params.relevance[a.ID]) }, nil
+	case "priority":
+		// По возрастанию - от большего веса
+		return func(a, b User) int { return cmp.Compare(params.priority[b.ID], params.priority[a.ID]) }, nil
+	case "age":
+		return func(a, b User) int { return cmp.Compare(a.Age, b.Age) }, nil
+	case "city":
+		fold := params.sortFold("city")
+		return func(a, b User) int { return strings.Compare(fold(a.City), fold(b.City)) }, nil
+	case "about":
+		return func(a, b User) int { return strings.Compare(a.About, b.About) }, nil
+	case "gender":
+		return func(a, b User) int { return strings.Compare(a.Gender, b.Gender) }, nil
+	case "about_words", "about_len":
+		return func(a, b User) int { return cmp.Compare(aboutMetric(a, orderField), aboutMetric(b, orderField)) }, nil
 	}
+	return nil, errors.New("OrderField invalid")
+}
 
-	return err
+// Ссылка на поле пользователя, по которому идет сортировка
+func sortKeyRef(user *User, orderField string) interface{} {
+	switch orderField {
+	case "", "name":
+		return &user.Name
+	case "age":
+		return &user.Age
+	case "city":
+		return &user.City
+	case "about", "about_words", "about_len":
+		return &user.About
+	case "gender":
+		return &user.Gender
+	case "email":
+		return &user.Email
+	}
+	return &user.ID
 }
 
-func isRowMatching(row row, query string) bool {
-	return strings.Contains(row.FirstName, query) ||
-		strings.Contains(row.LastName, query) ||
-		strings.Contains(row.About, query)
+// Вычисляемые по About значения: число слов для about_words и
+// число символов для about_len
+func aboutMetric(user User, field string) int {
+	if field == "about_words" {
+		return len(strings.Fields(user.About))
+	}
+	return utf8.RuneCountInString(user.About)
 }
 
-// Имя xml-файла с данными
-var fileName = "dataset.xml"
+// userSortKey - значение для with_sort_key: у relevance и priority это
+// оценка и вес пользователя из запроса, у остальных полей см. sortKey
+func userSortKey(user User, params *queryDTO) interface{} {
+	switch params.orderField {
+	case "relevance":
+		return params.relevance[user.ID]
+	case "priority":
+		return params.priority[user.ID]
+	}
+	return sortKey(user, params.orderField)
+}
 
-// Фильтрация данных по заданному query
-func filterData(data xmlData, query string) []User {
-	result := make([]User, 0)
+// Значение, по которому пользователь сортируется по orderField
+func sortKey(user User, orderField string) interface{} {
+	switch orderField {
+	case "about_words", "about_len":
+		return aboutMetric(user, orderField)
+	}
+	switch ref := sortKeyRef(&user, orderField).(type) {
+	case *string:
+		return *ref
+	case *int:
+		return *ref
+	}
+	return nil
+}
 
-	for _, row := range data.Rows {
-		if query != "" {
-			// Проверка соответствия запросу в полях FirstName, LastName и About
-			if !isRowMatching(row, query) {
-				continue
-			}
+// Порядок пользователей для orderField (или orderKeys) и orderBy. При равенстве полей
+// пользователи упорядочиваются по возрастанию ID при любом orderBy,
+// так что порядок всегда однозначен
+func userLess(params *queryDTO) (func(a, b User) bool, error) {
+	compare, err := orderCompare(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(a, b User) bool {
+		result := compare(a, b)
+		if result == 0 {
+			result = cmp.Compare(a.ID, b.ID)
 		}
+		return result < 0
+	}, nil
+}
 
-		// Добавление соответствующих данных в результат
-		result = append(result, User{
-			ID:     row.ID,
-			Name:   row.FirstName + " " + row.LastName,
-			Age:    row.Age,
-			About:  row.About,
-			Gender: row.Gender,
-		})
+// parseOrderIDs разбирает список id через запятую для order_ids
+func parseOrderIDs(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var result []int
+	for _, item := range strings.Split(value, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil {
+			return nil, &paramError{ErrorBadOrderIDs}
+		}
+		result = append(result, id)
+	}
+	return result, nil
+}
+
+// usersInIDOrder оставляет пользователей с id из ids в порядке ids.
+// Отсутствующие id пропускаются, повторы id учитываются один раз
+func usersInIDOrder(data []User, ids []int) []User {
+	byID := make(map[int]User, len(data))
+	for _, user := range data {
+		byID[user.ID] = user
+	}
+
+	result := make([]User, 0, len(ids))
+	for _, id := range ids {
+		if user, ok := byID[id]; ok {
+			result = append(result, user)
+			delete(byID, id)
+		}
 	}
 	return result
 }
 
 // Сортировка данных в соответствии с orderField и orderBy
-func sortData(data []User, orderField string, orderBy int) ([]User, error) {
-	var isLess func(i, j int) bool
+func sortData(ctx context.Context, data []User, params *queryDTO) ([]User, error) {
+	isLess, err := userLess(params)
+	if err != nil {
+		return nil, err
+	}
+	if err = stageErr(ctx, stageSort); err != nil {
+		return nil, err
+	}
 
-	switch orderField {
-	case "":
-		fallthrough
-	case "name":
-		isLess = func(i, j int) bool {
-			return (data[i].Name < data[j].Name) && (orderBy == OrderByAsc)
-		}
-	case "id":
-		isLess = func(i, j int) bool {
-			return (data[i].ID < data[j].ID) && (orderBy == OrderByAsc)
-		}
-	case "age":
-		isLess = func(i, j int) bool {
-			return (data[i].Age < data[j].Age) && (orderBy == OrderByAsc)
+	// Сравнения после истечения времени ничего не делают, и сортировка
+	// быстро заканчивается с неопределенным порядком
+	compared, expired := 0, false
+	sort.Slice(data, func(i, j int) bool {
+		if compared++; compared%stageCheckEvery == 0 && ctx.Err() != nil {
+			expired = true
 		}
-	default:
-		return nil, errors.New("OrderField invalid")
+		return !expired && isLess(data[i], data[j])
+	})
+	if expired {
+		return nil, &stageTimeoutError{stageSort}
 	}
-
-	sort.Slice(data, isLess)
 	return data, nil
 }
 
-// Пагинация данных
+// Пагинация данных. limit == 0 означает "без ограничения": отдаются
+// все пользователи после offset
 func paginateData(data []User, offset, limit int) []User {
+	// offset на последней строке и дальше дает пустую страницу
+	if offset >= len(data) && offset > 0 {
+		return []User{}
+	}
 	if offset > 0 {
-		if offset < len(data) {
-			data = data[offset:]
-		} else {
-			data = []User{}
-		}
+		data = data[offset:]
+	}
+
+	// limit ограничивается строками, оставшимися после offset, иначе
+	// срез у конца выборки выходит за границу
+	if limit > 0 {
+		data = data[:min(limit, len(data))]
 	}
 
-	if (limit - 1) > 0 {
-		data = data[:limit]
+	if data == nil {
+		// Пустой ответ всегда [], а не null
+		data = []User{}
 	}
 
 	return data
 }
 
+// ResponseCharset добавляется к Content-Type JSON-ответов.
+// Пустое значение оставляет просто application/json
+var ResponseCharset = "utf-8"
+
+// Content-Type для JSON-ответов
+func jsonContentType() string {
+	if ResponseCharset == "" {
+		return "application/json"
+	}
+	return "application/json; charset=" + ResponseCharset
+}
+
+// ETag ответа - SHA-256 от тела
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// headWriter отбрасывает тело ответа на HEAD-запрос, заголовки остаются как у GET
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// CacheMaxAge - сколько секунд клиент может отдавать сохраненный успешный
+// ответ поиска (Cache-Control: private, max-age). Ответ зависит от AccessToken
+// и согласования формата, поэтому общим кешам и CDN он не разрешается, а
+// Vary перечисляет эти заголовки. 0 - заголовки не ставятся.
+// Ответы с ошибкой всегда получают Cache-Control: no-store
+var CacheMaxAge = 0
+
+// Заголовки запроса, от которых зависит ответ поиска: поля по токену,
+// формат по Accept и сравнение имен по Accept-Language
+const searchVary = "AccessToken, Accept, Accept-Language"
+
+// cacheWriter ставит Cache-Control по статусу ответа: no-store ошибкам,
+// private, max-age по CacheMaxAge и Vary успешным ответам поиска (search)
+type cacheWriter struct {
+	http.ResponseWriter
+	search      bool
+	wroteHeader bool
+	// Отправленный статус для logRequest
+	status int
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+		switch {
+		case status >= http.StatusBadRequest:
+			w.Header().Set("Cache-Control", "no-store")
+		case w.search && CacheMaxAge > 0 && w.Header().Get("Cache-Control") == "" &&
+			(status < http.StatusMultipleChoices || status == http.StatusNotModified):
+			w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(CacheMaxAge))
+			w.Header().Add("Vary", searchVary)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Отправка ошибки в формате SearchErrorResponse
+func sendError(w http.ResponseWriter, status int, msg string) {
+	jsonStr, err := json.Marshal(SearchErrorResponse{Error: msg})
+	if err != nil {
+		http.Error(w, "cant marshal json", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", jsonContentType())
+	w.WriteHeader(status)
+	_, err = w.Write(jsonStr)
+	if err != nil {
+		http.Error(w, "cant write json", http.StatusInternalServerError)
+	}
+}
+
 // Отправка ответа в формате JSON
 func sendResponse(w http.ResponseWriter, data interface{}) {
-	jsonFile, err := json.Marshal(data)
+	sendJSON(w, data, false)
+}
+
+// sendJSON отправляет data в JSON, при pretty - с отступом в два пробела
+func sendJSON(w http.ResponseWriter, data interface{}, pretty bool) {
+	var jsonFile []byte
+	var err error
+	if pretty {
+		jsonFile, err = json.MarshalIndent(data, "", "  ")
+	} else {
+		jsonFile, err = json.Marshal(data)
+	}
 	if err != nil {
 		http.Error(w, "cant marshal json", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", jsonContentType())
+	w.Header().Set("Content-Length", strconv.Itoa(len(jsonFile)))
+	w.Header().Set("ETag", etag(jsonFile))
 	_, err = w.Write(jsonFile)
 	if err != nil {
 		http.Error(w, "cant write json", http.StatusInternalServerError)
@@ -157,66 +1681,451 @@ func sendResponse(w http.ResponseWriter, data interface{}) {
 	}
 }
 
+// RequireHTTPS отклоняет запросы, пришедшие по HTTP без TLS, с кодом 426.
+// За TLS-терминирующим прокси флаг включать нельзя: r.TLS там всегда nil
+var RequireHTTPS = false
+
+// Дополнительные эндпоинты, запросы на остальные пути считаются поиском
+var routes = map[string]http.HandlerFunc{
+	"/rank":         rankHandler,
+	"/reload":       reloadHandler,
+	"/capabilities": capabilitiesHandler,
+	"/index":        indexHandler,
+	"/version":      versionHandler,
+	"/users/batch":  batchHandler,
+	"/batch":        batchCountHandler,
+	"/diff":         diffHandler,
+	"/histogram":    histogramHandler,
+}
+
 // Обработчик запроса поиска
 func SearchServer(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("AccessToken") != accessToken {
+	received := time.Now()
+	r = withRequestID(w, r)
+	defer logSlowRequest(r, received)
+
+	if r.Method == http.MethodHead {
+		w = headWriter{w}
+	}
+	cw := &cacheWriter{ResponseWriter: w}
+	w = cw
+	defer func() { logRequest(r, cw.status, received) }()
+
+	if RequireHTTPS && r.TLS == nil {
+		sendError(w, http.StatusUpgradeRequired, "https required")
+		return
+	}
+
+	// /status нужен мониторингу без токена
+	if r.URL.Path == "/status" {
+		statusHandler(w, r)
+		return
+	}
+
+	if !serverConfig(r).tokenAllowed(r.Header.Get("AccessToken")) {
 		http.Error(w, "Invalid AccessToken", http.StatusUnauthorized)
 		return
 	}
 
-	xmlFile, err := os.Open(fileName)
+	if r.ContentLength > MaxBodyBytes {
+		sendError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBodyBytes)
+
+	if handler, ok := routes[r.URL.Path]; ok {
+		handler(w, r)
+		return
+	}
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if name, ok := strings.CutPrefix(r.URL.Path, namedPrefix); ok {
+		named, found := namedRequest(r, name)
+		if !found {
+			sendError(w, http.StatusNotFound, ErrorUnknownNamedQuery)
+			return
+		}
+		r = named
+	}
+
+	w.Header().Set("X-Server-Version", Version)
+	cw.search = true
+	result, params, ok := searchUsers(w, r, true)
+	if !ok {
 		return
 	}
-	defer xmlFile.Close()
+	setSearchModeHeaders(w, params)
 
-	var (
-		data   xmlData
-		result []User
-	)
+	if params.countOnly {
+		setWarnings(w, params)
+		sendResponse(w, countOnlyResponse{Count: len(result)})
+		return
+	}
+
+	if params.stats != "" {
+		setWarnings(w, params)
+		sendResponse(w, newStatsResponse(result, params.stats))
+		return
+	}
+
+	if params.digest {
+		// Хеш считается только по полям, которые видит токен
+		digest, err := newDigestResponse(restrictUsers(result, params.allowedFields))
+		if err != nil {
+			http.Error(w, "cant marshal json", http.StatusInternalServerError)
+			return
+		}
+		setWarnings(w, params)
+		sendResponse(w, digest)
+		return
+	}
+
+	if params.groupBy != "" {
+		setWarnings(w, params)
+		sendResponse(w, newGroupsResponse(result, params))
+		return
+	}
+
+	if EmptyOffsetIs404 && params.offset > 0 && params.offset >= len(result) {
+		sendError(w, http.StatusNotFound, "offset "+strconv.Itoa(params.offset)+" is beyond total "+strconv.Itoa(len(result)))
+		return
+	}
+	if params.offset > 0 && params.offset >= len(result) && !params.noTotal {
+		params.warn("offset " + strconv.Itoa(params.offset) + " is beyond total " + strconv.Itoa(len(result)))
+	}
+
+	params.matched = len(result)
+	if params.withLinks {
+		links := newPageLinks(r.URL, len(result), params)
+		params.links = &links
+	}
+
+	// Пагинация данных
+	start := time.Now()
+	ctx, cancel := stageContext(r.Context(), stagePaginate)
+	var page []User
+	if params.before != nil {
+		page = paginateBefore(result, params.offset, params.limit)
+	} else {
+		page = paginateData(result, params.offset, params.limit)
+	}
+	err := stageErr(ctx, stagePaginate)
+	cancel()
+	if sendStageTimeout(w, err) {
+		return
+	}
+	if params.plan != nil {
+		params.plan.stage("paginate", start, len(page))
+		sendResponse(w, params.plan)
+		return
+	}
+	setPageCursors(w, result, page, params)
+	annotateUsers(page, params)
+	setWarnings(w, params)
+	// Отправка результата
+	sendUsers(w, page, params)
+}
+
+// Чтение и разбор xml-файла с данными
+func readData(path string) (xmlData, error) {
+	var data xmlData
+
+	xmlFile, err := os.Open(path)
+	if err != nil {
+		return data, err
+	}
+	defer xmlFile.Close()
 
 	b, err := io.ReadAll(xmlFile)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return data, err
 	}
 	err = xml.Unmarshal(b, &data)
+	if err == nil && XMLRootName != "" && data.XMLName.Local != XMLRootName {
+		return xmlData{}, fmt.Errorf("expected element type <%s> but have <%s>", XMLRootName, data.XMLName.Local)
+	}
+	for idx := range data.Rows {
+		data.Rows[idx].prepare()
+	}
+	if err == nil {
+		data.Rows = checkAges(data.Rows, AgeOutOfRange)
+		data.Rows, err = dedupRows(data.Rows, DuplicateIDs)
+	}
+	return data, err
+}
+
+// prepare приводит только что прочитанную строку к виду для поиска
+func (r *row) prepare() {
+	if TrimXMLText {
+		r.trimSpace()
+	}
+	for idx := range r.Abouts {
+		r.Abouts[idx].joinParagraphs()
+	}
+	r.Gender = normalizeGender(r.Gender)
+}
+
+// TrimXMLText убирает пробелы и переводы строк по краям текстовых полей
+// строк при чтении данных, которые появляются при форматировании XML с
+// отступами. false - поля остаются как в файле
+var TrimXMLText = true
+
+// trimSpace убирает пробельные символы по краям текстовых полей строки
+func (r *row) trimSpace() {
+	for _, field := range []*string{&r.FirstName, &r.LastName, &r.Gender, &r.City, &r.Country,
+		&r.Handle, &r.Email, &r.Phone, &r.Birthday, &r.UpdatedAt} {
+		*field = strings.TrimSpace(*field)
+	}
+	for idx := range r.Abouts {
+		r.Abouts[idx].Text = strings.TrimSpace(r.Abouts[idx].Text)
+		for pIdx, paragraph := range r.Abouts[idx].Paragraphs {
+			r.Abouts[idx].Paragraphs[pIdx] = strings.TrimSpace(paragraph)
+		}
+	}
+}
+
+// Допустимый диапазон возраста в данных, включительно
+var (
+	MinAge = 0
+	MaxAge = 150
+)
+
+// Обработка возраста вне MinAge..MaxAge (AgeOutOfRange)
+const (
+	// Возраст заменяется ближайшей границей
+	AgeClamp = "clamp"
+	// Строка пропускается
+	AgeDrop = "drop"
+)
+
+// AgeOutOfRange - что делать при загрузке со строками, где возраст вне
+// MinAge..MaxAge, например 999 из-за опечатки
+var AgeOutOfRange = AgeClamp
+
+// checkAges применяет mode к строкам с возрастом вне MinAge..MaxAge
+func checkAges(rows []row, mode string) []row {
+	result := rows[:0]
+	for _, row := range rows {
+		if checkAge(&row, mode) {
+			result = append(result, row)
+		}
+	}
+	return result
+}
+
+// checkAge применяет mode к строке, false - строку нужно пропустить
+func checkAge(r *row, mode string) bool {
+	if r.Age < MinAge || r.Age > MaxAge {
+		if mode == AgeDrop {
+			return false
+		}
+		r.Age = min(max(r.Age, MinAge), MaxAge)
+	}
+	return true
+}
+
+// Обработка повторяющихся ID в данных (DuplicateIDs)
+const (
+	// Повторы остаются как есть
+	DuplicateIDsAllow = ""
+	// Загрузка данных завершается ошибкой
+	DuplicateIDsError = "error"
+	// Остается первая строка с таким ID
+	DuplicateIDsKeepFirst = "first"
+	// Остается последняя строка с таким ID
+	DuplicateIDsKeepLast = "last"
+)
+
+// DuplicateIDs - что делать со строками с одинаковым ID при загрузке данных
+var DuplicateIDs = DuplicateIDsAllow
+
+// dedupRows применяет mode к строкам с повторяющимися ID. Порядок
+// оставшихся строк сохраняется
+func dedupRows(rows []row, mode string) ([]row, error) {
+	if mode == DuplicateIDsAllow {
+		return rows, nil
+	}
+
+	if mode != DuplicateIDsError && mode != DuplicateIDsKeepFirst && mode != DuplicateIDsKeepLast {
+		return nil, fmt.Errorf("unknown DuplicateIDs mode %q", mode)
+	}
+
+	last := make(map[int]int, len(rows))
+	for idx, row := range rows {
+		if _, ok := last[row.ID]; ok && mode == DuplicateIDsError {
+			return nil, fmt.Errorf("duplicate id %d in data", row.ID)
+		}
+		last[row.ID] = idx
+	}
+	if mode == DuplicateIDsError || len(last) == len(rows) {
+		return rows, nil
+	}
+
+	result := make([]row, 0, len(last))
+	kept := make(map[int]bool, len(last))
+	for idx, row := range rows {
+		if mode == DuplicateIDsKeepFirst && kept[row.ID] || mode == DuplicateIDsKeepLast && last[row.ID] != idx {
+			continue
+		}
+		kept[row.ID] = true
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+// searchUsers загружает данные, разбирает параметры запроса, фильтрует и
+// сортирует пользователей. При paged результат нужен только для страницы
+// offset/limit, и фильтрация может закончиться раньше, см. filterStopAfter.
+// При ошибке ответ уже отправлен и ok == false
+func searchUsers(w http.ResponseWriter, r *http.Request, paged bool) (result []User, params *queryDTO, ok bool) {
+	cfg := serverConfig(r)
+	cacheHit := datasetCached(cfg.FileName)
+	var ds *dataset
+	var modTime time.Time
+	var err error
+	// no_cache=1: файл читается заново только для этого запроса, общий кеш
+	// не меняется. Запрос уже прошел проверку AccessToken. Читается файл
+	// после разбора параметров: страницу без сортировки можно найти, не
+	// загружая его целиком, см. streamFilterData
+	noCache := !CacheDataset || flagParam(r.URL.Query().Get("no_cache"))
+	if noCache {
+		cacheHit = false
+		var info os.FileInfo
+		if info, err = os.Stat(cfg.FileName); err == nil {
+			modTime = info.ModTime()
+		}
+	} else if ds, err = getDataset(cfg.FileName); err == nil {
+		modTime = ds.modTime
+		if ds.stale {
+			w.Header().Set("Warning", staleWarning(ds))
+		}
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, nil, false
 	}
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
 
 	// Парсинг параметров запроса
-	params := &queryDTO{}
+	ctx, cancel := stageContext(r.Context(), stageParse)
+	params = &queryDTO{}
 	err = params.parseParams(r)
+	if err == nil {
+		err = stageErr(ctx, stageParse)
+	}
+	cancel()
 	if err != nil {
+		if sendStageTimeout(w, err) {
+			return nil, nil, false
+		}
+		var pErr *paramError
+		if errors.As(err, &pErr) {
+			sendError(w, http.StatusBadRequest, pErr.Error())
+			return nil, nil, false
+		}
+		if errors.Is(err, errNotAcceptable) {
+			sendError(w, http.StatusNotAcceptable, err.Error())
+			return nil, nil, false
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	stopAfter := 0
+	if paged {
+		stopAfter = filterStopAfter(params)
+	}
+	if noCache && canStreamFilter(params) {
+		return streamSearch(w, r, cfg.FileName, params, stopAfter)
+	}
+	if noCache {
+		if ds, err = loadDataset(cfg.FileName); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, nil, false
+		}
+	}
+	data := ds.data
+
+	if params.explainPlan {
+		params.plan = newQueryPlan(cacheHit, len(data.Rows))
+	}
+	params.totalRows = len(data.Rows)
+	// Для order_ids и since_id строки берутся по индексу, остальные фильтры
+	// применяются к ним
+	rows := data
+	switch {
+	case len(params.orderIDs) > 0:
+		rows = ds.rowsByID(params.orderIDs)
+	case params.sinceID != nil:
+		rows = ds.rowsSinceID(*params.sinceID)
 	}
+	if params.plan != nil && (len(params.orderIDs) > 0 || params.sinceID != nil) {
+		params.plan.IndexUsed = true
+	}
+
 	// Фильтрация данных
-	result = filterData(data, params.query)
+	start := time.Now()
+	ctx, cancel = stageContext(r.Context(), stageFilter)
+	result, scanned, err := filterData(ctx, rows, params, stopAfter)
+	cancel()
+	if sendStageTimeout(w, err) {
+		return nil, nil, false
+	}
+	if params.distinctNames {
+		result = distinctNames(result)
+	}
+	params.plan.stage("filter", start, len(result))
+	if params.plan != nil {
+		params.plan.Rows["scan"] = scanned
+		params.plan.EarlyExit = scanned < len(rows.Rows)
+	}
+	// Сколько строк просмотрено и сколько из них подошло под запрос
+	w.Header().Set("X-Rows-Scanned", strconv.Itoa(scanned))
+	// Досрочная остановка бывает только с no_total=1, тогда общее число
+	// найденных неизвестно
+	if scanned == len(rows.Rows) && !params.noTotal {
+		w.Header().Set("X-Rows-Matched", strconv.Itoa(len(result)))
+	}
 
-	if params.orderBy != OrderByAsIs {
+	start = time.Now()
+	if len(params.orderIDs) > 0 {
+		result = usersInIDOrder(result, params.orderIDs)
+	} else if params.orderBy != OrderByAsIs && !params.countOnly {
+		if !cfg.AllowSort {
+			sendError(w, http.StatusBadRequest, ErrorSortDisabled)
+			return nil, nil, false
+		}
 		// Сортировка данных
-		sortedData, err := sortData(result, params.orderField, params.orderBy)
+		ctx, cancel := stageContext(r.Context(), stageSort)
+		sortedData, err := sortData(ctx, result, params)
+		cancel()
+		if sendStageTimeout(w, err) {
+			return nil, nil, false
+		}
 		if err != nil {
-			// В случае отпраляется ответ с ошибкой
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			jsonStr := `{ "Error": "` + err.Error() + `" }`
-			_, err = w.Write([]byte(jsonStr))
-			if err != nil {
-				http.Error(w, "cant write json", http.StatusInternalServerError)
-			}
-			return
+			// В случае ошибки отправляется ответ с ошибкой
+			sendError(w, http.StatusBadRequest, err.Error())
+			return nil, nil, false
 		}
 		result = sortedData
+
+		if params.after != nil {
+			isLess, _ := userLess(params) //nolint:errcheck
+			result = resultAfter(result, *params.after, isLess)
+		}
+		if params.before != nil {
+			isLess, _ := userLess(params) //nolint:errcheck
+			result = resultBefore(result, *params.before, isLess)
+		}
+		if params.tieSeed != nil {
+			compare, _ := orderCompare(params) //nolint:errcheck
+			shuffleTies(result, *params.tieSeed, compare)
+		}
 	}
 
-	// Пагинация данных
-	result = paginateData(result, params.offset, params.limit)
-	// Отправка результата
-	sendResponse(w, result)
-}
+	if params.random {
+		shuffleUsers(result, params.seed)
+	}
+	params.plan.stage("sort", start, len(result))
 
+	return result, params, true
+}
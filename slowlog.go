@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// SlowRequestThreshold - запросы дольше этого времени пишутся в лог с уровнем
+// Warn вместе с путем, параметрами и временем обработки. 0 - не писать
+var SlowRequestThreshold = time.Second
+
+// logSlowRequest пишет запрос r в лог, если с start прошло больше SlowRequestThreshold
+func logSlowRequest(r *http.Request, start time.Time) {
+	elapsed := time.Since(start)
+	if SlowRequestThreshold <= 0 || elapsed <= SlowRequestThreshold {
+		return
+	}
+	slog.Warn("slow request",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"params", r.URL.RawQuery,
+		"elapsed", elapsed,
+		"request_id", requestID(r.Context()),
+	)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowRequestLog(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	searchRecorder("query=Boyd")
+	if buf.Len() != 0 {
+		t.Errorf("Unexpected log: %s", buf.String())
+	}
+
+	SlowRequestThreshold = time.Nanosecond
+	defer func() { SlowRequestThreshold = time.Second }()
+
+	searchRecorder("query=Boyd")
+	line := buf.String()
+	for _, expected := range []string{"level=WARN", `msg="slow request"`, `params="query=Boyd"`, "elapsed="} {
+		if !strings.Contains(line, expected) {
+			t.Errorf("Expected %s in log, got: %s", expected, line)
+		}
+	}
+
+	buf.Reset()
+	SlowRequestThreshold = 0
+	searchRecorder("query=Boyd")
+	if buf.Len() != 0 {
+		t.Errorf("Unexpected log with disabled threshold: %s", buf.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	var observed []RequestInfo
+	AccessLog = true
+	RequestObserver = func(info RequestInfo) { observed = append(observed, info) }
+	defer func() { AccessLog, RequestObserver = false, nil }()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/?query=Boyd", nil)
+	req.Header.Set("AccessToken", accessToken)
+	req.Header.Set("X-Request-ID", "trace-42")
+	SearchServer(w, req)
+
+	if id := w.Header().Get("X-Request-ID"); id != "trace-42" {
+		t.Errorf("Expected: trace-42, got: %s", id)
+	}
+	for _, expected := range []string{`msg=request`, "request_id=trace-42", "status=200", "path=/"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected %s in log, got: %s", expected, buf.String())
+		}
+	}
+	if len(observed) != 1 || observed[0].RequestID != "trace-42" || observed[0].Status != http.StatusOK {
+		t.Errorf("Unexpected observed requests: %+v", observed)
+	}
+
+	// Без заголовка идентификатор создается и тоже попадает в лог
+	buf.Reset()
+	w = searchRecorder("limit=-1")
+	id := w.Header().Get("X-Request-ID")
+	if len(id) != 32 || !strings.Contains(buf.String(), "request_id="+id) || !strings.Contains(buf.String(), "status=400") {
+		t.Errorf("Expected generated id %q in log, got: %s", id, buf.String())
+	}
+	if len(observed) != 2 || observed[1].RequestID != id {
+		t.Errorf("Unexpected observed requests: %+v", observed)
+	}
+
+	// Медленный запрос пишется с тем же идентификатором
+	buf.Reset()
+	AccessLog = false
+	SlowRequestThreshold = time.Nanosecond
+	defer func() { SlowRequestThreshold = time.Second }()
+	SearchServer(httptest.NewRecorder(), req)
+	if !strings.Contains(buf.String(), `msg="slow request"`) || !strings.Contains(buf.String(), "request_id=trace-42") {
+		t.Errorf("Expected request_id in slow request log, got: %s", buf.String())
+	}
+}
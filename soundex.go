@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Коды букв для Soundex, гласные и h, w, y кода не имеют
+var soundexCodes = map[rune]byte{
+	'b': '1', 'f': '1', 'p': '1', 'v': '1',
+	'c': '2', 'g': '2', 'j': '2', 'k': '2', 'q': '2', 's': '2', 'x': '2', 'z': '2',
+	'd': '3', 't': '3',
+	'l': '4',
+	'm': '5', 'n': '5',
+	'r': '6',
+}
+
+// soundex вычисляет классический американский Soundex-код слова,
+// например "R163" для "Robert" и "Rupert". Символы кроме латинских букв
+// пропускаются, для слова без букв возвращается пустая строка
+func soundex(word string) string {
+	code := make([]byte, 0, 4)
+	var last byte
+
+	for _, r := range strings.ToLower(word) {
+		if r < 'a' || r > 'z' {
+			continue
+		}
+		digit, ok := soundexCodes[r]
+
+		if len(code) == 0 {
+			code = append(code, byte(unicode.ToUpper(r)))
+			last = digit
+			continue
+		}
+
+		switch {
+		case !ok && r != 'h' && r != 'w':
+			// Гласная разделяет одинаковые коды
+			last = 0
+		case ok && digit != last:
+			code = append(code, digit)
+			last = digit
+		}
+
+		if len(code) == 4 {
+			break
+		}
+	}
+
+	if len(code) == 0 {
+		return ""
+	}
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+	return string(code)
+}
+
+// soundexMatch проверяет, что одно из имен звучит так же, как query
+func soundexMatch(query string, names ...string) bool {
+	queryCode := soundex(query)
+	if queryCode == "" {
+		return false
+	}
+
+	for _, name := range names {
+		if soundex(name) == queryCode {
+			return true
+		}
+	}
+	return false
+}
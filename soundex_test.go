@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSoundex(t *testing.T) {
+	cases := map[string]string{
+		"Robert":   "R163",
+		"Rupert":   "R163",
+		"Rubin":    "R150",
+		"Ashcraft": "A261",
+		"Tymczak":  "T522",
+		"Pfister":  "P236",
+		"Honeyman": "H555",
+		"Lee":      "L000",
+		"":         "",
+		"123":      "",
+	}
+
+	for word, expected := range cases {
+		if code := soundex(word); code != expected {
+			t.Errorf("Expected: %s for %q, got: %s", expected, word, code)
+		}
+	}
+}
+
+func TestMatchModeSoundex(t *testing.T) {
+	fileName = "testdata/soundex.xml"
+	defer func() { fileName = "dataset.xml" }()
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{"Smyth", []string{"John Smith", "Anna Smythe"}},
+		{"Rupert", []string{"Robert Fischer"}},
+		{"Fisher", []string{"Robert Fischer"}},
+		{"Jon", []string{"John Smith", "Joan Ashcroft"}},
+		{"Ashcraft", []string{"Joan Ashcroft"}},
+		{"Miller", []string{}},
+	}
+
+	for _, c := range cases {
+		users := decodeUsers(t, searchRecorder("match_mode=soundex&query="+c.query))
+		if len(users) != len(c.expected) {
+			t.Errorf("Expected: %v for %s, got: %v", c.expected, c.query, users)
+			continue
+		}
+		for idx, user := range users {
+			if user.Name != c.expected[idx] {
+				t.Errorf("Expected: %v for %s, got: %v", c.expected[idx], c.query, user.Name)
+			}
+		}
+	}
+}
+
+func TestBadMatchMode(t *testing.T) {
+	w := searchRecorder("match_mode=random&query=Boyd")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected: %d, got: %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Этапы поиска, для которых можно задать StageTimeouts
+const (
+	stageParse    = "parse"
+	stageFilter   = "filter"
+	stageSort     = "sort"
+	stagePaginate = "paginate"
+)
+
+// StageTimeouts ограничивает время этапов поиска для диагностики медленных
+// запросов: {"sort": 50 * time.Millisecond}. Запрос, этап которого не уложился,
+// получает 503 с именем этапа. Этапы без записи не ограничиваются
+var StageTimeouts = map[string]time.Duration{}
+
+// Как часто filterData и sortData проверяют, не истекло ли время этапа
+const stageCheckEvery = 256
+
+// stageTimeoutError - этап stage не уложился в StageTimeouts
+type stageTimeoutError struct {
+	stage string
+}
+
+func (e *stageTimeoutError) Error() string {
+	return "stage " + e.stage + " timed out"
+}
+
+// stageContext возвращает контекст этапа stage с ограничением из
+// StageTimeouts, а без ограничения - ctx как есть
+func stageContext(ctx context.Context, stage string) (context.Context, context.CancelFunc) {
+	if timeout := StageTimeouts[stage]; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return ctx, func() {}
+}
+
+// sendStageTimeout отправляет 503, если err - stageTimeoutError
+func sendStageTimeout(w http.ResponseWriter, err error) bool {
+	var stageErr *stageTimeoutError
+	if !errors.As(err, &stageErr) {
+		return false
+	}
+	sendUnavailable(w, stageErr.Error())
+	return true
+}
+
+// stageErr возвращает stageTimeoutError, если контекст этапа уже завершен
+func stageErr(ctx context.Context, stage string) error {
+	if ctx.Err() != nil {
+		return &stageTimeoutError{stage}
+	}
+	return nil
+}
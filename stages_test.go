@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStageTimeouts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "generated.xml")
+	if err := os.WriteFile(path, GenerateDataset(50_000, 1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fileName = path
+	defer func() { fileName = "dataset.xml" }()
+
+	StageTimeouts = map[string]time.Duration{stageSort: time.Microsecond}
+	defer func() { StageTimeouts = map[string]time.Duration{} }()
+
+	w := searchRecorder("order_field=name&order_by=1&limit=10")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "stage sort timed out") {
+		t.Errorf("Expected %d with sort stage, got: %d %s", http.StatusServiceUnavailable, w.Code, w.Body.String())
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("Expected Retry-After: 1, got: %q", retryAfter)
+	}
+
+	ts := newTestServer(accessToken)
+	defer ts.Close()
+	_, err := ts.client.FindUsers(SearchRequest{OrderField: "name", OrderBy: OrderByAsc, Limit: 10})
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) || unavailable.RetryAfter != time.Second || !strings.Contains(unavailable.Message, "sort") {
+		t.Errorf("Expected UnavailableError with retry after 1s, got: %v", err)
+	}
+
+	// Без сортировки ограничение этапа sort не мешает
+	if w = searchRecorder("limit=10"); w.Code != http.StatusOK {
+		t.Errorf("Expected: %d, got: %d %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	StageTimeouts = map[string]time.Duration{stageFilter: time.Nanosecond}
+	w = searchRecorder("query=nulla&limit=0")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "stage filter timed out") {
+		t.Errorf("Expected %d with filter stage, got: %d %s", http.StatusServiceUnavailable, w.Code, w.Body.String())
+	}
+
+	StageTimeouts = map[string]time.Duration{}
+	if w = searchRecorder("order_field=name&order_by=1&limit=10"); w.Code != http.StatusOK {
+		t.Errorf("Expected: %d, got: %d %s", http.StatusOK, w.Code, w.Body.String())
+	}
+}
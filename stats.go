@@ -0,0 +1,49 @@
+package main
+
+import (
+	"slices"
+)
+
+// ErrorBadStats отдается, если stats задан для нечислового поля
+const ErrorBadStats = `stats field must be numeric`
+
+// Числовые поля, по которым считается stats, значения берутся из sortKey
+var statsFields = []string{"age", "id", "about_words", "about_len"}
+
+// Ответ в режиме stats=<поле>: статистика по полю вместо пользователей.
+// Для пустой выборки все, кроме count, null
+type statsResponse struct {
+	Min    *float64 `json:"min"`
+	Max    *float64 `json:"max"`
+	Avg    *float64 `json:"avg"`
+	Median *float64 `json:"median"`
+	Count  int      `json:"count"`
+}
+
+// newStatsResponse считает статистику поля field по всей выборке до пагинации.
+// Медиана при четном числе значений - среднее двух средних
+func newStatsResponse(users []User, field string) statsResponse {
+	values := make([]float64, len(users))
+	for idx, user := range users {
+		values[idx] = float64(sortKey(user, field).(int))
+	}
+
+	result := statsResponse{Count: len(values)}
+	if len(values) == 0 {
+		return result
+	}
+	slices.Sort(values)
+
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	low, high := values[0], values[len(values)-1]
+	avg := sum / float64(len(values))
+	median := values[len(values)/2]
+	if len(values)%2 == 0 {
+		median = (values[len(values)/2-1] + median) / 2
+	}
+	result.Min, result.Max, result.Avg, result.Median = &low, &high, &avg, &median
+	return result
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func decodeStats(t *testing.T, query string) statsResponse {
+	t.Helper()
+	w := searchRecorder(query)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d (%s)", http.StatusOK, w.Code, w.Body.String())
+	}
+	result := statsResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid stats: %v", err)
+	}
+	return result
+}
+
+func TestStats(t *testing.T) {
+	cases := []struct {
+		query                 string
+		count                 int
+		min, max, avg, median float64
+	}{
+		// 35 возрастов от 21 до 40 с суммой 1079, медиана - 18-й
+		{"stats=age", 35, 21, 40, 1079.0 / 35, 32},
+		// 24 мужчины, сумма 1079-355, медиана - среднее 12-го и 13-го (30 и 30)
+		{"stats=age&gender=male", 24, 21, 40, 724.0 / 24, 30},
+		// limit не влияет на статистику
+		{"stats=age&gender=female&limit=1", 11, 21, 40, 355.0 / 11, 34},
+	}
+	for _, c := range cases {
+		stats := decodeStats(t, c.query)
+		if stats.Count != c.count || stats.Min == nil || *stats.Min != c.min || *stats.Max != c.max ||
+			math.Abs(*stats.Avg-c.avg) > 1e-9 || *stats.Median != c.median {
+			t.Errorf("Unexpected stats for %s: %+v", c.query, stats)
+		}
+	}
+
+	if stats := decodeStats(t, "stats=age&query=nobody-here"); stats.Count != 0 || stats.Min != nil || stats.Median != nil {
+		t.Errorf("Expected empty stats, got: %+v", stats)
+	}
+
+	for _, query := range []string{"stats=name", "stats=gender", "stats=unknown"} {
+		if w := searchRecorder(query); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected: %d for %s, got: %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
+
+func TestStatsMedian(t *testing.T) {
+	users := func(ages ...int) []User {
+		result := make([]User, len(ages))
+		for idx, age := range ages {
+			result[idx] = User{Age: age}
+		}
+		return result
+	}
+
+	if stats := newStatsResponse(users(4, 1, 3, 2), "age"); *stats.Median != 2.5 {
+		t.Errorf("Expected: 2.5, got: %v", *stats.Median)
+	}
+	if stats := newStatsResponse(users(5, 1, 3), "age"); *stats.Median != 3 {
+		t.Errorf("Expected: 3, got: %v", *stats.Median)
+	}
+	if stats := newStatsResponse(users(7), "age"); *stats.Median != 7 || *stats.Avg != 7 {
+		t.Errorf("Expected: 7, got: %+v", stats)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Ответ /status
+type statusResponse struct {
+	Rows     int        `json:"rows"`
+	LoadedAt *time.Time `json:"loaded_at"`
+	File     string     `json:"file"`
+	// warm - данные fileName загружены в кеш, cold - еще нет
+	Cache string `json:"cache"`
+	// Последняя перезагрузка не удалась, отдаются старые данные
+	Stale bool `json:"stale,omitempty"`
+}
+
+// statusHandler отдает состояние данных для мониторинга без AccessToken.
+// Данные не загружаются: до первого поиска кеш cold, rows 0 и loaded_at null
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	path := serverConfig(r).FileName
+	resp := statusResponse{File: path, Cache: "cold"}
+
+	if ds := cachedDataset(path); ds != nil {
+		loadedAt := ds.loadedAt.UTC()
+		resp.Rows, resp.LoadedAt, resp.Cache, resp.Stale = len(ds.data.Rows), &loadedAt, "warm", ds.stale
+	}
+	sendResponse(w, resp)
+}
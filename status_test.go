@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func statusRecorder(t *testing.T) statusResponse {
+	t.Helper()
+	// Без AccessToken
+	req := httptest.NewRequest("GET", "/status", nil)
+	w := httptest.NewRecorder()
+	SearchServer(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d, got: %d", http.StatusOK, w.Code)
+	}
+
+	result := statusResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid status: %v", err)
+	}
+	return result
+}
+
+func TestStatus(t *testing.T) {
+	path := useTempDataset(t)
+
+	status := statusRecorder(t)
+	if status.Cache != "cold" || status.Rows != 0 || status.LoadedAt != nil || status.File != path {
+		t.Errorf("Expected cold cache for %s, got: %+v", path, status)
+	}
+
+	b, _ := os.ReadFile(path) //nolint:errcheck
+	rows := strings.Count(string(b), "<row>")
+	decodeUsers(t, searchRecorder("limit=1"))
+
+	status = statusRecorder(t)
+	if status.Cache != "warm" || status.Rows != rows || status.LoadedAt == nil {
+		t.Errorf("Expected warm cache with %d rows, got: %+v", rows, status)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// canStreamFilter - запрос можно выполнить потоковым чтением файла через
+// streamFilterData. Нужен запрос без сортировки и перемешивания, без
+// order_ids, since_id и explain, и DuplicateIDs, который не требует всего файла.
+// С no_total=1 чтение заканчивается на последней строке страницы, иначе
+// файл читается целиком, но в памяти остаются только найденные
+func canStreamFilter(params *queryDTO) bool {
+	return params.orderBy == OrderByAsIs && !params.random && len(params.orderIDs) == 0 && params.sinceID == nil && !params.explainPlan &&
+		(DuplicateIDs == DuplicateIDsAllow || DuplicateIDs == DuplicateIDsKeepFirst)
+}
+
+// streamSearch - searchUsers для запроса без сортировки, когда кеш данных не
+// используется (no_cache=1 или CacheDataset = false): строки фильтруются
+// прямо при чтении файла
+func streamSearch(w http.ResponseWriter, r *http.Request, path string, params *queryDTO, stopAfter int) ([]User, *queryDTO, bool) {
+	ctx, cancel := stageContext(r.Context(), stageFilter)
+	result, scanned, complete, err := streamFilterData(ctx, path, params, stopAfter)
+	cancel()
+	if sendStageTimeout(w, err) {
+		return nil, nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	if params.distinctNames {
+		result = distinctNames(result)
+	}
+	w.Header().Set("X-Rows-Scanned", strconv.Itoa(scanned))
+	if complete {
+		params.totalRows = scanned
+		if !params.noTotal {
+			w.Header().Set("X-Rows-Matched", strconv.Itoa(len(result)))
+		}
+	}
+	return result, params, true
+}
+
+// streamFilterData читает файл path и фильтрует строки по мере разбора, не
+// собирая xmlData: в памяти остаются только найденные пользователи. Результат
+// тот же, что у readData и filterData со stopAfter, кроме ошибок в части
+// файла после досрочной остановки - она не читается. complete - файл
+// просмотрен до конца, тогда scanned - число строк в данных
+func streamFilterData(ctx context.Context, path string, params *queryDTO, stopAfter int) (result []User, scanned int, complete bool, err error) {
+	xmlFile, err := os.Open(path)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer xmlFile.Close()
+
+	result = make([]User, 0)
+	seen := map[int]bool{}
+	decoder := xml.NewDecoder(xmlFile)
+	depth, root := 0, false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF && root {
+			break
+		}
+		if err != nil {
+			return nil, scanned, false, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				root = true
+				if XMLRootName != "" && t.Name.Local != XMLRootName {
+					return nil, 0, false, fmt.Errorf("expected element type <%s> but have <%s>", XMLRootName, t.Name.Local)
+				}
+				continue
+			}
+			if depth != 2 || t.Name.Local != "row" {
+				if err := decoder.Skip(); err != nil {
+					return nil, scanned, false, err
+				}
+				depth--
+				continue
+			}
+
+			var r row
+			if err := decoder.DecodeElement(&r, &t); err != nil {
+				return nil, scanned, false, err
+			}
+			depth--
+			r.prepare()
+			if !checkAge(&r, AgeOutOfRange) {
+				continue
+			}
+			if DuplicateIDs == DuplicateIDsKeepFirst {
+				if seen[r.ID] {
+					continue
+				}
+				seen[r.ID] = true
+			}
+
+			if stopAfter > 0 && len(result) == stopAfter {
+				return result, scanned, false, nil
+			}
+			if scanned%stageCheckEvery == 0 {
+				if err := stageErr(ctx, stageFilter); err != nil {
+					return nil, scanned, false, err
+				}
+			}
+			scanned++
+			if params.matchNothing {
+				continue
+			}
+			if user, ok := matchRow(r, params); ok {
+				result = append(result, user)
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return result, scanned, true, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// streamParams разбирает параметры запроса query
+func streamParams(t testing.TB, query string) *queryDTO {
+	t.Helper()
+	params := &queryDTO{}
+	if err := params.parseParams(httptest.NewRequest("GET", "/?"+query, nil)); err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	return params
+}
+
+func TestStreamFilterData(t *testing.T) {
+	defer func() { DuplicateIDs = DuplicateIDsAllow }()
+	defer func() { AgeOutOfRange = AgeClamp }()
+
+	cases := []struct {
+		path, query string
+		duplicates  string
+		ages        string
+	}{
+		{"dataset.xml", "no_total=1&limit=1", DuplicateIDsAllow, AgeClamp},
+		{"dataset.xml", "no_total=1&limit=5&offset=3&query=e", DuplicateIDsAllow, AgeClamp},
+		{"dataset.xml", "no_total=1&limit=100&gender=female", DuplicateIDsAllow, AgeClamp},
+		{"dataset.xml", "no_total=1&limit=5&query=nosuchword", DuplicateIDsAllow, AgeClamp},
+		// Без no_total файл читается целиком
+		{"dataset.xml", "limit=5&query=e", DuplicateIDsAllow, AgeClamp},
+		{"dataset.xml", "limit=0&gender=male", DuplicateIDsAllow, AgeClamp},
+		{"testdata/duplicates.xml", "no_total=1&limit=5", DuplicateIDsKeepFirst, AgeClamp},
+		{"testdata/ages.xml", "no_total=1&limit=5", DuplicateIDsAllow, AgeDrop},
+		{"testdata/empty.xml", "no_total=1&limit=5", DuplicateIDsAllow, AgeClamp},
+	}
+	for _, c := range cases {
+		DuplicateIDs, AgeOutOfRange = c.duplicates, c.ages
+		params := streamParams(t, c.query)
+		stopAfter := filterStopAfter(params)
+		if !canStreamFilter(params) {
+			t.Fatalf("%s: expected streaming", c.query)
+		}
+
+		data, err := readData(c.path)
+		if err != nil {
+			t.Fatalf("Invalid error: %v", err)
+		}
+		expected, expectedScanned, _ := filterData(context.Background(), data, params, stopAfter) //nolint:errcheck
+
+		users, scanned, complete, err := streamFilterData(context.Background(), c.path, params, stopAfter)
+		if err != nil {
+			t.Fatalf("Invalid error: %v", err)
+		}
+		if !reflect.DeepEqual(users, expected) || scanned != expectedScanned || complete != (scanned == len(data.Rows)) {
+			t.Errorf("%s %s: expected %v, %d scanned, got: %v, %d scanned, complete %v",
+				c.path, c.query, userIDs(expected), expectedScanned, userIDs(users), scanned, complete)
+		}
+	}
+
+	if _, _, _, err := streamFilterData(context.Background(), "testdata/users_root.xml", streamParams(t, "limit=1"), 1); err == nil {
+		t.Errorf("Expected error for wrong root element")
+	}
+	if params := streamParams(t, "limit=5&order_by=random"); canStreamFilter(params) {
+		t.Errorf("Expected no streaming with random order")
+	}
+	if params := streamParams(t, "no_total=1&limit=5&order_field=id&order_by=1"); canStreamFilter(params) {
+		t.Errorf("Expected no streaming with sorting")
+	}
+}
+
+func TestStreamSearch(t *testing.T) {
+	for _, query := range []string{
+		"no_total=1&limit=2&query=e", "no_total=1&limit=50&gender=male", "no_total=1&limit=5&query=Boyd&fields=id,name",
+		"limit=3&offset=2&query=e", "distinct_names=1&limit=0", "count_only=1&gender=female",
+	} {
+		cached := searchRecorder(query)
+		streamed := searchRecorder(query + "&no_cache=1")
+		if streamed.Body.String() != cached.Body.String() {
+			t.Errorf("%s: expected: %s, got: %s", query, cached.Body.String(), streamed.Body.String())
+		}
+		for _, header := range []string{"X-Rows-Scanned", "X-Rows-Matched", "Last-Modified"} {
+			if streamed.Header().Get(header) != cached.Header().Get(header) {
+				t.Errorf("%s: expected %s: %q, got: %q", query, header, cached.Header().Get(header), streamed.Header().Get(header))
+			}
+		}
+	}
+}
+
+func TestCacheDatasetDisabled(t *testing.T) {
+	path := useTempDataset(t)
+	CacheDataset = false
+	defer func() { CacheDataset = true }()
+
+	for _, query := range []string{"query=Boyd", "query=Boyd&order_field=age&order_by=1"} {
+		if users := decodeUsers(t, searchRecorder(query)); len(users) != 1 || users[0].ID != 0 {
+			t.Errorf("%s: expected Boyd, got: %v", query, userIDs(users))
+		}
+	}
+	if cachedDataset(path) != nil {
+		t.Errorf("Expected no cached dataset with CacheDataset = false")
+	}
+}
+
+func TestStreamFilterDataAllocs(t *testing.T) {
+	params := streamParams(t, "no_total=1&limit=1&query=e")
+	stopAfter := filterStopAfter(params)
+
+	twoPass := testing.AllocsPerRun(10, func() {
+		data, _ := readData(fileName)                             //nolint:errcheck
+		filterData(context.Background(), data, params, stopAfter) //nolint:errcheck
+	})
+	fused := testing.AllocsPerRun(10, func() {
+		streamFilterData(context.Background(), fileName, params, stopAfter) //nolint:errcheck
+	})
+	if fused >= twoPass {
+		t.Errorf("Expected fewer allocations than %v, got: %v", twoPass, fused)
+	}
+}
+
+func BenchmarkStreamFilterData(b *testing.B) {
+	params := streamParams(b, "no_total=1&limit=5&query=e")
+	stopAfter := filterStopAfter(params)
+
+	b.Run("two_pass", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data, _ := readData(fileName)                             //nolint:errcheck
+			filterData(context.Background(), data, params, stopAfter) //nolint:errcheck
+		}
+	})
+	b.Run("fused", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			streamFilterData(context.Background(), fileName, params, stopAfter) //nolint:errcheck
+		}
+	})
+}
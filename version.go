@@ -0,0 +1,17 @@
+package main
+
+import "net/http"
+
+// Version - версия сборки сервера, задается при сборке:
+// go build -ldflags "-X main.Version=1.2.3"
+var Version = "dev"
+
+// Ответ /version
+type versionResponse struct {
+	Version string `json:"version"`
+}
+
+// versionHandler отдает версию сборки сервера
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, versionResponse{Version: Version})
+}
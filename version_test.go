@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	Version = "1.2.3"
+	defer func() { Version = "dev" }()
+
+	w := searchRecorder("query=Boyd")
+	if actual := w.Header().Get("X-Server-Version"); actual != Version {
+		t.Errorf("Expected: %s, got: %s", Version, actual)
+	}
+
+	req := httptest.NewRequest("GET", "/version", nil)
+	req.Header.Set("AccessToken", accessToken)
+	w = httptest.NewRecorder()
+	SearchServer(w, req)
+
+	result := versionResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	if result.Version != Version {
+		t.Errorf("Expected: %s, got: %s", Version, result.Version)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	// WatchDataset включает автоматическую перезагрузку данных в RunServer
+	// при изменении fileName, без запросов к /reload
+	WatchDataset = false
+	// WatchInterval - как часто проверяется время изменения и размер файла
+	WatchInterval = time.Second
+	// WatchDebounce - сколько файл должен не меняться перед перезагрузкой,
+	// чтобы не перечитывать его несколько раз, пока он еще записывается
+	WatchDebounce = 500 * time.Millisecond
+	// WatchMTimeResolution - точность времени изменения в файловой системе.
+	// Файл, измененный не раньше этого, сравнивается еще и по содержимому:
+	// перезапись того же размера в пределах одного тика времени иначе не видна
+	WatchMTimeResolution = 2 * time.Second
+)
+
+// Состояние файла, по которому видно, что он изменился
+type fileState struct {
+	modTime time.Time
+	size    int64
+	// SHA-256 содержимого, только для недавно измененного файла
+	sum [sha256.Size]byte
+}
+
+// same - состояния не различаются. Содержимое сравнивается, только если
+// оно посчитано у обоих
+func (s fileState) same(other fileState) bool {
+	if !s.modTime.Equal(other.modTime) || s.size != other.size {
+		return false
+	}
+	var zero [sha256.Size]byte
+	return s.sum == zero || other.sum == zero || s.sum == other.sum
+}
+
+func statFile(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	state := fileState{modTime: info.ModTime(), size: info.Size()}
+	if time.Since(state.modTime) < WatchMTimeResolution {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fileState{}, err
+		}
+		state.sum = sha256.Sum256(b)
+	}
+	return state, nil
+}
+
+// watchDataset опрашивает fileName раз в WatchInterval и перезагружает
+// данные, когда файл отличается от загруженного и не меняется дольше
+// WatchDebounce. Работает до отмены ctx. Ошибки только логируются: поиск
+// продолжает работать на последних загруженных данных, а /reload остается
+// доступен. Опрос вместо событий файловой системы (fsnotify) - чтобы
+// обойтись стандартной библиотекой
+func watchDataset(ctx context.Context) {
+	path := fileName
+	var (
+		seen      fileState
+		changedAt time.Time
+		// Состояние файла, с которого загружены текущие данные
+		loaded fileState
+		// Состояние файла, который не удалось загрузить
+		failed fileState
+	)
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		state, err := statFile(path)
+		if err != nil {
+			// Файл могут заменять через удаление и создание, ждем следующей проверки
+			continue
+		}
+		if !state.same(seen) {
+			seen, changedAt = state, time.Now()
+			continue
+		}
+		if time.Since(changedAt) < WatchDebounce || state.same(failed) || state.same(loaded) {
+			continue
+		}
+
+		// Данные уже загружены из этого файла до запуска наблюдения
+		if ds := cachedDataset(path); loaded.modTime.IsZero() && ds != nil && ds.modTime.Equal(state.modTime) {
+			loaded = state
+			continue
+		}
+
+		if _, err = reloadDataset(path); err != nil {
+			// Битый файл не перечитывается, пока его снова не изменят
+			log.Printf("watch %s: reload failed: %v", path, err)
+			failed = state
+			continue
+		}
+		loaded = state
+	}
+}